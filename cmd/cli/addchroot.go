@@ -53,8 +53,8 @@ var CmdAddChroot = cli.Command{
 	},
 	Action: runAddChroot,
 	Flags: []cli.Flag{
-		cli.StringFlag{"release, r", "<release>", "release (fedora, epel, ...)", ""},
-		cli.StringFlag{"version, v", "<version>", "version (22, 23, rawhide, ...)", ""},
+		cli.StringFlag{"release, r", "<release>", "release (fedora, epel, debian, ubuntu, ...)", ""},
+		cli.StringFlag{"version, v", "<version>", "version (22, 23, rawhide, sid, xenial, ...)", ""},
 		cli.StringFlag{"arch, a", "<arch>", "architecture (i386, x86_64, armhfp, ...)", ""},
 	},
 }
@@ -61,6 +61,12 @@ var CmdAddImage = cli.Command{
 		cli.StringFlag{"descr, d", "", "image description", ""},
 		cli.StringFlag{"archs, a", "<arch1>, <arch2>, <archN>...", "supported architectures", ""},
 		cli.StringFlag{"vcs", "", "VCS (format: <url>#branch=<branch>)", ""},
+		cli.StringFlag{"kickstart, k", "", "kickstart file relative to the VCS root", ""},
+		cli.StringFlag{"format, f", "", "output format (live, raw or qcow2)", ""},
+		cli.StringFlag{"product, p", "", "product name", ""},
+		cli.StringFlag{"releasever, r", "", "release version", ""},
+		cli.StringSliceFlag{"var", &cli.StringSlice{}, "kickstart variable (format: <name>=<value>)", ""},
+		cli.DurationFlag{"timeout, t", 0, "build timeout (for example: 3h), 0 to use the slave default", ""},
 	},
 }
 
@@ -81,7 +87,17 @@ func runAddImage(ctx *cli.Context) {
 	descr := ctx.String("descr")
 	archs := ctx.String("archs")
 	vcs := ctx.String("vcs")
-	if err = client.AddImage(name, descr, archs, vcs); err != nil {
+	kickstart := ctx.String("kickstart")
+	format := ctx.String("format")
+	product := ctx.String("product")
+	releasever := ctx.String("releasever")
+	vars, err := ParseVariables(ctx.StringSlice("var"))
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+	timeout := ctx.Duration("timeout")
+	if err = client.AddImage(name, descr, archs, vcs, kickstart, format, product, releasever, vars, timeout); err != nil {
 		logging.Errorln(err)
 		return
 	}
@@ -64,6 +64,13 @@ var CmdAddPackage = cli.Command{
 		cli.BoolFlag{"ci", "continuous integration?", ""},
 		cli.StringFlag{"vcs", "<url>#branch=<branch>", "packaging VCS", ""},
 		cli.StringFlag{"upstream-vcs", "<url>#branch=<branch>", "upstream VCS (only for CI)", ""},
+		cli.StringFlag{"distro, d", "fedora", "distribution (fedora, archlinux, debian, ubuntu)", ""},
+		cli.StringFlag{"releasever", "", "distribution release (for example: sid), needed when more than one chroot is active", ""},
+		cli.StringFlag{"project, p", "", "project the package belongs to", ""},
+		cli.DurationFlag{"timeout, t", 0, "build timeout (for example: 3h), 0 to use the slave default", ""},
+		cli.StringFlag{"memory-limit", "", "memory limit (for example: 8G), empty to use the slave default", ""},
+		cli.StringFlag{"cpu-limit", "", "CPU limit in number of CPUs (for example: 2.5), empty to use the slave default", ""},
+		cli.IntFlag{"pids-limit", 0, "maximum number of processes, 0 to use the slave default", ""},
 	},
 }
 
@@ -88,7 +95,16 @@ func runAddPackage(ctx *cli.Context) {
 	if !ctx.IsSet("upstream-vcs") {
 		uvcs = ""
 	}
-	if err = client.AddPackage(name, archs, ci, vcs, uvcs); err != nil {
+	distro := ctx.String("distro")
+	releasever := ctx.String("releasever")
+	project := ctx.String("project")
+	timeout := ctx.Duration("timeout")
+	limits, err := parseLimits(ctx.String("memory-limit"), ctx.String("cpu-limit"), uint32(ctx.Int("pids-limit")))
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+	if err = client.AddPackage(name, archs, ci, vcs, uvcs, distro, releasever, project, timeout, limits); err != nil {
 		logging.Errorln(err)
 		return
 	}
@@ -45,6 +45,10 @@ var CmdBuildImage = cli.Command{
 			logging.Errorln("You must specify the target architecture")
 			return ErrWrongArguments
 		}
+		if ctx.IsSet("download") && !ctx.Bool("wait") {
+			logging.Errorln("You must wait for the build to download artifacts")
+			return ErrWrongArguments
+		}
 
 		return nil
 	},
@@ -52,6 +56,9 @@ var CmdBuildImage = cli.Command{
 	Flags: []cli.Flag{
 		cli.StringFlag{"name, n", "", "package name", ""},
 		cli.StringFlag{"arch, a", "", "architecture", ""},
+		cli.StringFlag{"revision, r", "", "commit hash or tag of the kickstart repository", ""},
+		cli.BoolFlag{"wait, w", "wait for the build to finish and exit with an error if it fails", ""},
+		cli.StringFlag{"download, d", "", "download artifacts into this directory when done, requires --wait", ""},
 	},
 }
 
@@ -60,6 +67,7 @@ func runBuildImage(ctx *cli.Context) {
 	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
 	if err != nil {
 		logging.Errorln(err)
+		exitIfWaiting(nil, ctx.Bool("wait"))
 		return
 	}
 
@@ -71,9 +79,18 @@ func runBuildImage(ctx *cli.Context) {
 	name := ctx.String("name")
 	arch := ctx.String("arch")
 	var id uint64
-	if id, err = client.SendJob(name, arch, "image"); err != nil {
+	if id, err = client.SendJob(name, arch, "image", ctx.String("revision"), ""); err != nil {
 		logging.Errorln(err)
+		exitIfWaiting(client, ctx.Bool("wait"))
 		return
 	}
 	logging.Infof("Image \"%s\" build for %s queued as #%d\n", name, arch, id)
+
+	// Wait for the build to finish
+	if ctx.Bool("wait") {
+		if err = waitForJob(client, id, ctx.String("download")); err != nil {
+			logging.Errorln(err)
+			exitIfWaiting(client, true)
+		}
+	}
 }
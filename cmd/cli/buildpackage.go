@@ -33,9 +33,10 @@ import (
 )
 
 var CmdBuildPackage = cli.Command{
-	Name:        "build-package",
-	Usage:       "Build packages",
-	Description: `Request to build a package.`,
+	Name:  "build-package",
+	Usage: "Build packages",
+	Description: `Request to build a package, from the branch heads unless a
+   packaging or upstream commit hash or tag is specified.`,
 	Before: func(ctx *cli.Context) error {
 		if !ctx.IsSet("name") {
 			logging.Errorln("You must specify the target name")
@@ -45,6 +46,10 @@ var CmdBuildPackage = cli.Command{
 			logging.Errorln("You must specify the target architecture")
 			return ErrWrongArguments
 		}
+		if ctx.IsSet("download") && !ctx.Bool("wait") {
+			logging.Errorln("You must wait for the build to download artifacts")
+			return ErrWrongArguments
+		}
 
 		return nil
 	},
@@ -52,6 +57,10 @@ var CmdBuildPackage = cli.Command{
 	Flags: []cli.Flag{
 		cli.StringFlag{"name, n", "", "package name", ""},
 		cli.StringFlag{"arch, a", "", "architecture", ""},
+		cli.StringFlag{"revision, r", "", "packaging commit hash or tag", ""},
+		cli.StringFlag{"upstream-revision, u", "", "upstream commit hash or tag, only for CI packages", ""},
+		cli.BoolFlag{"wait, w", "wait for the build to finish and exit with an error if it fails", ""},
+		cli.StringFlag{"download, d", "", "download artifacts into this directory when done, requires --wait", ""},
 	},
 }
 
@@ -60,6 +69,7 @@ func runBuildPackage(ctx *cli.Context) {
 	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
 	if err != nil {
 		logging.Errorln(err)
+		exitIfWaiting(nil, ctx.Bool("wait"))
 		return
 	}
 
@@ -71,9 +81,18 @@ func runBuildPackage(ctx *cli.Context) {
 	name := ctx.String("name")
 	arch := ctx.String("arch")
 	var id uint64
-	if id, err = client.SendJob(name, arch, "package"); err != nil {
+	if id, err = client.SendJob(name, arch, "package", ctx.String("revision"), ctx.String("upstream-revision")); err != nil {
 		logging.Errorln(err)
+		exitIfWaiting(client, ctx.Bool("wait"))
 		return
 	}
 	logging.Infof("Package \"%s\" build for %s queued as #%d\n", name, arch, id)
+
+	// Wait for the build to finish
+	if ctx.Bool("wait") {
+		if err = waitForJob(client, id, ctx.String("download")); err != nil {
+			logging.Errorln(err)
+			exitIfWaiting(client, true)
+		}
+	}
 }
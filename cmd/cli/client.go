@@ -27,14 +27,22 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/hawaii-desktop/builder"
 	pb "github.com/hawaii-desktop/builder/protocol"
+	"github.com/hawaii-desktop/builder/utils"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"io"
-	"regexp"
+	"os"
+	"sort"
 	"strings"
+	"time"
 )
 
 // Store client stuff.
@@ -48,6 +56,7 @@ type Client struct {
 var (
 	ErrFailed     = errors.New("master didn't satisfy our request")
 	ErrInvalidVcs = errors.New("invalid VCS information")
+	ErrInvalidVar = errors.New("invalid variable (format: <name>=<value>)")
 )
 
 const (
@@ -56,6 +65,17 @@ const (
 	InactiveChroots pb.EnumListChroots = pb.EnumListChroots_InactiveChroots
 )
 
+// Map job status to description.
+var jobStatusDescriptionMap = map[pb.EnumJobStatus]string{
+	pb.EnumJobStatus_JOB_STATUS_JUST_CREATED: "JustCreated",
+	pb.EnumJobStatus_JOB_STATUS_WAITING:      "Waiting",
+	pb.EnumJobStatus_JOB_STATUS_PROCESSING:   "Processing",
+	pb.EnumJobStatus_JOB_STATUS_SUCCESSFUL:   "Successful",
+	pb.EnumJobStatus_JOB_STATUS_FAILED:       "Failed",
+	pb.EnumJobStatus_JOB_STATUS_CRASHED:      "Crashed",
+	pb.EnumJobStatus_JOB_STATUS_TIMED_OUT:    "TimedOut",
+}
+
 // Create a new Client object.
 func NewClient(conn *grpc.ClientConn) *Client {
 	return &Client{conn: conn, client: pb.NewBuilderClient(conn)}
@@ -64,7 +84,7 @@ func NewClient(conn *grpc.ClientConn) *Client {
 // Add a chroot.
 func (c *Client) AddChroot(release, version, arch string) error {
 	// Send message
-	args := &pb.ChrootInfo{release, version, arch}
+	args := &pb.ChrootInfo{Release: release, Version: version, Architecture: arch}
 	reply, err := c.client.AddChroot(context.Background(), args)
 	if err != nil {
 		return err
@@ -77,7 +97,7 @@ func (c *Client) AddChroot(release, version, arch string) error {
 
 // Remove chroot.
 func (c *Client) RemoveChroot(release, version, arch string) error {
-	args := &pb.ChrootInfo{release, version, arch}
+	args := &pb.ChrootInfo{Release: release, Version: version, Architecture: arch}
 	reply, err := c.client.RemoveChroot(context.Background(), args)
 	if err != nil {
 		return err
@@ -88,67 +108,65 @@ func (c *Client) RemoveChroot(release, version, arch string) error {
 	return nil
 }
 
-// List chroots.
-func (c *Client) ListChroots(state_flag pb.EnumListChroots) error {
-	stream, err := c.client.ListChroots(context.Background(), &pb.ListChrootsRequest{state_flag})
-	if err != nil {
-		return err
+// Split a "<url>#branch=<branch>" VCS string, the branch defaults
+// to master except for tarballs which don't have any.
+func splitVcs(vcs string) (string, string, error) {
+	url, branch := vcs, ""
+	if i := strings.LastIndex(vcs, "#branch="); i >= 0 {
+		url, branch = vcs[:i], vcs[i+len("#branch="):]
 	}
-
-	for {
-		chroot, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-
-		fmt.Printf("Chroot \"%s-%s-%s\"\n", chroot.Release, chroot.Version, chroot.Architecture)
+	if url == "" {
+		return "", "", ErrInvalidVcs
 	}
+	if branch == "" && !builder.IsTarballUrl(url) {
+		branch = "master"
+	}
+	return url, branch, nil
+}
 
-	return nil
+// Return a VCS string as accepted by splitVcs().
+func joinVcs(url, branch string) string {
+	if branch == "" {
+		return url
+	}
+	return fmt.Sprintf("%s#branch=%s", url, branch)
 }
 
 // Add a package.
-func (c *Client) AddPackage(name string, archs string, ci bool, vcs string, uvcs string) error {
+func (c *Client) AddPackage(name string, archs string, ci bool, vcs string, uvcs string, distro string, releasever string, project string, timeout time.Duration, limits ResourceLimits) error {
 	// Split architectures
 	a := strings.Split(archs, ",")
 
-	// Clean VCS strings
-	if m, _ := regexp.MatchString("#branch=.+$", vcs); !m {
-		vcs += "#branch=master"
-	}
-	if ci {
-		if m, _ := regexp.MatchString("#branch=.+$", uvcs); !m {
-			uvcs += "#branch=master"
-		}
-	}
-
-	// VCS regexp
-	r := regexp.MustCompile("(.+)#branch=(.+)$")
-
 	// Decode VCS
-	matches := r.FindStringSubmatch(vcs)
-	if len(matches) != 3 {
-		return ErrInvalidVcs
+	vcs_url, vcs_branch, err := splitVcs(vcs)
+	if err != nil {
+		return err
 	}
-	vcs_url := matches[1]
-	vcs_branch := matches[2]
 
 	// Decode upstream VCS
 	var uvcs_url, uvcs_branch string
 	if ci {
-		matches = r.FindStringSubmatch(uvcs)
-		if len(matches) != 3 {
-			return ErrInvalidVcs
+		uvcs_url, uvcs_branch, err = splitVcs(uvcs)
+		if err != nil {
+			return err
 		}
-		uvcs_url = matches[1]
-		uvcs_branch = matches[2]
 	}
 
 	// Send message
-	args := &pb.PackageInfo{name, a, ci, &pb.VcsInfo{vcs_url, vcs_branch}, &pb.VcsInfo{uvcs_url, uvcs_branch}}
+	args := &pb.PackageInfo{
+		Name:          name,
+		Architectures: a,
+		Ci:            ci,
+		Vcs:           &pb.VcsInfo{Url: vcs_url, Branch: vcs_branch},
+		UpstreamVcs:   &pb.VcsInfo{Url: uvcs_url, Branch: uvcs_branch},
+		Distribution:  distro,
+		ReleaseVer:    releasever,
+		Project:       project,
+		Timeout:       uint32(timeout / time.Second),
+		MemoryLimit:   limits.Memory,
+		CpuLimit:      limits.Cpu,
+		PidsLimit:     limits.Pids,
+	}
 	reply, err := c.client.AddPackage(context.Background(), args)
 	if err != nil {
 		return err
@@ -172,61 +190,56 @@ func (c *Client) RemovePackage(name string) error {
 	return nil
 }
 
-// List packages.
-func (c *Client) ListPackages() error {
-	stream, err := c.client.ListPackages(context.Background(), &pb.StringMessage{".+"})
+// Drain a slave.
+func (c *Client) DrainSlave(name string) error {
+	args := &pb.StringMessage{name}
+	reply, err := c.client.DrainSlave(context.Background(), args)
 	if err != nil {
 		return err
 	}
-
-	for {
-		pkg, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-
-		fmt.Printf("Package \"%s\"\n", pkg.Name)
-		fmt.Printf("\tArchitectures: %s\n", strings.Join(pkg.Architectures, ", "))
-		fmt.Printf("\tCI: %v\n", pkg.Ci)
-		fmt.Println("\tVCS:")
-		fmt.Printf("\t\tURL: %s\n", pkg.Vcs.Url)
-		fmt.Printf("\t\tBranch: %s\n", pkg.Vcs.Branch)
-		if pkg.Ci {
-			fmt.Println("\tUpstream VCS:")
-			fmt.Printf("\t\tURL: %s\n", pkg.UpstreamVcs.Url)
-			fmt.Printf("\t\tBranch: %s\n", pkg.UpstreamVcs.Branch)
-		}
+	if !reply.Result {
+		return ErrFailed
 	}
+	return nil
+}
 
+// Resume a drained slave.
+func (c *Client) ResumeSlave(name string) error {
+	args := &pb.StringMessage{name}
+	reply, err := c.client.ResumeSlave(context.Background(), args)
+	if err != nil {
+		return err
+	}
+	if !reply.Result {
+		return ErrFailed
+	}
 	return nil
 }
 
 // Add an image.
-func (c *Client) AddImage(name, descr, archs, vcs string) error {
+func (c *Client) AddImage(name, descr, archs, vcs, kickstart, format, product, releasever string, vars map[string]string, timeout time.Duration) error {
 	// Split architectures
 	a := strings.Split(archs, ",")
 
-	// Clean VCS strings
-	if m, _ := regexp.MatchString("#branch=.+$", vcs); !m {
-		vcs += "#branch=master"
-	}
-
-	// VCS regexp
-	r := regexp.MustCompile("(.+)#branch=(.+)$")
-
 	// Decode VCS
-	matches := r.FindStringSubmatch(vcs)
-	if len(matches) != 3 {
-		return ErrInvalidVcs
+	vcs_url, vcs_branch, err := splitVcs(vcs)
+	if err != nil {
+		return err
 	}
-	vcs_url := matches[1]
-	vcs_branch := matches[2]
 
 	// Send message
-	args := &pb.ImageInfo{name, descr, a, &pb.VcsInfo{vcs_url, vcs_branch}}
+	args := &pb.ImageInfo{
+		Name:          name,
+		Description:   descr,
+		Architectures: a,
+		Vcs:           &pb.VcsInfo{Url: vcs_url, Branch: vcs_branch},
+		Kickstart:     kickstart,
+		Format:        format,
+		Product:       product,
+		ReleaseVer:    releasever,
+		Variables:     vars,
+		Timeout:       uint32(timeout / time.Second),
+	}
 	reply, err := c.client.AddImage(context.Background(), args)
 	if err != nil {
 		return err
@@ -250,35 +263,143 @@ func (c *Client) RemoveImage(name string) error {
 	return nil
 }
 
-// List images.
-func (c *Client) ListImages() error {
-	stream, err := c.client.ListImages(context.Background(), &pb.StringMessage{".+"})
+// Add a project.
+func (c *Client) AddProject(name, descr, repos string, chroots []string, autoCreateRepo, buildEnableNet bool) error {
+	args := &pb.ProjectInfo{
+		Name:           name,
+		Description:    descr,
+		Repos:          repos,
+		Chroots:        chroots,
+		AutoCreaterepo: autoCreateRepo,
+		BuildEnableNet: buildEnableNet,
+	}
+	reply, err := c.client.AddProject(context.Background(), args)
+	if err != nil {
+		return err
+	}
+	if !reply.Result {
+		return ErrFailed
+	}
+	return nil
+}
+
+// Remove a project.
+func (c *Client) RemoveProject(name string) error {
+	args := &pb.StringMessage{name}
+	reply, err := c.client.RemoveProject(context.Background(), args)
 	if err != nil {
 		return err
 	}
+	if !reply.Result {
+		return ErrFailed
+	}
+	return nil
+}
+
+// Return whether the error means that the master has nothing to list.
+func isEmptyListError(err error) bool {
+	return grpc.Code(err) == codes.NotFound
+}
 
+// Return chroots, state_flag determines which ones.
+func (c *Client) ChrootList(state_flag pb.EnumListChroots) ([]*pb.ChrootInfo, error) {
+	stream, err := c.client.ListChroots(context.Background(), &pb.ListChrootsRequest{state_flag})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*pb.ChrootInfo
 	for {
-		img, err := stream.Recv()
+		chroot, err := stream.Recv()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return err
+			return nil, err
 		}
+		list = append(list, chroot)
+	}
+	return list, nil
+}
 
-		fmt.Printf("Image \"%s\"\n", img.Name)
-		fmt.Printf("\tDescription: %v\n", img.Description)
-		fmt.Printf("\tArchitectures: %s\n", strings.Join(img.Architectures, ", "))
-		fmt.Println("\tVCS:")
-		fmt.Printf("\t\tURL: %s\n", img.Vcs.Url)
-		fmt.Printf("\t\tBranch: %s\n", img.Vcs.Branch)
+// Return all the packages.
+func (c *Client) PackageList() ([]*pb.PackageInfo, error) {
+	stream, err := c.client.ListPackages(context.Background(), &pb.StringMessage{".+"})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	var list []*pb.PackageInfo
+	for {
+		pkg, err := stream.Recv()
+		if err == io.EOF || isEmptyListError(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, pkg)
+	}
+	return list, nil
 }
 
-// Schedule a job.
-func (c *Client) SendJob(target, arch, tstr string) (uint64, error) {
+// Return all the images.
+func (c *Client) ImageList() ([]*pb.ImageInfo, error) {
+	stream, err := c.client.ListImages(context.Background(), &pb.StringMessage{".+"})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*pb.ImageInfo
+	for {
+		img, err := stream.Recv()
+		if err == io.EOF || isEmptyListError(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, img)
+	}
+	return list, nil
+}
+
+// Return all the projects.
+func (c *Client) ProjectList() ([]*pb.ProjectInfo, error) {
+	stream, err := c.client.ListProjects(context.Background(), &pb.StringMessage{".+"})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*pb.ProjectInfo
+	for {
+		prj, err := stream.Recv()
+		if err == io.EOF || isEmptyListError(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, prj)
+	}
+	return list, nil
+}
+
+// Decode a list of <name>=<value> variables.
+func ParseVariables(list []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, item := range list {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, ErrInvalidVar
+		}
+		vars[pair[0]] = pair[1]
+	}
+	return vars, nil
+}
+
+// Schedule a job, building the branch heads unless revisions are specified.
+func (c *Client) SendJob(target, arch, tstr, revision, upstreamRevision string) (uint64, error) {
 	var t pb.EnumTargetType
 	switch tstr {
 	case "package":
@@ -291,7 +412,13 @@ func (c *Client) SendJob(target, arch, tstr string) (uint64, error) {
 		return 0, ErrWrongArguments
 	}
 
-	args := &pb.CollectJobRequest{Target: target, Architecture: arch, Type: t}
+	args := &pb.CollectJobRequest{
+		Target:            target,
+		Architecture:      arch,
+		Type:              t,
+		PackagingRevision: revision,
+		UpstreamRevision:  upstreamRevision,
+	}
 	reply, err := c.client.CollectJob(context.Background(), args)
 	if err != nil {
 		return 0, err
@@ -302,6 +429,299 @@ func (c *Client) SendJob(target, arch, tstr string) (uint64, error) {
 	return reply.Id, nil
 }
 
+// Print the output of a job, when follow is true keep
+// printing new output until the job has finished.
+func (c *Client) JobOutput(id uint64, follow bool) error {
+	args := &pb.JobOutputRequest{Id: id, Follow: follow}
+	stream, err := c.client.GetJobOutput(context.Background(), args)
+	if err != nil {
+		return err
+	}
+
+	// Output stored so far already has the step names, afterwards
+	// print the step name when it changes
+	step := ""
+	first := true
+	for {
+		output, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if first {
+			first = false
+		} else if output.Step != step {
+			fmt.Printf("==> %s\n", output.Step)
+		}
+		step = output.Step
+
+		for _, line := range output.Lines {
+			fmt.Println(line)
+		}
+	}
+
+	return nil
+}
+
+// Print job status, build steps and their summaries.
+func (c *Client) ShowJob(id uint64) error {
+	job, err := c.client.GetJob(context.Background(), &pb.GetJobRequest{Id: id})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Job #%d\n", job.Id)
+	fmt.Printf("\tType: %s\n", strings.ToLower(job.Type.String()))
+	fmt.Printf("\tTarget: %s\n", job.Target)
+	fmt.Printf("\tArchitecture: %s\n", job.Architecture)
+	if job.Distribution != "" {
+		fmt.Printf("\tDistribution: %s\n", job.Distribution)
+	}
+	if job.Slave != "" {
+		fmt.Printf("\tSlave: %s\n", job.Slave)
+	}
+	if job.PackagingCommit != "" {
+		if job.Type == pb.EnumTargetType_IMAGE {
+			fmt.Printf("\tKickstart commit: %s\n", job.PackagingCommit)
+		} else {
+			fmt.Printf("\tPackaging commit: %s\n", job.PackagingCommit)
+		}
+	}
+	if job.UpstreamCommit != "" {
+		fmt.Printf("\tUpstream commit: %s\n", job.UpstreamCommit)
+	}
+	fmt.Printf("\tStatus: %s\n", jobStatusDescriptionMap[job.Status])
+	printTiming("\t", job.Started, job.Finished)
+
+	for _, step := range job.Steps {
+		fmt.Printf("\tStep \"%s\"\n", step.Name)
+		printTiming("\t\t", step.Started, step.Finished)
+		if step.PeakMemory > 0 {
+			fmt.Printf("\t\tPeak memory: %s\n", utils.FormatSize(step.PeakMemory))
+		}
+		if step.CpuTime > 0 {
+			fmt.Printf("\t\tCPU time: %s\n", time.Duration(step.CpuTime))
+		}
+
+		keys := make([]string, 0, len(step.Summary))
+		for key := range step.Summary {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("\t\t%s:\n", key)
+			for _, line := range strings.Split(step.Summary[key], "\n") {
+				fmt.Printf("\t\t\t%s\n", line)
+			}
+		}
+
+		for _, log := range step.Logs {
+			truncated := ""
+			if log.Truncated {
+				truncated = ", truncated"
+			}
+			fmt.Printf("\t\tLog \"%s\" (%d bytes%s)\n", log.Name, log.Size, truncated)
+		}
+	}
+
+	return nil
+}
+
+// Print start and finish time with the duration.
+func printTiming(indent string, started, finished int64) {
+	if started == 0 {
+		return
+	}
+	start := time.Unix(0, started)
+	fmt.Printf("%sStarted: %s\n", indent, start.Format(time.RFC1123))
+	if finished == 0 {
+		return
+	}
+	finish := time.Unix(0, finished)
+	fmt.Printf("%sFinished: %s\n", indent, finish.Format(time.RFC1123))
+	fmt.Printf("%sDuration: %s\n", indent, finish.Sub(start))
+}
+
+// Return jobs matching the criteria.
+func (c *Client) ListJobs(args *pb.ListJobsRequest) ([]*pb.JobInfo, error) {
+	stream, err := c.client.ListJobs(context.Background(), args)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*pb.JobInfo
+	for {
+		job, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// Return builds matching the criteria.
+func (c *Client) ListBuilds(args *pb.ListBuildsRequest) ([]*pb.BuildInfo, error) {
+	stream, err := c.client.ListBuilds(context.Background(), args)
+	if err != nil {
+		return nil, err
+	}
+
+	var builds []*pb.BuildInfo
+	for {
+		build, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, build)
+	}
+
+	return builds, nil
+}
+
+// Watch a job printing status and build step changes
+// until it has finished, then return its final state.
+func (c *Client) WatchJob(id uint64) (*pb.JobInfo, error) {
+	stream, err := c.client.WatchJob(context.Background(), &pb.WatchJobRequest{Id: id})
+	if err != nil {
+		return nil, err
+	}
+
+	var job *pb.JobInfo
+	status := pb.EnumJobStatus(-1)
+	started := make(map[string]bool)
+	finished := make(map[string]bool)
+	for {
+		info, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		job = info
+
+		if job.Status != status {
+			status = job.Status
+			fmt.Printf("Job #%d: %s\n", job.Id, jobStatusDescriptionMap[status])
+		}
+		for _, step := range job.Steps {
+			if !started[step.Name] && step.Started > 0 {
+				started[step.Name] = true
+				fmt.Printf("==> %s\n", step.Name)
+			}
+			if !finished[step.Name] && step.Finished > step.Started {
+				finished[step.Name] = true
+				duration := time.Unix(0, step.Finished).Sub(time.Unix(0, step.Started))
+				fmt.Printf("<== %s (%s)\n", step.Name, duration)
+			}
+		}
+	}
+
+	if job == nil {
+		return nil, ErrFailed
+	}
+	return job, nil
+}
+
+// Download a file from the master, image tells whether a relative
+// file name is an image artifact.
+func (c *Client) DownloadFile(srcfilename, dstfilename string, image bool) error {
+	args := &pb.DownloadRequest{FileName: srcfilename, Image: image}
+	stream, err := c.client.Download(context.Background(), args)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(dstfilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	total := int64(0)
+	hasher := sha256.New()
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			return fmt.Errorf("transfer interrupted")
+		}
+		if err != nil {
+			return err
+		}
+
+		if chunk := r.GetChunk(); chunk != nil {
+			size, err := file.Write(chunk.Data)
+			if err != nil {
+				return err
+			}
+			hasher.Write(chunk.Data)
+			total += int64(size)
+		}
+
+		if end := r.GetEnd(); end != nil {
+			if total != end.Size {
+				return fmt.Errorf("size mismatch: %d bytes received, %d expected",
+					total, end.Size)
+			}
+			if hash := hasher.Sum(nil); !bytes.Equal(hash, end.Hash) {
+				return fmt.Errorf("wrong SHA256 hash \"%s\", expected \"%s\"",
+					hex.EncodeToString(hash), hex.EncodeToString(end.Hash))
+			}
+			return nil
+		}
+	}
+}
+
+// Return the most recent job of each target of type t.
+func (c *Client) LastBuilds(t pb.EnumTargetType) (map[string]*pb.JobInfo, error) {
+	args := &pb.ListJobsRequest{Types: []pb.EnumTargetType{t}, LastPerTarget: true}
+	jobs, err := c.ListJobs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	builds := make(map[string]*pb.JobInfo)
+	for _, job := range jobs {
+		builds[job.Target] = job
+	}
+	return builds, nil
+}
+
+// Print a build step log, only the last tail bytes
+// when tail is greater than zero.
+func (c *Client) Log(id uint64, step, name string, tail int64) error {
+	args := &pb.GetLogRequest{JobId: id, Step: step, Name: name, Tail: tail}
+	stream, err := c.client.GetLog(context.Background(), args)
+	if err != nil {
+		return err
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		os.Stdout.Write(chunk.Data)
+	}
+
+	return nil
+}
+
 // Close client connection.
 func (c *Client) Close() {
 	c.conn.Close()
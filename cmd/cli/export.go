@@ -0,0 +1,181 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"time"
+)
+
+var CmdExport = cli.Command{
+	Name:  "export",
+	Usage: "Export chroots, packages, images and projects to file",
+	Description: `Write chroots, packages, images and projects from the master
+   to a YAML file in the same format understood by import and sync.`,
+	Action: runExport,
+	Flags: []cli.Flag{
+		cli.StringFlag{"filename, f", "", "file to write, standard output if not specified", ""},
+	},
+}
+
+func runExport(ctx *cli.Context) {
+	// Connect to the master
+	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Create client proxy
+	client := NewClient(conn)
+	defer client.Close()
+
+	// Retrieve the current state
+	data, err := fetchData(client)
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Marshal and write
+	out, err := yaml.Marshal(data)
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+	if ctx.String("filename") == "" {
+		fmt.Print(string(out))
+		return
+	}
+	if err = ioutil.WriteFile(ctx.String("filename"), out, 0644); err != nil {
+		logging.Errorln(err)
+		return
+	}
+}
+
+// Retrieve chroots, packages, images and projects from the master.
+func fetchData(client *Client) (*Data, error) {
+	data := &Data{}
+
+	chroots, err := client.ChrootList(AllChroots)
+	if err != nil {
+		return nil, err
+	}
+	for _, chroot := range chroots {
+		data.AddChroots = append(data.AddChroots, ChrootEntry{chroot.Release, chroot.Version, chroot.Architecture})
+	}
+
+	pkgs, err := client.PackageList()
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		data.AddPackages = append(data.AddPackages, newPackageEntry(pkg))
+	}
+
+	imgs, err := client.ImageList()
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range imgs {
+		data.AddImages = append(data.AddImages, newImageEntry(img))
+	}
+
+	prjs, err := client.ProjectList()
+	if err != nil {
+		return nil, err
+	}
+	for _, prj := range prjs {
+		data.AddProjects = append(data.AddProjects, newProjectEntry(prj))
+	}
+
+	return data, nil
+}
+
+// Return the entry of a package.
+func newPackageEntry(pkg *pb.PackageInfo) PackageEntry {
+	entry := PackageEntry{
+		Name:          pkg.Name,
+		Architectures: pkg.Architectures,
+		Ci:            pkg.Ci,
+		Distribution:  pkg.Distribution,
+		ReleaseVer:    pkg.ReleaseVer,
+		Project:       pkg.Project,
+	}
+	if vcs := pkg.GetVcs(); vcs != nil {
+		entry.Vcs = VcsInfo{vcs.Url, vcs.Branch}
+	}
+	if uvcs := pkg.GetUpstreamVcs(); uvcs != nil && pkg.Ci {
+		entry.UpstreamVcs = VcsInfo{uvcs.Url, uvcs.Branch}
+	}
+	if pkg.Timeout > 0 {
+		entry.Timeout = (time.Duration(pkg.Timeout) * time.Second).String()
+	}
+	entry.MemoryLimit = formatMemoryLimit(pkg.MemoryLimit)
+	entry.CpuLimit = formatCpuLimit(pkg.CpuLimit)
+	entry.PidsLimit = pkg.PidsLimit
+	return entry
+}
+
+// Return the entry of an image.
+func newImageEntry(img *pb.ImageInfo) ImageEntry {
+	entry := ImageEntry{
+		Name:          img.Name,
+		Description:   img.Description,
+		Architectures: img.Architectures,
+		Kickstart:     img.Kickstart,
+		Format:        img.Format,
+		Product:       img.Product,
+		ReleaseVer:    img.ReleaseVer,
+		Variables:     img.GetVariables(),
+	}
+	if vcs := img.GetVcs(); vcs != nil {
+		entry.Vcs = VcsInfo{vcs.Url, vcs.Branch}
+	}
+	if img.Timeout > 0 {
+		entry.Timeout = (time.Duration(img.Timeout) * time.Second).String()
+	}
+	return entry
+}
+
+// Return the entry of a project.
+func newProjectEntry(prj *pb.ProjectInfo) ProjectEntry {
+	return ProjectEntry{
+		Name:           prj.Name,
+		Description:    prj.Description,
+		Repos:          prj.Repos,
+		Chroots:        prj.Chroots,
+		AutoCreateRepo: prj.AutoCreaterepo,
+		BuildEnableNet: prj.BuildEnableNet,
+	}
+}
@@ -29,11 +29,13 @@ package main
 import (
 	"fmt"
 	"github.com/codegangsta/cli"
+	"github.com/hawaii-desktop/builder"
 	"github.com/hawaii-desktop/builder/logging"
 	"google.golang.org/grpc"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"strings"
+	"time"
 )
 
 type ChrootEntry struct {
@@ -52,31 +54,56 @@ type PackageEntry struct {
 	Architectures []string `yaml:"archs"`
 	Ci            bool     `yaml:"ci"`
 	Vcs           VcsInfo  `yaml:"vcs"`
-	UpstreamVcs   VcsInfo  `yaml:"uvcs"`
-	Disabled      bool     `yaml:"disabled"`
+	UpstreamVcs   VcsInfo  `yaml:"uvcs,omitempty"`
+	Distribution  string   `yaml:"distro,omitempty"`
+	ReleaseVer    string   `yaml:"releasever,omitempty"`
+	Project       string   `yaml:"project,omitempty"`
+	Timeout       string   `yaml:"timeout,omitempty"`
+	MemoryLimit   string   `yaml:"memory-limit,omitempty"`
+	CpuLimit      string   `yaml:"cpu-limit,omitempty"`
+	PidsLimit     uint32   `yaml:"pids-limit,omitempty"`
+	Disabled      bool     `yaml:"disabled,omitempty"`
 }
 
 type ImageEntry struct {
-	Name          string   `yaml:"name"`
-	Description   string   `yaml:"descr"`
-	Architectures []string `yaml:"archs"`
-	Vcs           VcsInfo  `yaml:"vcs"`
-	Disabled      bool     `yaml:"disabled"`
+	Name          string            `yaml:"name"`
+	Description   string            `yaml:"descr"`
+	Architectures []string          `yaml:"archs"`
+	Vcs           VcsInfo           `yaml:"vcs"`
+	Kickstart     string            `yaml:"kickstart,omitempty"`
+	Format        string            `yaml:"format,omitempty"`
+	Product       string            `yaml:"product,omitempty"`
+	ReleaseVer    string            `yaml:"releasever,omitempty"`
+	Variables     map[string]string `yaml:"vars,omitempty"`
+	Timeout       string            `yaml:"timeout,omitempty"`
+	Disabled      bool              `yaml:"disabled,omitempty"`
+}
+
+type ProjectEntry struct {
+	Name           string   `yaml:"name"`
+	Description    string   `yaml:"descr,omitempty"`
+	Repos          string   `yaml:"repos,omitempty"`
+	Chroots        []string `yaml:"chroots,omitempty"`
+	AutoCreateRepo bool     `yaml:"auto-createrepo,omitempty"`
+	BuildEnableNet bool     `yaml:"build-enable-net,omitempty"`
+	Disabled       bool     `yaml:"disabled,omitempty"`
 }
 
 type Data struct {
-	AddChroots     []ChrootEntry  `yaml:"add-chroots"`
-	RemoveChroots  []ChrootEntry  `yaml:"remove-chroots"`
-	AddPackages    []PackageEntry `yaml:"add-packages"`
-	RemovePackages []string       `yaml:"remove-packages"`
-	AddImages      []ImageEntry   `yaml:"add-images"`
-	RemoveImages   []string       `yaml:"remove-images"`
+	AddChroots     []ChrootEntry  `yaml:"add-chroots,omitempty"`
+	RemoveChroots  []ChrootEntry  `yaml:"remove-chroots,omitempty"`
+	AddPackages    []PackageEntry `yaml:"add-packages,omitempty"`
+	RemovePackages []string       `yaml:"remove-packages,omitempty"`
+	AddImages      []ImageEntry   `yaml:"add-images,omitempty"`
+	RemoveImages   []string       `yaml:"remove-images,omitempty"`
+	AddProjects    []ProjectEntry `yaml:"add-projects,omitempty"`
+	RemoveProjects []string       `yaml:"remove-projects,omitempty"`
 }
 
 var CmdImport = cli.Command{
 	Name:        "import",
-	Usage:       "Add and remove packages and images from file",
-	Description: `Add and remove packages and image from a YAML file.`,
+	Usage:       "Add and remove packages, images and projects from file",
+	Description: `Add and remove chroots, packages, images and projects from a YAML file.`,
 	Before: func(ctx *cli.Context) error {
 		if !ctx.IsSet("filename") {
 			logging.Errorln("You must specify the file to import")
@@ -139,25 +166,7 @@ func runImport(ctx *cli.Context) {
 			continue
 		}
 
-		archs := defaultArchitectures
-		if len(pkg.Architectures) > 0 {
-			archs = strings.Join(pkg.Architectures, ",")
-		}
-
-		if pkg.Vcs.Branch == "" {
-			pkg.Vcs.Branch = "master"
-		}
-		vcs := fmt.Sprintf("%s#branch=%s", pkg.Vcs.Url, pkg.Vcs.Branch)
-
-		uvcs := ""
-		if pkg.Ci {
-			if pkg.UpstreamVcs.Branch == "" {
-				pkg.UpstreamVcs.Branch = "master"
-			}
-			uvcs = fmt.Sprintf("%s#branch=%s", pkg.UpstreamVcs.Url, pkg.UpstreamVcs.Branch)
-		}
-
-		if err = client.AddPackage(pkg.Name, archs, pkg.Ci, vcs, uvcs); err != nil {
+		if err = addPackageEntry(client, pkg); err != nil {
 			logging.Errorf("Failed to add package \"%s\": %s\n", pkg.Name, err)
 		}
 	}
@@ -175,17 +184,7 @@ func runImport(ctx *cli.Context) {
 			continue
 		}
 
-		archs := defaultArchitectures
-		if len(img.Architectures) > 0 {
-			archs = strings.Join(img.Architectures, ",")
-		}
-
-		if img.Vcs.Branch == "" {
-			img.Vcs.Branch = "master"
-		}
-		vcs := fmt.Sprintf("%s#branch=%s", img.Vcs.Url, img.Vcs.Branch)
-
-		if err = client.AddImage(img.Name, img.Description, archs, vcs); err != nil {
+		if err = addImageEntry(client, img); err != nil {
 			logging.Errorf("Failed to add image \"%s\": %s\n", img.Name, err)
 		}
 	}
@@ -196,4 +195,121 @@ func runImport(ctx *cli.Context) {
 			logging.Errorf("Failed to remove image \"%s\": %s\n", name, err)
 		}
 	}
+
+	// Process all the projects to add
+	for _, prj := range data.AddProjects {
+		if prj.Disabled {
+			continue
+		}
+
+		if err = client.AddProject(prj.Name, prj.Description, prj.Repos, prj.Chroots,
+			prj.AutoCreateRepo, prj.BuildEnableNet); err != nil {
+			logging.Errorf("Failed to add project \"%s\": %s\n", prj.Name, err)
+		}
+	}
+
+	// Process all the projects to remove
+	for _, name := range data.RemoveProjects {
+		if err = client.RemoveProject(name); err != nil {
+			logging.Errorf("Failed to remove project \"%s\": %s\n", name, err)
+		}
+	}
+}
+
+// Fill in default values.
+func (pkg *PackageEntry) normalize() {
+	if len(pkg.Architectures) == 0 {
+		pkg.Architectures = strings.Split(defaultArchitectures, ",")
+	}
+	if pkg.Vcs.Branch == "" && !builder.IsTarballUrl(pkg.Vcs.Url) {
+		pkg.Vcs.Branch = "master"
+	}
+	if pkg.Ci {
+		if pkg.UpstreamVcs.Branch == "" && !builder.IsTarballUrl(pkg.UpstreamVcs.Url) {
+			pkg.UpstreamVcs.Branch = "master"
+		}
+	} else {
+		pkg.UpstreamVcs = VcsInfo{}
+	}
+	pkg.Timeout = normalizeTimeout(pkg.Timeout)
+	if limits, err := parseLimits(pkg.MemoryLimit, pkg.CpuLimit, pkg.PidsLimit); err == nil {
+		pkg.MemoryLimit = formatMemoryLimit(limits.Memory)
+		pkg.CpuLimit = formatCpuLimit(limits.Cpu)
+	}
+}
+
+// Fill in default values.
+func (img *ImageEntry) normalize() {
+	if len(img.Architectures) == 0 {
+		img.Architectures = strings.Split(defaultArchitectures, ",")
+	}
+	if img.Vcs.Branch == "" && !builder.IsTarballUrl(img.Vcs.Url) {
+		img.Vcs.Branch = "master"
+	}
+	if len(img.Variables) == 0 {
+		img.Variables = nil
+	}
+	img.Timeout = normalizeTimeout(img.Timeout)
+}
+
+// Return the canonical form of a timeout, so that entries
+// with the same timeout written differently compare equal.
+func normalizeTimeout(timeout string) string {
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return timeout
+	}
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// Parse the timeout of an entry.
+func parseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid timeout \"%s\"", timeout)
+	}
+	return d, nil
+}
+
+// Add or update a package from its entry.
+func addPackageEntry(client *Client, pkg PackageEntry) error {
+	pkg.normalize()
+
+	vcs := joinVcs(pkg.Vcs.Url, pkg.Vcs.Branch)
+	uvcs := ""
+	if pkg.Ci {
+		uvcs = joinVcs(pkg.UpstreamVcs.Url, pkg.UpstreamVcs.Branch)
+	}
+
+	timeout, err := parseTimeout(pkg.Timeout)
+	if err != nil {
+		return err
+	}
+	limits, err := parseLimits(pkg.MemoryLimit, pkg.CpuLimit, pkg.PidsLimit)
+	if err != nil {
+		return err
+	}
+
+	return client.AddPackage(pkg.Name, strings.Join(pkg.Architectures, ","),
+		pkg.Ci, vcs, uvcs, pkg.Distribution, pkg.ReleaseVer, pkg.Project, timeout, limits)
+}
+
+// Add or update an image from its entry.
+func addImageEntry(client *Client, img ImageEntry) error {
+	img.normalize()
+
+	timeout, err := parseTimeout(img.Timeout)
+	if err != nil {
+		return err
+	}
+
+	vcs := joinVcs(img.Vcs.Url, img.Vcs.Branch)
+	return client.AddImage(img.Name, img.Description, strings.Join(img.Architectures, ","), vcs,
+		img.Kickstart, img.Format, img.Product, img.ReleaseVer, img.Variables, timeout)
 }
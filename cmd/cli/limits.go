@@ -0,0 +1,88 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"github.com/hawaii-desktop/builder/utils"
+	"strconv"
+)
+
+// Resource limits of a package build.
+type ResourceLimits struct {
+	// Memory in bytes.
+	Memory uint64
+	// CPU in thousandths of a CPU.
+	Cpu uint32
+	// Maximum number of processes.
+	Pids uint32
+}
+
+// Parse resource limits as specified on the command line,
+// empty strings and zero mean the slave default.
+func parseLimits(memory, cpu string, pids uint32) (ResourceLimits, error) {
+	limits := ResourceLimits{Pids: pids}
+	if memory != "" {
+		value, err := utils.ParseSize(memory)
+		if err != nil {
+			return limits, err
+		}
+		limits.Memory = value
+	}
+	if cpu != "" {
+		value, err := strconv.ParseFloat(cpu, 64)
+		if err != nil || value < 0 {
+			return limits, fmt.Errorf("invalid CPU limit \"%s\"", cpu)
+		}
+		limits.Cpu = uint32(value * 1000)
+	}
+	return limits, nil
+}
+
+// Format a memory limit so that it can be parsed back exactly.
+func formatMemoryLimit(memory uint64) string {
+	if memory == 0 {
+		return ""
+	}
+	for _, unit := range []struct {
+		suffix string
+		size   uint64
+	}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}} {
+		if memory%unit.size == 0 {
+			return strconv.FormatUint(memory/unit.size, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatUint(memory, 10)
+}
+
+// Format a CPU limit in number of CPUs.
+func formatCpuLimit(cpu uint32) string {
+	if cpu == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(cpu)/1000, 'f', -1, 64)
+}
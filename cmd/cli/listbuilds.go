@@ -0,0 +1,152 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"github.com/codegangsta/cli"
+	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
+	"google.golang.org/grpc"
+	"strconv"
+)
+
+var CmdListBuilds = cli.Command{
+	Name:  "list-builds",
+	Usage: "List successful package builds",
+	Description: `Print builds matching the criteria, most recent first, for example
+   which build produced an RPM: list-builds --artifact foo-1.0-1.fc23.x86_64.rpm.
+
+   Artifacts with their SHA256 checksums and upstream changes are printed
+   only in JSON and YAML output.`,
+	Before: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 0 {
+			logging.Errorln("Too many arguments")
+			return ErrWrongArguments
+		}
+		return nil
+	},
+	Action: runListBuilds,
+	Flags: []cli.Flag{
+		cli.StringFlag{"package, p", "", "package name", ""},
+		cli.StringFlag{"chroot, c", "", "chroot (e.g. fedora-23-x86_64)", ""},
+		cli.StringFlag{"nevr", "", "regular expression matching name, epoch, version and release", ""},
+		cli.StringFlag{"artifact, a", "", "file name of an artifact", ""},
+		cli.StringFlag{"commit", "", "packaging or upstream commit hash prefix", ""},
+	},
+}
+
+// Build as printed in JSON and YAML.
+type buildRecord struct {
+	Package         string                 `json:"package" yaml:"package"`
+	Chroot          string                 `json:"chroot" yaml:"chroot"`
+	Nevr            string                 `json:"nevr" yaml:"nevr"`
+	PackagingCommit string                 `json:"packaging_commit,omitempty" yaml:"packaging_commit,omitempty"`
+	UpstreamCommit  string                 `json:"upstream_commit,omitempty" yaml:"upstream_commit,omitempty"`
+	JobId           uint64                 `json:"job_id" yaml:"job_id"`
+	Finished        string                 `json:"finished,omitempty" yaml:"finished,omitempty"`
+	Artifacts       []*buildArtifactRecord `json:"artifacts,omitempty" yaml:"artifacts,omitempty"`
+	Changes         []string               `json:"changes,omitempty" yaml:"changes,omitempty"`
+}
+
+// Build artifact as printed in JSON and YAML.
+type buildArtifactRecord struct {
+	FileName string `json:"filename" yaml:"filename"`
+	Size     int64  `json:"size" yaml:"size"`
+	Sha256   string `json:"sha256" yaml:"sha256"`
+}
+
+func runListBuilds(ctx *cli.Context) {
+	// Connect to the master
+	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Create client proxy
+	client := NewClient(conn)
+	defer client.Close()
+
+	args := &pb.ListBuildsRequest{
+		Package:  ctx.String("package"),
+		Chroot:   ctx.String("chroot"),
+		Nevr:     ctx.String("nevr"),
+		Artifact: ctx.String("artifact"),
+		Commit:   ctx.String("commit"),
+	}
+	builds, err := client.ListBuilds(args)
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Print
+	records := make([]*buildRecord, 0, len(builds))
+	var rows [][]string
+	for _, build := range builds {
+		r := newBuildRecord(build)
+		records = append(records, r)
+		commit := r.UpstreamCommit
+		if commit == "" {
+			commit = r.PackagingCommit
+		}
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		rows = append(rows, []string{r.Package, r.Chroot, r.Nevr,
+			strconv.FormatUint(r.JobId, 10), commit, r.Finished})
+	}
+	header := []string{"PACKAGE", "CHROOT", "NEVR", "JOB", "COMMIT", "FINISHED"}
+	if err = printOutput(ctx.GlobalString("output"), records, header, rows); err != nil {
+		logging.Errorln(err)
+		return
+	}
+}
+
+// Return a build as printed in JSON and YAML.
+func newBuildRecord(build *pb.BuildInfo) *buildRecord {
+	record := &buildRecord{
+		Package:         build.Package,
+		Chroot:          build.Chroot,
+		Nevr:            build.Nevr,
+		PackagingCommit: build.PackagingCommit,
+		UpstreamCommit:  build.UpstreamCommit,
+		JobId:           build.JobId,
+		Changes:         build.Changes,
+	}
+	if build.Finished > 0 {
+		record.Finished = formatTime(build.Finished)
+	}
+	for _, artifact := range build.Artifacts {
+		record.Artifacts = append(record.Artifacts, &buildArtifactRecord{
+			FileName: artifact.FileName,
+			Size:     artifact.Size,
+			Sha256:   artifact.Sha256,
+		})
+	}
+	return record
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/codegangsta/cli"
 	"github.com/hawaii-desktop/builder/logging"
 	"google.golang.org/grpc"
+	"strconv"
 )
 
 var CmdListChroots = cli.Command{
@@ -43,6 +44,14 @@ var CmdListChroots = cli.Command{
 	},
 }
 
+// Chroot as printed in JSON and YAML.
+type chrootRecord struct {
+	Release      string `json:"release" yaml:"release"`
+	Version      string `json:"version" yaml:"version"`
+	Architecture string `json:"arch" yaml:"arch"`
+	Active       bool   `json:"active" yaml:"active"`
+}
+
 func runListChroots(ctx *cli.Context) {
 	// Connect to the master
 	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
@@ -62,7 +71,22 @@ func runListChroots(ctx *cli.Context) {
 	} else if ctx.Bool("inactive") {
 		flags = InactiveChroots
 	}
-	if err = client.ListChroots(flags); err != nil {
+	chroots, err := client.ChrootList(flags)
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Print
+	records := make([]*chrootRecord, 0, len(chroots))
+	var rows [][]string
+	for _, chroot := range chroots {
+		records = append(records, &chrootRecord{chroot.Release, chroot.Version, chroot.Architecture, chroot.Active})
+		rows = append(rows, []string{chroot.Release, chroot.Version, chroot.Architecture,
+			strconv.FormatBool(chroot.Active)})
+	}
+	header := []string{"RELEASE", "VERSION", "ARCH", "ACTIVE"}
+	if err = printOutput(ctx.GlobalString("output"), records, header, rows); err != nil {
 		logging.Errorln(err)
 		return
 	}
@@ -29,7 +29,10 @@ package main
 import (
 	"github.com/codegangsta/cli"
 	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
 	"google.golang.org/grpc"
+	"strings"
+	"time"
 )
 
 var CmdListImages = cli.Command{
@@ -40,6 +43,22 @@ var CmdListImages = cli.Command{
 	Flags:       []cli.Flag{},
 }
 
+// Image as printed in JSON and YAML.
+type imageRecord struct {
+	Name            string            `json:"name" yaml:"name"`
+	Description     string            `json:"descr,omitempty" yaml:"descr,omitempty"`
+	Architectures   []string          `json:"archs" yaml:"archs"`
+	Vcs             string            `json:"vcs" yaml:"vcs"`
+	Kickstart       string            `json:"kickstart,omitempty" yaml:"kickstart,omitempty"`
+	Format          string            `json:"format,omitempty" yaml:"format,omitempty"`
+	Product         string            `json:"product,omitempty" yaml:"product,omitempty"`
+	ReleaseVer      string            `json:"releasever,omitempty" yaml:"releasever,omitempty"`
+	Variables       map[string]string `json:"vars,omitempty" yaml:"vars,omitempty"`
+	Timeout         string            `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	LastBuildStatus string            `json:"last_build_status,omitempty" yaml:"last_build_status,omitempty"`
+	LastBuildTime   string            `json:"last_build_time,omitempty" yaml:"last_build_time,omitempty"`
+}
+
 func runListImages(ctx *cli.Context) {
 	// Connect to the master
 	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
@@ -52,8 +71,46 @@ func runListImages(ctx *cli.Context) {
 	client := NewClient(conn)
 	defer client.Close()
 
-	// List images
-	if err = client.ListImages(); err != nil {
+	// List images along with their last build
+	imgs, err := client.ImageList()
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+	builds, err := client.LastBuilds(pb.EnumTargetType_IMAGE)
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Print
+	records := make([]*imageRecord, 0, len(imgs))
+	var rows [][]string
+	for _, img := range imgs {
+		r := &imageRecord{
+			Name:          img.Name,
+			Description:   img.Description,
+			Architectures: img.Architectures,
+			Vcs:           formatVcs(img.Vcs),
+			Kickstart:     img.Kickstart,
+			Format:        img.Format,
+			Product:       img.Product,
+			ReleaseVer:    img.ReleaseVer,
+			Variables:     img.Variables,
+		}
+		if img.Timeout > 0 {
+			r.Timeout = (time.Duration(img.Timeout) * time.Second).String()
+		}
+		if job, ok := builds[img.Name]; ok {
+			r.LastBuildStatus = jobStatusDescriptionMap[job.Status]
+			r.LastBuildTime = formatTime(job.Started)
+		}
+		records = append(records, r)
+		rows = append(rows, []string{r.Name, r.Format, strings.Join(r.Architectures, ","),
+			r.Vcs, r.ReleaseVer, r.LastBuildStatus, r.LastBuildTime})
+	}
+	header := []string{"NAME", "FORMAT", "ARCHS", "VCS", "RELEASE", "LAST BUILD", "BUILT"}
+	if err = printOutput(ctx.GlobalString("output"), records, header, rows); err != nil {
 		logging.Errorln(err)
 		return
 	}
@@ -0,0 +1,213 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
+	"google.golang.org/grpc"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var CmdListJobs = cli.Command{
+	Name:  "list-jobs",
+	Usage: "List jobs",
+	Description: `Print jobs matching the criteria, most recent first, for example
+   the last failed build of a package: list-jobs --status failed --target '^foo$' --limit 1.
+
+   Times can be either absolute (2006-01-02 or RFC 3339) or relative
+   to now (e.g. 24h).`,
+	Before: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 0 {
+			logging.Errorln("Too many arguments")
+			return ErrWrongArguments
+		}
+		for _, name := range []string{"since", "until"} {
+			if _, err := parseTimeArg(ctx.String(name)); err != nil {
+				logging.Errorf("Invalid time \"%s\"\n", ctx.String(name))
+				return ErrWrongArguments
+			}
+		}
+		if _, err := parseJobStatuses(ctx.String("status")); err != nil {
+			logging.Errorln(err)
+			return ErrWrongArguments
+		}
+		if ctx.Int("limit") < 0 || ctx.Int("offset") < 0 {
+			logging.Errorln("Limit and offset cannot be negative")
+			return ErrWrongArguments
+		}
+		return nil
+	},
+	Action: runListJobs,
+	Flags: []cli.Flag{
+		cli.StringFlag{"status, s", "", "comma separated list of statuses (waiting, processing, successful, failed, crashed)", ""},
+		cli.StringFlag{"target, t", "", "regular expression matching the target name", ""},
+		cli.StringFlag{"arch", "", "architecture", ""},
+		cli.StringFlag{"slave", "", "name of the slave", ""},
+		cli.StringFlag{"since", "", "only jobs started after this time", ""},
+		cli.StringFlag{"until", "", "only jobs started before this time", ""},
+		cli.IntFlag{"limit, l", 50, "maximum number of jobs, 0 for all", ""},
+		cli.IntFlag{"offset", 0, "number of jobs to skip", ""},
+	},
+}
+
+// Job as printed in JSON and YAML.
+type jobRecord struct {
+	Id           uint64 `json:"id" yaml:"id"`
+	Type         string `json:"type" yaml:"type"`
+	Target       string `json:"target" yaml:"target"`
+	Architecture string `json:"arch" yaml:"arch"`
+	Distribution string `json:"distro,omitempty" yaml:"distro,omitempty"`
+	Slave        string `json:"slave,omitempty" yaml:"slave,omitempty"`
+	Status       string `json:"status" yaml:"status"`
+	Started      string `json:"started,omitempty" yaml:"started,omitempty"`
+	Finished     string `json:"finished,omitempty" yaml:"finished,omitempty"`
+	Duration     string `json:"duration,omitempty" yaml:"duration,omitempty"`
+
+	PackagingCommit string `json:"packaging_commit,omitempty" yaml:"packaging_commit,omitempty"`
+	UpstreamCommit  string `json:"upstream_commit,omitempty" yaml:"upstream_commit,omitempty"`
+}
+
+func runListJobs(ctx *cli.Context) {
+	// Connect to the master
+	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Create client proxy
+	client := NewClient(conn)
+	defer client.Close()
+
+	// Criteria were validated before
+	statuses, _ := parseJobStatuses(ctx.String("status"))
+	since, _ := parseTimeArg(ctx.String("since"))
+	until, _ := parseTimeArg(ctx.String("until"))
+	args := &pb.ListJobsRequest{
+		Statuses:      statuses,
+		Target:        ctx.String("target"),
+		Architecture:  ctx.String("arch"),
+		Slave:         ctx.String("slave"),
+		StartedAfter:  since,
+		StartedBefore: until,
+		Limit:         uint32(ctx.Int("limit")),
+		Offset:        uint32(ctx.Int("offset")),
+	}
+	jobs, err := client.ListJobs(args)
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Print
+	if err = printJobs(jobs, ctx.GlobalString("output")); err != nil {
+		logging.Errorln(err)
+		return
+	}
+}
+
+// Parse a comma separated list of job statuses.
+func parseJobStatuses(arg string) ([]pb.EnumJobStatus, error) {
+	var statuses []pb.EnumJobStatus
+	if arg == "" {
+		return statuses, nil
+	}
+	for _, name := range strings.Split(arg, ",") {
+		found := false
+		for status, descr := range jobStatusDescriptionMap {
+			if strings.EqualFold(strings.TrimSpace(name), descr) {
+				statuses = append(statuses, status)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid job status \"%s\"", name)
+		}
+	}
+	return statuses, nil
+}
+
+// Parse an absolute or relative time and return
+// nanoseconds since Epoch, 0 when arg is empty.
+func parseTimeArg(arg string) (int64, error) {
+	if arg == "" {
+		return 0, nil
+	}
+	if d, err := time.ParseDuration(arg); err == nil {
+		return time.Now().Add(-d).UnixNano(), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, arg, time.Local); err == nil {
+			return t.UnixNano(), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time \"%s\"", arg)
+}
+
+// Return a job as printed in JSON and YAML.
+func newJobRecord(job *pb.JobInfo) *jobRecord {
+	record := &jobRecord{
+		Id:           job.Id,
+		Type:         strings.ToLower(job.Type.String()),
+		Target:       job.Target,
+		Architecture: job.Architecture,
+		Distribution: job.Distribution,
+		Slave:        job.Slave,
+		Status:       jobStatusDescriptionMap[job.Status],
+
+		PackagingCommit: job.PackagingCommit,
+		UpstreamCommit:  job.UpstreamCommit,
+	}
+	if job.Started > 0 {
+		record.Started = formatTime(job.Started)
+		if job.Finished > 0 {
+			record.Finished = formatTime(job.Finished)
+			record.Duration = time.Unix(0, job.Finished).Sub(time.Unix(0, job.Started)).String()
+		}
+	}
+	return record
+}
+
+// Print jobs in the specified format.
+func printJobs(jobs []*pb.JobInfo, format string) error {
+	records := make([]*jobRecord, 0, len(jobs))
+	var rows [][]string
+	for _, job := range jobs {
+		r := newJobRecord(job)
+		records = append(records, r)
+		rows = append(rows, []string{strconv.FormatUint(r.Id, 10), r.Type, r.Target,
+			r.Architecture, r.Status, r.Slave, r.Started, r.Duration})
+	}
+	header := []string{"ID", "TYPE", "TARGET", "ARCH", "STATUS", "SLAVE", "STARTED", "DURATION"}
+	return printOutput(format, records, header, rows)
+}
@@ -29,7 +29,11 @@ package main
 import (
 	"github.com/codegangsta/cli"
 	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
 	"google.golang.org/grpc"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var CmdListPackages = cli.Command{
@@ -40,6 +44,24 @@ var CmdListPackages = cli.Command{
 	Flags:       []cli.Flag{},
 }
 
+// Package as printed in JSON and YAML.
+type packageRecord struct {
+	Name            string   `json:"name" yaml:"name"`
+	Distribution    string   `json:"distro,omitempty" yaml:"distro,omitempty"`
+	ReleaseVer      string   `json:"releasever,omitempty" yaml:"releasever,omitempty"`
+	Architectures   []string `json:"archs" yaml:"archs"`
+	Ci              bool     `json:"ci" yaml:"ci"`
+	Vcs             string   `json:"vcs" yaml:"vcs"`
+	UpstreamVcs     string   `json:"uvcs,omitempty" yaml:"uvcs,omitempty"`
+	Project         string   `json:"project,omitempty" yaml:"project,omitempty"`
+	Timeout         string   `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	MemoryLimit     string   `json:"memory_limit,omitempty" yaml:"memory_limit,omitempty"`
+	CpuLimit        string   `json:"cpu_limit,omitempty" yaml:"cpu_limit,omitempty"`
+	PidsLimit       uint32   `json:"pids_limit,omitempty" yaml:"pids_limit,omitempty"`
+	LastBuildStatus string   `json:"last_build_status,omitempty" yaml:"last_build_status,omitempty"`
+	LastBuildTime   string   `json:"last_build_time,omitempty" yaml:"last_build_time,omitempty"`
+}
+
 func runListPackages(ctx *cli.Context) {
 	// Connect to the master
 	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
@@ -52,8 +74,50 @@ func runListPackages(ctx *cli.Context) {
 	client := NewClient(conn)
 	defer client.Close()
 
-	// List packages
-	if err = client.ListPackages(); err != nil {
+	// List packages along with their last build
+	pkgs, err := client.PackageList()
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+	builds, err := client.LastBuilds(pb.EnumTargetType_PACKAGE)
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Print
+	records := make([]*packageRecord, 0, len(pkgs))
+	var rows [][]string
+	for _, pkg := range pkgs {
+		r := &packageRecord{
+			Name:          pkg.Name,
+			Distribution:  pkg.Distribution,
+			ReleaseVer:    pkg.ReleaseVer,
+			Architectures: pkg.Architectures,
+			Ci:            pkg.Ci,
+			Vcs:           formatVcs(pkg.Vcs),
+			Project:       pkg.Project,
+			MemoryLimit:   formatMemoryLimit(pkg.MemoryLimit),
+			CpuLimit:      formatCpuLimit(pkg.CpuLimit),
+			PidsLimit:     pkg.PidsLimit,
+		}
+		if pkg.Ci {
+			r.UpstreamVcs = formatVcs(pkg.UpstreamVcs)
+		}
+		if pkg.Timeout > 0 {
+			r.Timeout = (time.Duration(pkg.Timeout) * time.Second).String()
+		}
+		if job, ok := builds[pkg.Name]; ok {
+			r.LastBuildStatus = jobStatusDescriptionMap[job.Status]
+			r.LastBuildTime = formatTime(job.Started)
+		}
+		records = append(records, r)
+		rows = append(rows, []string{r.Name, r.Distribution, strings.Join(r.Architectures, ","),
+			strconv.FormatBool(r.Ci), r.Vcs, r.Project, r.LastBuildStatus, r.LastBuildTime})
+	}
+	header := []string{"NAME", "DISTRO", "ARCHS", "CI", "VCS", "PROJECT", "LAST BUILD", "BUILT"}
+	if err = printOutput(ctx.GlobalString("output"), records, header, rows); err != nil {
 		logging.Errorln(err)
 		return
 	}
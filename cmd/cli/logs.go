@@ -0,0 +1,97 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"github.com/codegangsta/cli"
+	"github.com/hawaii-desktop/builder/logging"
+	"google.golang.org/grpc"
+	"strconv"
+)
+
+var CmdLogs = cli.Command{
+	Name:  "logs",
+	Usage: "Show job output or logs",
+	Description: `Print the output of the commands run by a job, for example: logs -f 42.
+
+   A log of a build step is printed when both --step and --log
+   are specified, for example: logs --step mock --log root.log 42.`,
+	Before: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			logging.Errorln("You must specify the job identifier")
+			return ErrWrongArguments
+		}
+		if _, err := strconv.ParseUint(ctx.Args().First(), 10, 64); err != nil {
+			logging.Errorf("Invalid job identifier \"%s\"\n", ctx.Args().First())
+			return ErrWrongArguments
+		}
+		if (ctx.String("step") == "") != (ctx.String("log") == "") {
+			logging.Errorln("You must specify both the build step and the log name")
+			return ErrWrongArguments
+		}
+		if ctx.Bool("follow") && ctx.String("log") != "" {
+			logging.Errorln("Logs of a build step cannot be followed")
+			return ErrWrongArguments
+		}
+		return nil
+	},
+	Action: runLogs,
+	Flags: []cli.Flag{
+		cli.BoolFlag{"follow, f", "keep printing new output until the job has finished", ""},
+		cli.StringFlag{"step, s", "", "build step name", ""},
+		cli.StringFlag{"log, l", "", "log name", ""},
+		cli.IntFlag{"tail, t", 0, "only print the last bytes of the log", ""},
+	},
+}
+
+func runLogs(ctx *cli.Context) {
+	// Connect to the master
+	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Create client proxy
+	client := NewClient(conn)
+	defer client.Close()
+
+	// Print a log
+	id, _ := strconv.ParseUint(ctx.Args().First(), 10, 64)
+	if ctx.String("log") != "" {
+		if err = client.Log(id, ctx.String("step"), ctx.String("log"), int64(ctx.Int("tail"))); err != nil {
+			logging.Errorln(err)
+		}
+		return
+	}
+
+	// Print the output
+	if err = client.JobOutput(id, ctx.Bool("follow")); err != nil {
+		logging.Errorln(err)
+		return
+	}
+}
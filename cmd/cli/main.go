@@ -60,15 +60,30 @@ func main() {
 		CmdListChroots,
 		CmdListImages,
 		CmdImport,
+		CmdExport,
+		CmdSync,
 		CmdBuildImage,
 		CmdBuildPackage,
+		CmdLogs,
+		CmdShowJob,
+		CmdListJobs,
+		CmdListBuilds,
+		CmdDrainSlave,
+		CmdResumeSlave,
 		CmdCert,
 	}
 	app.Flags = []cli.Flag{
 		cli.StringFlag{"config, c", "", "custom configuration file path", ""},
 		cli.StringFlag{"address, a", "", "override master address from the configuration file", ""},
+		cli.StringFlag{"output, o", OutputTable, "output format of list commands (table, json, yaml)", ""},
 	}
 	app.Before = func(ctx *cli.Context) error {
+		// Validate the output format
+		if !isValidOutputFormat(ctx.String("output")) {
+			logging.Errorf("Invalid output format \"%s\"\n", ctx.String("output"))
+			return ErrWrongArguments
+		}
+
 		// Load the configuration
 		var configArg string
 		if ctx.IsSet("config") {
@@ -0,0 +1,105 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	pb "github.com/hawaii-desktop/builder/protocol"
+	"gopkg.in/yaml.v2"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats of list commands.
+const (
+	OutputTable = "table"
+	OutputJson  = "json"
+	OutputYaml  = "yaml"
+)
+
+// Return whether the output format is supported.
+func isValidOutputFormat(format string) bool {
+	switch format {
+	case OutputTable, OutputJson, OutputYaml:
+		return true
+	}
+	return false
+}
+
+// Print records as JSON or YAML, otherwise print a table
+// with the header and rows.  Empty cells are printed as "-".
+func printOutput(format string, records interface{}, header []string, rows [][]string) error {
+	switch format {
+	case OutputJson:
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case OutputYaml:
+		data, err := yaml.Marshal(records)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, cell := range row {
+				if cell == "" {
+					cell = "-"
+				}
+				cells[i] = cell
+			}
+			fmt.Fprintln(w, strings.Join(cells, "\t"))
+		}
+		w.Flush()
+	}
+
+	return nil
+}
+
+// Return a time in nanoseconds since Epoch as printed by list commands.
+func formatTime(t int64) string {
+	if t == 0 {
+		return ""
+	}
+	return time.Unix(0, t).Format(time.RFC3339)
+}
+
+// Return VCS information as printed by list commands.
+func formatVcs(vcs *pb.VcsInfo) string {
+	if vcs == nil || vcs.Url == "" {
+		return ""
+	}
+	return joinVcs(vcs.Url, vcs.Branch)
+}
@@ -0,0 +1,72 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"github.com/codegangsta/cli"
+	"github.com/hawaii-desktop/builder/logging"
+	"google.golang.org/grpc"
+	"strconv"
+)
+
+var CmdShowJob = cli.Command{
+	Name:        "show-job",
+	Usage:       "Show job details",
+	Description: `Print status, build steps timing, summaries and logs of a job, for example: show-job 42.`,
+	Before: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			logging.Errorln("You must specify the job identifier")
+			return ErrWrongArguments
+		}
+		if _, err := strconv.ParseUint(ctx.Args().First(), 10, 64); err != nil {
+			logging.Errorf("Invalid job identifier \"%s\"\n", ctx.Args().First())
+			return ErrWrongArguments
+		}
+		return nil
+	},
+	Action: runShowJob,
+}
+
+func runShowJob(ctx *cli.Context) {
+	// Connect to the master
+	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Create client proxy
+	client := NewClient(conn)
+	defer client.Close()
+
+	// Print job details
+	id, _ := strconv.ParseUint(ctx.Args().First(), 10, 64)
+	if err = client.ShowJob(id); err != nil {
+		logging.Errorln(err)
+		return
+	}
+}
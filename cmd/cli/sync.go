@@ -0,0 +1,323 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"github.com/codegangsta/cli"
+	"github.com/hawaii-desktop/builder/logging"
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+)
+
+var CmdSync = cli.Command{
+	Name:  "sync",
+	Usage: "Make the master match a file",
+	Description: `Compare a YAML file in the import format with the master and
+   add, update or remove chroots, packages, images and projects so that
+   the master matches the file.  Entries not in the file, disabled or
+   listed for removal are removed from the master.
+
+   Changes are printed prefixed by + (add), ~ (update) and - (remove).`,
+	Before: func(ctx *cli.Context) error {
+		if !ctx.IsSet("filename") {
+			logging.Errorln("You must specify the file to synchronize with")
+			return ErrWrongArguments
+		}
+		if ctx.Bool("dry-run") == ctx.Bool("apply") {
+			logging.Errorln("You must specify either --dry-run or --apply")
+			return ErrWrongArguments
+		}
+		return nil
+	},
+	Action: runSync,
+	Flags: []cli.Flag{
+		cli.StringFlag{"filename, f", "", "file to synchronize with", ""},
+		cli.BoolFlag{"dry-run, n", "only print what would be changed", ""},
+		cli.BoolFlag{"apply", "apply the changes", ""},
+	},
+}
+
+// Change to make on the master.
+type syncAction struct {
+	// Symbol printed before the description.
+	Symbol string
+	// Description.
+	Description string
+	// Apply the change.
+	Apply func() error
+}
+
+func runSync(ctx *cli.Context) {
+	// Open file
+	yamlFile, err := ioutil.ReadFile(ctx.String("filename"))
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Unmarshal
+	var desired Data
+	err = yaml.Unmarshal(yamlFile, &desired)
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Connect to the master
+	conn, err := grpc.Dial(Config.Master.Address, grpc.WithInsecure())
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Create client proxy
+	client := NewClient(conn)
+	defer client.Close()
+
+	// Retrieve the current state
+	current, err := fetchData(client)
+	if err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Print and apply the changes
+	failed := false
+	for _, action := range syncActions(client, &desired, current) {
+		fmt.Printf("%s %s\n", action.Symbol, action.Description)
+		if ctx.Bool("apply") {
+			if err = action.Apply(); err != nil {
+				logging.Errorf("Failed to %s: %s\n", action.Description, err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		client.Close()
+		os.Exit(1)
+	}
+}
+
+// Return the changes needed for current to match desired: additions
+// and updates come first, then removals in reverse dependency order.
+func syncActions(client *Client, desired, current *Data) []*syncAction {
+	var actions, removals []*syncAction
+
+	// Chroots
+	removedChroots := make(map[ChrootEntry]bool)
+	for _, chroot := range desired.RemoveChroots {
+		removedChroots[chroot] = true
+	}
+	wantedChroots := make(map[ChrootEntry]bool)
+	for _, chroot := range desired.AddChroots {
+		if !removedChroots[chroot] {
+			wantedChroots[chroot] = true
+		}
+	}
+	currentChroots := make(map[ChrootEntry]bool)
+	for _, chroot := range current.AddChroots {
+		currentChroots[chroot] = true
+		if !wantedChroots[chroot] {
+			chroot := chroot
+			removals = append(removals, &syncAction{"-",
+				fmt.Sprintf("remove chroot \"%s-%s-%s\"", chroot.Release, chroot.Version, chroot.Architecture),
+				func() error { return client.RemoveChroot(chroot.Release, chroot.Version, chroot.Architecture) }})
+		}
+	}
+	for _, chroot := range desired.AddChroots {
+		if wantedChroots[chroot] && !currentChroots[chroot] {
+			currentChroots[chroot] = true
+			chroot := chroot
+			actions = append(actions, &syncAction{"+",
+				fmt.Sprintf("add chroot \"%s-%s-%s\"", chroot.Release, chroot.Version, chroot.Architecture),
+				func() error { return client.AddChroot(chroot.Release, chroot.Version, chroot.Architecture) }})
+		}
+	}
+
+	// Packages
+	removed := stringSet(desired.RemovePackages)
+	wantedPackages := make(map[string]bool)
+	currentPackages := make(map[string]PackageEntry)
+	for _, pkg := range current.AddPackages {
+		pkg.normalize()
+		currentPackages[pkg.Name] = pkg
+	}
+	for _, pkg := range desired.AddPackages {
+		if pkg.Disabled || removed[pkg.Name] {
+			continue
+		}
+		pkg.normalize()
+		wantedPackages[pkg.Name] = true
+		old, found := currentPackages[pkg.Name]
+		if found && packageEntriesEqual(old, pkg) {
+			continue
+		}
+		symbol, verb := "+", "add"
+		if found {
+			symbol, verb = "~", "update"
+		}
+		pkg := pkg
+		actions = append(actions, &syncAction{symbol,
+			fmt.Sprintf("%s package \"%s\"", verb, pkg.Name),
+			func() error { return addPackageEntry(client, pkg) }})
+	}
+	var packageRemovals []*syncAction
+	for _, pkg := range current.AddPackages {
+		if !wantedPackages[pkg.Name] {
+			name := pkg.Name
+			packageRemovals = append(packageRemovals, &syncAction{"-",
+				fmt.Sprintf("remove package \"%s\"", name),
+				func() error { return client.RemovePackage(name) }})
+		}
+	}
+
+	// Images
+	removed = stringSet(desired.RemoveImages)
+	wantedImages := make(map[string]bool)
+	currentImages := make(map[string]ImageEntry)
+	for _, img := range current.AddImages {
+		img.normalize()
+		currentImages[img.Name] = img
+	}
+	for _, img := range desired.AddImages {
+		if img.Disabled || removed[img.Name] {
+			continue
+		}
+		img.normalize()
+		wantedImages[img.Name] = true
+		old, found := currentImages[img.Name]
+		if found && imageEntriesEqual(old, img) {
+			continue
+		}
+		symbol, verb := "+", "add"
+		if found {
+			symbol, verb = "~", "update"
+		}
+		img := img
+		actions = append(actions, &syncAction{symbol,
+			fmt.Sprintf("%s image \"%s\"", verb, img.Name),
+			func() error { return addImageEntry(client, img) }})
+	}
+	var imageRemovals []*syncAction
+	for _, img := range current.AddImages {
+		if !wantedImages[img.Name] {
+			name := img.Name
+			imageRemovals = append(imageRemovals, &syncAction{"-",
+				fmt.Sprintf("remove image \"%s\"", name),
+				func() error { return client.RemoveImage(name) }})
+		}
+	}
+
+	// Projects
+	removed = stringSet(desired.RemoveProjects)
+	wantedProjects := make(map[string]bool)
+	currentProjects := make(map[string]ProjectEntry)
+	for _, prj := range current.AddProjects {
+		currentProjects[prj.Name] = prj
+	}
+	for _, prj := range desired.AddProjects {
+		if prj.Disabled || removed[prj.Name] {
+			continue
+		}
+		wantedProjects[prj.Name] = true
+		old, found := currentProjects[prj.Name]
+		if found && projectEntriesEqual(old, prj) {
+			continue
+		}
+		symbol, verb := "+", "add"
+		if found {
+			symbol, verb = "~", "update"
+		}
+		prj := prj
+		actions = append(actions, &syncAction{symbol,
+			fmt.Sprintf("%s project \"%s\"", verb, prj.Name),
+			func() error {
+				return client.AddProject(prj.Name, prj.Description, prj.Repos, prj.Chroots,
+					prj.AutoCreateRepo, prj.BuildEnableNet)
+			}})
+	}
+	var projectRemovals []*syncAction
+	for _, prj := range current.AddProjects {
+		if !wantedProjects[prj.Name] {
+			name := prj.Name
+			projectRemovals = append(projectRemovals, &syncAction{"-",
+				fmt.Sprintf("remove project \"%s\"", name),
+				func() error { return client.RemoveProject(name) }})
+		}
+	}
+
+	// Chroots are removed last since projects refer to them
+	actions = append(actions, projectRemovals...)
+	actions = append(actions, imageRemovals...)
+	actions = append(actions, packageRemovals...)
+	return append(actions, removals...)
+}
+
+// Return a set with the strings of a list.
+func stringSet(list []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, s := range list {
+		set[s] = true
+	}
+	return set
+}
+
+// Return a sorted copy of a list.
+func sortedCopy(list []string) []string {
+	result := append([]string{}, list...)
+	sort.Strings(result)
+	return result
+}
+
+// Compare two normalized package entries ignoring architectures order.
+func packageEntriesEqual(a, b PackageEntry) bool {
+	a.Architectures = sortedCopy(a.Architectures)
+	b.Architectures = sortedCopy(b.Architectures)
+	a.Disabled, b.Disabled = false, false
+	return reflect.DeepEqual(a, b)
+}
+
+// Compare two normalized image entries ignoring architectures order.
+func imageEntriesEqual(a, b ImageEntry) bool {
+	a.Architectures = sortedCopy(a.Architectures)
+	b.Architectures = sortedCopy(b.Architectures)
+	a.Disabled, b.Disabled = false, false
+	return reflect.DeepEqual(a, b)
+}
+
+// Compare two project entries ignoring chroots order.
+func projectEntriesEqual(a, b ProjectEntry) bool {
+	a.Chroots = sortedCopy(a.Chroots)
+	b.Chroots = sortedCopy(b.Chroots)
+	a.Disabled, b.Disabled = false, false
+	return reflect.DeepEqual(a, b)
+}
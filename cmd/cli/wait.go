@@ -0,0 +1,78 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
+	"os"
+	"path/filepath"
+)
+
+// Wait for a job to finish, then download its artifacts
+// into dir unless dir is empty.
+// Return an error if the job didn't complete successfully.
+func waitForJob(client *Client, id uint64, dir string) error {
+	job, err := client.WatchJob(id)
+	if err != nil {
+		return err
+	}
+	if job.Status != pb.EnumJobStatus_JOB_STATUS_SUCCESSFUL {
+		return fmt.Errorf("job #%d has finished with status \"%s\"",
+			id, jobStatusDescriptionMap[job.Status])
+	}
+	logging.Infof("Job #%d completed successfully\n", id)
+
+	if dir == "" {
+		return nil
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, artifact := range job.Artifacts {
+		dstfilename := filepath.Join(dir, filepath.Base(artifact))
+		logging.Infof("Downloading \"%s\"...\n", filepath.Base(artifact))
+		image := job.Type == pb.EnumTargetType_IMAGE
+		if err = client.DownloadFile(artifact, dstfilename, image); err != nil {
+			return fmt.Errorf("unable to download \"%s\": %s", artifact, err)
+		}
+	}
+
+	return nil
+}
+
+// Exit with an error when the build was requested with --wait, this
+// way scripts know whether the build was successful.
+func exitIfWaiting(client *Client, wait bool) {
+	if wait {
+		if client != nil {
+			client.Close()
+		}
+		os.Exit(1)
+	}
+}
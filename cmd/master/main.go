@@ -133,6 +133,10 @@ func runMaster(ctx *cli.Context) {
 	webServer.Router.GET("/jobs/dispatched", master.WebJobsDispatchedHandler)
 	webServer.Router.GET("/jobs/completed", master.WebJobsCompletedHandler)
 	webServer.Router.GET("/jobs/failed", master.WebJobsFailedHandler)
+	webServer.Router.GET("/log/:id", master.WebLogHandler)
+	webServer.Router.GET("/package/:name", master.WebPackageHandler)
+	webServer.Router.GET("/images", master.WebImagesHandler)
+	webServer.Router.GET("/slaves", master.WebSlavesHandler)
 	webServer.Router.Static("/css", http.Dir(master.Config.Web.StaticDir+"/css"))
 	webServer.Router.Static("/js", http.Dir(master.Config.Web.StaticDir+"/js"))
 	webServer.Router.Static("/img", http.Dir(master.Config.Web.StaticDir+"/img"))
@@ -160,6 +164,18 @@ func runMaster(ctx *cli.Context) {
 		return
 	}
 
+	// Move logs of old jobs out of the database
+	if err := m.MigrateStepLogs(); err != nil {
+		logging.Errorln(err)
+		return
+	}
+
+	// Import the signing key and publish the public key
+	if err := m.PrepareSigning(); err != nil {
+		logging.Errorln(err)
+		return
+	}
+
 	// Process repodata updates
 	m.ProcessRepoDataUpdates()
 
@@ -32,15 +32,16 @@ import (
 	"github.com/hawaii-desktop/builder/logging"
 	"github.com/hawaii-desktop/builder/pidfile"
 	"github.com/hawaii-desktop/builder/slave"
+	"github.com/hawaii-desktop/builder/utils"
 	"github.com/hawaii-desktop/builder/version"
 	"golang.org/x/net/context"
-	"google.golang.org/grpc"
 	"gopkg.in/gcfg.v1"
 	"os"
 	"os/signal"
 	"os/user"
 	"runtime"
 	"runtime/pprof"
+	"syscall"
 	"time"
 )
 
@@ -58,25 +59,46 @@ func main() {
 		cli.StringFlag{"config, c", "", "Custom configuration file path", ""},
 		cli.StringFlag{"cpuprofile", "", "Write CPU profile to file", ""},
 	}
+	app.Commands = []cli.Command{
+		{
+			Name:  "prune-cache",
+			Usage: "Remove unused git mirrors",
+			Description: `Remove git mirrors from the cache directory that were not used
+   by any job for the specified time, mirrors being used are skipped.`,
+			Action: runPruneCache,
+			Flags: []cli.Flag{
+				cli.DurationFlag{"max-age", 30 * 24 * time.Hour, "remove mirrors unused for this long", ""},
+			},
+		},
+		{
+			Name:  "workspace",
+			Usage: "Manage workspaces and caches",
+			Subcommands: []cli.Command{
+				{
+					Name:   "list",
+					Usage:  "List workspaces and git mirrors, least recently used first",
+					Action: runWorkspaceList,
+				},
+				{
+					Name:  "gc",
+					Usage: "Remove least recently used workspaces and git mirrors",
+					Description: `Remove workspaces and git mirrors, least recently used first, until
+   the requested space is free; those being used are skipped.`,
+					Action: runWorkspaceGc,
+					Flags: []cli.Flag{
+						cli.StringFlag{"free", "", "free space to aim for (for example: 50G), defaults to GcFreeSpace", ""},
+					},
+				},
+			},
+		},
+	}
 	app.Run(os.Args)
 }
 
-func runSlave(ctx *cli.Context) {
-	// CPU profile
-	if ctx.IsSet("cpuprofile") {
-		file, err := os.Create(ctx.String("cpuprofile"))
-		if err != nil {
-			logging.Fatalf("Unable to create \"%s\": %s\n", ctx.String("cpuprofile"), err)
-		}
-		pprof.StartCPUProfile(file)
-		defer pprof.StopCPUProfile()
-	}
-
-	// Load the configuration
-	var configArg string
-	if ctx.IsSet("config") {
-		configArg = ctx.String("config")
-	} else {
+// Load the configuration from the file specified on the command
+// line or the first one found in the standard locations.
+func loadConfig(configArg string) {
+	if configArg == "" {
 		user, _ := user.Current()
 		possible := []string{
 			user.HomeDir + "/.config/builder/builder-slave.ini",
@@ -98,6 +120,80 @@ func runSlave(ctx *cli.Context) {
 	if err != nil {
 		logging.Fatalln(err)
 	}
+	if err := slave.CheckTimeouts(); err != nil {
+		logging.Fatalln(err)
+	}
+	if err := slave.CheckLimits(); err != nil {
+		logging.Fatalln(err)
+	}
+	if err := slave.CheckWorkspace(); err != nil {
+		logging.Fatalln(err)
+	}
+	if err := slave.CheckDebian(); err != nil {
+		logging.Fatalln(err)
+	}
+}
+
+func runPruneCache(ctx *cli.Context) {
+	loadConfig(ctx.GlobalString("config"))
+
+	removed, err := slave.PruneGitMirrors(ctx.Duration("max-age"))
+	if err != nil {
+		logging.Fatalln(err)
+	}
+	for _, mirror := range removed {
+		logging.Infof("Removed \"%s\"\n", mirror)
+	}
+	logging.Infof("%d mirror(s) removed\n", len(removed))
+}
+
+func runWorkspaceList(ctx *cli.Context) {
+	loadConfig(ctx.GlobalString("config"))
+
+	workspaces, err := slave.ListWorkspaces()
+	if err != nil {
+		logging.Fatalln(err)
+	}
+	for _, w := range workspaces {
+		fmt.Printf("%s\t%s\t%s\t%s\n", w.LastUsed.Format(time.RFC3339),
+			slave.WorkspaceKindDescriptionMap[w.Kind], utils.FormatSize(w.Size()), w.Path)
+	}
+}
+
+func runWorkspaceGc(ctx *cli.Context) {
+	loadConfig(ctx.GlobalString("config"))
+
+	_, size := slave.FreeSpaceWatermarks()
+	if ctx.IsSet("free") {
+		var err error
+		if size, err = utils.ParseSize(ctx.String("free")); err != nil {
+			logging.Fatalln(err)
+		}
+	}
+	if size == 0 {
+		logging.Fatalln("Please specify how much space should be free")
+	}
+
+	removed, err := slave.CollectGarbage(size)
+	if err != nil {
+		logging.Fatalln(err)
+	}
+	logging.Infof("%d workspace(s) removed\n", len(removed))
+}
+
+func runSlave(ctx *cli.Context) {
+	// CPU profile
+	if ctx.IsSet("cpuprofile") {
+		file, err := os.Create(ctx.String("cpuprofile"))
+		if err != nil {
+			logging.Fatalf("Unable to create \"%s\": %s\n", ctx.String("cpuprofile"), err)
+		}
+		pprof.StartCPUProfile(file)
+		defer pprof.StopCPUProfile()
+	}
+
+	// Load the configuration
+	loadConfig(ctx.String("config"))
 
 	// Override configuration
 	if ctx.IsSet("name") {
@@ -115,6 +211,11 @@ func runSlave(ctx *cli.Context) {
 		logging.Fatalln("You must specify the supported architectures")
 	}
 
+	// Jobs run in cgroups with resource limits
+	if err := slave.SetupCgroup(); err != nil {
+		logging.Fatalf("Unable to set up cgroups: %s\n", err)
+	}
+
 	// Acquire PID file
 	if os.Getuid() == 0 {
 		pidFile, err := pidfile.New(fmt.Sprintf("/run/builder/slave-%s.pid", slave.Config.Slave.Name))
@@ -128,69 +229,56 @@ func runSlave(ctx *cli.Context) {
 		defer pidFile.Unlock()
 	}
 
-	// Connect to the master
-	conn, err := grpc.Dial(slave.Config.Master.Address, grpc.WithInsecure(), grpc.WithTimeout(5*time.Second))
-	defer conn.Close()
-
-	// We are finally connected
-	logging.Infoln("Connected to master")
-
 	// Channel used to close all goroutines for job processing
 	waitc := make(chan struct{})
 
 	// Client object
-	client := slave.NewClient(conn)
-
-	// Subscribe
-	clientCtx, err := client.Subscribe()
-	if err != nil {
-		logging.Errorf("Unable to register slave: %s\n", err)
-		return
-	}
+	client := slave.NewClient()
 
 	// Unsubscribe and close the connection when quitting
-	defer func(ctx context.Context) {
-		if conn.State() == grpc.Ready {
-			client.Unsubscribe(ctx)
+	defer func() {
+		if client.IsConnected() {
+			client.Unsubscribe()
 		}
 		client.Close()
-	}(clientCtx)
-
-	// Pick up jobs from the master
-	go func() {
-		if err := client.PickJob(clientCtx, waitc); err != nil {
-			logging.Errorf("Failed to pick up jobs: %s\n", err)
-			return
-		}
 	}()
 
+	// Running jobs are cancelled when quitting
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+
+	// Connect to the master and pick up jobs, connecting
+	// again whenever the connection is lost
+	go client.Serve(jobsCtx, slave.Config.Master.Address, waitc)
+
 	// Channel used to quit the program
-	quitc := make(chan bool)
+	quitc := make(chan bool, 2)
 
-	// Quit when the connection is stopped
-	// TODO: We need to register the slave again if the master is restarted
+	// Exit with SIGINT, SIGTERM drains first: the jobs that were
+	// dispatched to us finish and no new job is accepted, unless
+	// another signal is received in the meantime
 	go func() {
-		for {
-			if conn.State() != grpc.Ready {
+		sigchan := make(chan os.Signal, 2)
+		signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
+		if sig := <-sigchan; sig == syscall.SIGTERM {
+			logging.Infoln("Draining, send another signal to quit immediately")
+			go func() {
+				client.Drain()
 				quitc <- true
-				return
-			}
+			}()
+			<-sigchan
 		}
-	}()
-
-	// Gracefully exit with SIGINT and SIGTERM
-	go func() {
-		sigchan := make(chan os.Signal, 2)
-		signal.Notify(sigchan, os.Interrupt)
-		signal.Notify(sigchan, os.Kill)
-		<-sigchan
 		quitc <- true
 	}()
 
 	// Wait for the conditions to quit
 	<-quitc
 
-	// Now quit
+	// Now quit, commands run in their own process group and
+	// don't receive our signals so they are terminated here
 	logging.Traceln("Quitting...")
+	cancelJobs()
+	if !slave.WaitForCommands(time.Minute) {
+		logging.Warningln("Some commands are still running")
+	}
 	close(waitc)
 }
@@ -0,0 +1,130 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"time"
+)
+
+// Build of a package for a chroot.
+type Build struct {
+	// Package name.
+	Package string `json:"package"`
+	// Chroot in the <distro>-<release>-<arch> format.
+	Chroot string `json:"chroot"`
+	// Name, epoch, version and release.
+	Nevr string `json:"nevr"`
+	// Packaging commit hash.
+	PackagingCommit string `json:"packaging_commit,omitempty"`
+	// Upstream commit hash, only for CI packages.
+	UpstreamCommit string `json:"upstream_commit,omitempty"`
+	// Artifacts.
+	Artifacts []*BuildArtifact `json:"artifacts"`
+	// Job that produced the build.
+	JobId uint64 `json:"job_id"`
+	// When the build has finished.
+	Finished time.Time `json:"finished"`
+	// Upstream changes since the previous build, only for CI packages.
+	Changes []string `json:"changes,omitempty"`
+}
+
+// Artifact produced by a build.
+type BuildArtifact struct {
+	// File name, relative to the repository directory.
+	FileName string `json:"filename"`
+	// Size in bytes.
+	Size int64 `json:"size"`
+	// SHA256 checksum.
+	Sha256 string `json:"sha256"`
+}
+
+// Return the key prefix of builds of a package, for a chroot
+// unless chroot is empty.
+func buildKeyPrefix(pkg, chroot string) string {
+	if chroot == "" {
+		return pkg + "/"
+	}
+	return pkg + "/" + chroot + "/"
+}
+
+// Store a build.
+// Builds are keyed by package and chroot, followed by the job
+// identifier so that the history is preserved in chronological order.
+func (db *Database) SaveBuild(build *Build) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("build"))
+		if err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(build)
+		if err != nil {
+			return err
+		}
+
+		key := fmt.Sprintf("%s%020d", buildKeyPrefix(build.Package, build.Chroot), build.JobId)
+		return bucket.Put([]byte(key), encoded)
+	})
+}
+
+// Return builds of a package that match a certain criteria, for all
+// packages if pkg is empty and all chroots if chroot is empty.
+func (db *Database) FilterBuilds(pkg, chroot string, filter func(build *Build) bool) []*Build {
+	var list []*Build
+	db.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("build"))
+		if bucket == nil {
+			return nil
+		}
+
+		prefix := []byte{}
+		if pkg != "" {
+			prefix = []byte(buildKeyPrefix(pkg, chroot))
+		}
+
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var build *Build
+			if err := json.Unmarshal(v, &build); err != nil {
+				return err
+			}
+			if chroot != "" && build.Chroot != chroot {
+				continue
+			}
+			if filter(build) {
+				list = append(list, build)
+			}
+		}
+
+		return nil
+	})
+	return list
+}
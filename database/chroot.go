@@ -34,9 +34,9 @@ import (
 )
 
 type Chroot struct {
-	// Release (fedora, epel, ...)
+	// Release (fedora, epel, debian, ubuntu, ...)
 	OsRelease string `json:"release"`
-	// Version (22, 23, rawhide, ...)
+	// Version (22, 23, rawhide, sid, xenial, ...)
 	OsVersion string `json:"version"`
 	// Architecture (x86_64, i386, armhfp, ...)
 	Architecture string `json:"arch"`
@@ -108,6 +108,26 @@ func (db *Database) GetChroot(release, version, arch string) *Chroot {
 	return chroot
 }
 
+// Return the active chroot with release, version and architecture
+// from the database.  An empty version matches any version, provided
+// that only one chroot is active for release and architecture.
+func (db *Database) FindActiveChroot(release, version, arch string) (*Chroot, error) {
+	var chroots []*Chroot
+	db.ForEachChroot(func(c *Chroot) {
+		if c.Active && c.OsRelease == release && c.Architecture == arch &&
+			(version == "" || c.OsVersion == version) {
+			chroots = append(chroots, c)
+		}
+	})
+	switch len(chroots) {
+	case 0:
+		return nil, ErrChrootNotFound
+	case 1:
+		return chroots[0], nil
+	}
+	return nil, ErrAmbiguousChroot
+}
+
 // Add a chroot to the database.
 func (db *Database) AddChroot(chroot *Chroot) error {
 	name := fmt.Sprintf("%s-%s-%s", chroot.OsRelease, chroot.OsVersion, chroot.Architecture)
@@ -41,7 +41,9 @@ type Database struct {
 
 // Errors
 var (
-	ErrBucketNotFound = errors.New("bucket not found")
+	ErrBucketNotFound  = errors.New("bucket not found")
+	ErrChrootNotFound  = errors.New("no active chroot found")
+	ErrAmbiguousChroot = errors.New("more than one active chroot, a release version is needed")
 )
 
 // Create and open a database.
@@ -30,13 +30,36 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/boltdb/bolt"
+	"time"
 )
 
 type Image struct {
-	Name          string   `json:"name"`
-	Description   string   `json:"descr"`
-	Architectures []string `json:"archs"`
-	Vcs           VcsInfo  `json:"vcs"`
+	Name          string            `json:"name"`
+	Description   string            `json:"descr"`
+	Architectures []string          `json:"archs"`
+	Vcs           VcsInfo           `json:"vcs"`
+	Kickstart     string            `json:"kickstart,omitempty"`
+	Format        string            `json:"format,omitempty"`
+	Product       string            `json:"product,omitempty"`
+	ReleaseVer    string            `json:"releasever,omitempty"`
+	Variables     map[string]string `json:"vars,omitempty"`
+	Timeout       time.Duration     `json:"timeout,omitempty"`
+}
+
+// Image output formats.
+const (
+	IMAGE_FORMAT_LIVE  = "live"
+	IMAGE_FORMAT_RAW   = "raw"
+	IMAGE_FORMAT_QCOW2 = "qcow2"
+)
+
+// Return whether the image format is known.
+func IsValidImageFormat(format string) bool {
+	switch format {
+	case IMAGE_FORMAT_LIVE, IMAGE_FORMAT_RAW, IMAGE_FORMAT_QCOW2:
+		return true
+	}
+	return false
 }
 
 // Return whether the image was stored into the db.
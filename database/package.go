@@ -30,14 +30,22 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/boltdb/bolt"
+	"time"
 )
 
 type Package struct {
-	Name          string   `json:"name"`
-	Architectures []string `json:"architectures"`
-	Ci            bool     `json:"ci"`
-	Vcs           VcsInfo  `json:"vcs"`
-	UpstreamVcs   VcsInfo  `json:"upstream_vcs"`
+	Name          string        `json:"name"`
+	Architectures []string      `json:"architectures"`
+	Ci            bool          `json:"ci"`
+	Vcs           VcsInfo       `json:"vcs"`
+	UpstreamVcs   VcsInfo       `json:"upstream_vcs"`
+	Distribution  string        `json:"distro,omitempty"`
+	ReleaseVer    string        `json:"releasever,omitempty"`
+	Project       string        `json:"project,omitempty"`
+	Timeout       time.Duration `json:"timeout,omitempty"`
+	MemoryLimit   uint64        `json:"memory_limit,omitempty"`
+	CpuLimit      uint32        `json:"cpu_limit,omitempty"`
+	PidsLimit     uint32        `json:"pids_limit,omitempty"`
 }
 
 // Return whether the package was stored into the db.
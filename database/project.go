@@ -122,32 +122,35 @@ func (db *Database) GetProject(name string) *Project {
 	return prj
 }
 
-// Add a project to the database.
+// Add or update a project into the database.
+// Updated projects keep their Web hook secret and creation time.
 func (db *Database) AddProject(prj *Project) error {
-	encoded, err := json.Marshal(prj)
-	if err != nil {
-		return err
-	}
-
-	err = db.db.Update(func(tx *bolt.Tx) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte("project"))
 		if err != nil {
 			return err
 		}
 
-		now := time.Now().Format(time.RFC3339)
-		prj.WebHookSecret = fmt.Sprintf("%x", sha1.Sum([]byte(now)))
-		prj.Created = time.Now()
+		var old *Project
+		if v := bucket.Get([]byte(prj.Name)); v != nil {
+			json.Unmarshal(v, &old)
+		}
+		if old != nil && old.WebHookSecret != "" {
+			prj.WebHookSecret = old.WebHookSecret
+			prj.Created = old.Created
+		} else {
+			now := time.Now().Format(time.RFC3339)
+			prj.WebHookSecret = fmt.Sprintf("%x", sha1.Sum([]byte(now)))
+			prj.Created = time.Now()
+		}
 
-		err = bucket.Put([]byte(prj.Name), encoded)
+		encoded, err := json.Marshal(prj)
 		if err != nil {
 			return err
 		}
 
-		return nil
+		return bucket.Put([]byte(prj.Name), encoded)
 	})
-
-	return err
 }
 
 // Remove a project from the database.
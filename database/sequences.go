@@ -71,3 +71,24 @@ func (db *Database) NewSlaveId() uint64 {
 
 	return id
 }
+
+// Return a new unique log id.
+func (db *Database) NewLogId() uint64 {
+	var id uint64
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("log"))
+		if err != nil {
+			return err
+		}
+
+		id, err = bucket.NextSequence()
+		return err
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	return id
+}
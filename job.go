@@ -41,6 +41,10 @@ type Job struct {
 	Target string `json:"target"`
 	// Architecture.
 	Architecture string `json:"arch"`
+	// Distribution.
+	Distribution string `json:"distro,omitempty"`
+	// Name of the slave that picked the job up.
+	Slave string `json:"slave,omitempty"`
 	// When the job has started.
 	Started time.Time `json:"started"`
 	// When the job has finished.
@@ -49,6 +53,18 @@ type Job struct {
 	Status JobStatus `json:"status"`
 	// Build steps.
 	Steps []*Step `json:"steps"`
+	// Artifacts, relative to the repository directory or to the
+	// images directory for images.
+	Artifacts []string `json:"artifacts,omitempty"`
+	// Packaging commit hash or tag requested, the branch head when empty.
+	PackagingRevision string `json:"packaging_revision,omitempty"`
+	// Upstream commit hash or tag requested, the branch head when empty.
+	UpstreamRevision string `json:"upstream_revision,omitempty"`
+	// Packaging commit hash that was built, for images the
+	// kickstart repository commit hash.
+	PackagingCommit string `json:"packaging_commit,omitempty"`
+	// Upstream commit hash that was built, only for CI packages.
+	UpstreamCommit string `json:"upstream_commit,omitempty"`
 	// Mutex that serialize access to this job.
 	Mutex sync.Mutex `json:"-"`
 }
@@ -63,8 +79,25 @@ type Step struct {
 	Finished time.Time `json:"finished"`
 	// Summary.
 	Summary map[string][]string `json:"summary,omitempty"`
-	// Additional logs.
+	// Additional logs, only for jobs saved before logs were
+	// stored separately.
 	Logs map[string][]byte `json:"logs,omitempty"`
+	// References to the additional logs.
+	LogRefs map[string]*StepLog `json:"log_refs,omitempty"`
+	// Peak memory usage in bytes, when known.
+	PeakMemory uint64 `json:"peak_memory,omitempty"`
+	// CPU time, when known.
+	CpuTime time.Duration `json:"cpu_time,omitempty"`
+}
+
+// StepLog references a build step log stored outside the job.
+type StepLog struct {
+	// Identifier.
+	Id uint64 `json:"id"`
+	// Size in bytes.
+	Size int64 `json:"size"`
+	// Whether the log was truncated because too big.
+	Truncated bool `json:"truncated,omitempty"`
 }
 
 // Job target type enumeration.
@@ -75,15 +108,26 @@ const (
 	JOB_TARGET_TYPE_IMAGE
 )
 
+// Map job target type to name.
+var JobTargetTypeNameMap = map[JobTargetType]string{
+	JOB_TARGET_TYPE_PACKAGE: "package",
+	JOB_TARGET_TYPE_IMAGE:   "image",
+}
+
 // String returns a string representation of the target type.
 func (t JobTargetType) String() string {
-	switch t {
-	case JOB_TARGET_TYPE_PACKAGE:
-		return "package"
-	case JOB_TARGET_TYPE_IMAGE:
-		return "image"
+	return JobTargetTypeNameMap[t]
+}
+
+// Distribution used when a job doesn't specify one.
+const DEFAULT_DISTRIBUTION = "fedora"
+
+// Return the job distribution or the default one.
+func (j *Job) DistributionName() string {
+	if j.Distribution == "" {
+		return DEFAULT_DISTRIBUTION
 	}
-	return ""
+	return j.Distribution
 }
 
 // Job status enumeration.
@@ -96,6 +140,7 @@ const (
 	JOB_STATUS_SUCCESSFUL
 	JOB_STATUS_FAILED
 	JOB_STATUS_CRASHED
+	JOB_STATUS_TIMED_OUT
 )
 
 // Map job status to description.
@@ -106,4 +151,5 @@ var JobStatusDescriptionMap = map[JobStatus]string{
 	JOB_STATUS_SUCCESSFUL:   "Successful",
 	JOB_STATUS_FAILED:       "Failed",
 	JOB_STATUS_CRASHED:      "Crashed",
+	JOB_STATUS_TIMED_OUT:    "TimedOut",
 }
@@ -36,6 +36,8 @@ type Settings struct {
 	Storage struct {
 		RepositoryDir string
 		ImagesDir     string
+		LogsDir       string
+		MaxLogSize    int64
 	}
 	Notifications struct {
 		Slack bool
@@ -57,6 +59,12 @@ type Settings struct {
 		MaxJobs   uint32
 		MaxSlaves uint32
 	}
+	Signing struct {
+		KeyId          string
+		GpgHome        string
+		KeyFile        string
+		PassphraseFile string
+	}
 }
 
 // Global configuration object.
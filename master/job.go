@@ -36,18 +36,18 @@ type Job struct {
 	*builder.Job
 	// Channel.
 	Channel chan bool `json:"-"`
+	// Release version of the artifacts uploaded.
+	releaseVer string
 }
 
-// Return the slave topic name based in the <type>/<arch> format,
-// where type is the job type (package or image) and <arch> the
-// architecture (i386, x86_64, ...).
+// Return the slave topic name based in the <type>/<distro>/<arch> format,
+// where type is the job type (package or image), <distro> the
+// distribution (fedora, ...) and <arch> the architecture (i386, x86_64, ...).
 func (j *Job) TopicName() string {
-	switch j.Type {
-	case builder.JOB_TARGET_TYPE_PACKAGE:
-		return "package/" + j.Architecture
-	case builder.JOB_TARGET_TYPE_IMAGE:
-		return "image/" + j.Architecture
+	ttype := j.Type.String()
+	if ttype == "" {
+		panic("Unknown job type")
 	}
 
-	panic("Unknown job type")
+	return ttype + "/" + j.DistributionName() + "/" + j.Architecture
 }
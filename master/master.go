@@ -31,6 +31,7 @@ import (
 	"github.com/hawaii-desktop/builder"
 	"github.com/hawaii-desktop/builder/database"
 	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
 	"github.com/hawaii-desktop/builder/webserver"
 	"net"
 	"os"
@@ -48,11 +49,13 @@ type Master struct {
 	subscriptions map[*webserver.WebSocketConnection]*wsSubscription
 	// Buffered channel that we can send jobs on.
 	buildJobQueue chan *Job
-	// Map a slave topic (that is a combination of what job types and
-	// architectures supported by a slave, for example package/x86_64 for
-	// x86_64 packages) to a buffered channel that holds the job channels
-	// from each slave.
+	// Map a slave topic (that is a combination of what job types,
+	// distributions and architectures supported by a slave, for example
+	// package/fedora/x86_64 for Fedora x86_64 packages) to a buffered
+	// channel that holds the job channels from each slave.
 	slaveQueues map[string]chan chan *Job
+	// Protects slave queues.
+	qMutex sync.Mutex
 	// Broadcast queue for the web socket.
 	webSocketQueue chan interface{}
 	// List of jobs to be processed.
@@ -67,6 +70,20 @@ type Master struct {
 	repoBaseUrl string
 	// Channel where repodata updates are serialized to.
 	repoDataQueue chan bool
+	// Channels receiving the output of running jobs.
+	followers map[uint64][]chan *pb.JobOutput
+	// Last build step of the output of running jobs.
+	outputSteps map[uint64]string
+	// Protects job output.
+	oMutex sync.Mutex
+	// Channels notified when running jobs change.
+	watchers map[uint64][]chan bool
+	// Protects watchers.
+	wMutex sync.Mutex
+	// Protects image checksum files.
+	iMutex sync.Mutex
+	// RPC service, that knows the slaves.
+	service *RpcService
 }
 
 // Statistics to show on the Web user interface.
@@ -111,6 +128,9 @@ func NewMaster(hub *webserver.WebSocketHub) (*Master, error) {
 		stats:          statistics{0, 0, 0, 0, 0, 0},
 		repoBaseUrl:    "http://" + addr + "/repo",
 		repoDataQueue:  make(chan bool),
+		followers:      make(map[uint64][]chan *pb.JobOutput),
+		outputSteps:    make(map[uint64]string),
+		watchers:       make(map[uint64][]chan bool),
 	}, nil
 }
 
@@ -129,14 +149,24 @@ func (m *Master) PrepareTopics() {
 	// it has already been created
 	for _, ttype := range []string{"package", "image"} {
 		for _, arch := range []string{"i386", "x86_64", "armhfp"} {
-			topic := ttype + "/" + arch
-			if _, ok := m.slaveQueues[topic]; !ok {
-				m.slaveQueues[topic] = make(chan chan *Job, Config.Build.MaxSlaves)
-			}
+			m.slaveQueue(ttype + "/" + builder.DEFAULT_DISTRIBUTION + "/" + arch)
 		}
 	}
 }
 
+// Return the buffered channel for the topic, creating it if needed.
+// Topics are created on demand so that slaves can advertise factories
+// for new job types and distributions.
+func (m *Master) slaveQueue(topic string) chan chan *Job {
+	m.qMutex.Lock()
+	defer m.qMutex.Unlock()
+
+	if _, ok := m.slaveQueues[topic]; !ok {
+		m.slaveQueues[topic] = make(chan chan *Job, Config.Build.MaxSlaves)
+	}
+	return m.slaveQueues[topic]
+}
+
 // Create storage directories.
 func (m *Master) CreateStorage() error {
 	if err := os.MkdirAll(Config.Storage.RepositoryDir, 0755); err != nil {
@@ -145,6 +175,9 @@ func (m *Master) CreateStorage() error {
 	if err := os.MkdirAll(Config.Storage.ImagesDir, 0755); err != nil {
 		fmt.Errorf("Failed to create images storage \"%s\": %s\n", Config.Storage.ImagesDir, err)
 	}
+	if err := os.MkdirAll(Config.Storage.LogsDir, 0755); err != nil {
+		return fmt.Errorf("Failed to create logs storage \"%s\": %s\n", Config.Storage.LogsDir, err)
+	}
 	return nil
 }
 
@@ -168,7 +201,7 @@ func (m *Master) Dispatch() {
 
 				// Dispatch job based on job type and architecture
 				topic := j.TopicName()
-				slaveQueue := <-m.slaveQueues[topic]
+				slaveQueue := <-m.slaveQueue(topic)
 				logging.Tracef("Dispatching job #%d (topic %s)...\n", j.Id, topic)
 				slaveQueue <- j
 			}()
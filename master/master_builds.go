@@ -0,0 +1,152 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package master
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/hawaii-desktop/builder"
+	"github.com/hawaii-desktop/builder/database"
+	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Return the chroot a job was built for, in the
+// <distro>-<releasever>-<arch> format or <distro>-<arch>
+// when the distribution has no release version.
+func buildChroot(job *Job) string {
+	if job.releaseVer == "" {
+		return job.DistributionName() + "-" + job.Architecture
+	}
+	return job.DistributionName() + "-" + job.releaseVer + "-" + job.Architecture
+}
+
+// Return information on an artifact stored in the repository.
+func buildArtifact(relpath string) (*database.BuildArtifact, error) {
+	file, err := os.Open(filepath.Join(Config.Storage.RepositoryDir, relpath))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &database.BuildArtifact{
+		FileName: relpath,
+		Size:     size,
+		Sha256:   hex.EncodeToString(hasher.Sum(nil)),
+	}, nil
+}
+
+// Record a successful package build.
+func (m *Master) saveBuild(job *Job, update *pb.JobUpdateRequest) {
+	if job.Type != builder.JOB_TARGET_TYPE_PACKAGE {
+		return
+	}
+
+	build := &database.Build{
+		Package:         job.Target,
+		Chroot:          buildChroot(job),
+		Nevr:            update.Nevr,
+		PackagingCommit: job.PackagingCommit,
+		UpstreamCommit:  job.UpstreamCommit,
+		Artifacts:       make([]*database.BuildArtifact, 0, len(job.Artifacts)),
+		JobId:           job.Id,
+		Finished:        job.Finished,
+		Changes:         update.Changes,
+	}
+	for _, relpath := range job.Artifacts {
+		artifact, err := buildArtifact(relpath)
+		if err != nil {
+			logging.Errorf("Unable to checksum artifact \"%s\" of job #%d: %s\n", relpath, job.Id, err)
+			continue
+		}
+		build.Artifacts = append(build.Artifacts, artifact)
+	}
+
+	if err := m.db.SaveBuild(build); err != nil {
+		logging.Errorf("Unable to save build of job #%d: %s\n", job.Id, err)
+		return
+	}
+
+	// Update Web socket clients
+	m.updatePackageBuilds(build.Package)
+}
+
+// Return the last build of the job target for the same distribution
+// and architecture, whatever the release, or nil if never built.
+func (m *Master) lastBuild(job *Job) *database.Build {
+	var last *database.Build
+	prefix := job.DistributionName() + "-"
+	suffix := "-" + job.Architecture
+	m.db.FilterBuilds(job.Target, "", func(build *database.Build) bool {
+		if strings.HasPrefix(build.Chroot, prefix) && strings.HasSuffix(build.Chroot, suffix) {
+			if last == nil || build.Finished.After(last.Finished) {
+				last = build
+			}
+		}
+		return false
+	})
+	return last
+}
+
+// Convert a build to its protocol representation.
+func buildInfo(build *database.Build) *pb.BuildInfo {
+	info := &pb.BuildInfo{
+		Package:         build.Package,
+		Chroot:          build.Chroot,
+		Nevr:            build.Nevr,
+		PackagingCommit: build.PackagingCommit,
+		UpstreamCommit:  build.UpstreamCommit,
+		JobId:           build.JobId,
+		Finished:        timeToNano(build.Finished),
+		Changes:         build.Changes,
+	}
+	for _, artifact := range build.Artifacts {
+		info.Artifacts = append(info.Artifacts, &pb.BuildArtifact{
+			FileName: artifact.FileName,
+			Size:     artifact.Size,
+			Sha256:   artifact.Sha256,
+		})
+	}
+	return info
+}
+
+// Sort builds by finished time.
+type buildsByFinished []*database.Build
+
+func (l buildsByFinished) Len() int           { return len(l) }
+func (l buildsByFinished) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l buildsByFinished) Less(i, j int) bool { return l[i].Finished.Before(l[j].Finished) }
@@ -47,12 +47,17 @@ func (m *Master) LoadDatabaseJobs() {
 				Type:         job.Type,
 				Target:       job.Target,
 				Architecture: job.Architecture,
+				Distribution: job.Distribution,
 				Started:      job.Started,
 				Finished:     job.Finished,
 				Status:       job.Status,
 				Steps:        make([]*builder.Step, 0),
+
+				PackagingRevision: job.PackagingRevision,
+				UpstreamRevision:  job.UpstreamRevision,
 			},
 			make(chan bool),
+			"",
 		}
 		m.appendJob(j)
 		m.queueJob(j)
@@ -66,10 +71,18 @@ func (m *Master) saveDatabaseJob(job *Job) {
 		Type:         job.Type,
 		Target:       job.Target,
 		Architecture: job.Architecture,
+		Distribution: job.Distribution,
+		Slave:        job.Slave,
 		Started:      job.Started,
 		Finished:     job.Finished,
 		Status:       job.Status,
 		Steps:        job.Steps,
+		Artifacts:    job.Artifacts,
+
+		PackagingRevision: job.PackagingRevision,
+		UpstreamRevision:  job.UpstreamRevision,
+		PackagingCommit:   job.PackagingCommit,
+		UpstreamCommit:    job.UpstreamCommit,
 	}
 
 	if err := m.db.SaveJob(j); err != nil {
@@ -0,0 +1,192 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package master
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Name of the checksum file written in each image directory.
+const imageChecksumName = "CHECKSUM"
+
+// Match a line of the checksum file.
+var imageChecksumRegexp = regexp.MustCompile(`^SHA256 \((.+)\) = ([0-9a-f]{64})$`)
+
+// Image file published on the master.
+type imageFile struct {
+	Image    string `json:"image"`
+	FileName string `json:"filename"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+	Signed   bool   `json:"signed"`
+}
+
+// Return the final location of an uploaded image, images are
+// stored in a directory named after the image.
+func imagePath(rootimagesdir string, request *pb.UploadRequest) string {
+	for _, name := range []string{request.Image, request.FileName} {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+			return ""
+		}
+	}
+	if request.FileName == imageChecksumName || request.FileName == imageChecksumName+".asc" {
+		return ""
+	}
+	return filepath.Join(rootimagesdir, request.Image, request.FileName)
+}
+
+// Read the checksum file of an image directory and return
+// a map of file names to hex encoded SHA-256 hashes.
+func readImageChecksums(imagedir string) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	file, err := os.Open(filepath.Join(imagedir, imageChecksumName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sums, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		m := imageChecksumRegexp.FindStringSubmatch(scanner.Text())
+		if len(m) == 3 {
+			sums[m[1]] = m[2]
+		}
+	}
+	return sums, scanner.Err()
+}
+
+// Write the checksum file of an image directory, entries of files
+// that no longer exist are dropped. The file is signed when
+// signing is enabled.
+func writeImageChecksums(imagedir string, sums map[string]string) error {
+	var names []string
+	for name := range sums {
+		if _, err := os.Stat(filepath.Join(imagedir, name)); err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var contents string
+	for _, name := range names {
+		contents += fmt.Sprintf("SHA256 (%s) = %s\n", name, sums[name])
+	}
+
+	// Replace the file atomically so that clients never see it half written
+	filename := filepath.Join(imagedir, imageChecksumName)
+	if err := ioutil.WriteFile(filename+".tmp", []byte(contents), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		return err
+	}
+
+	// A stale signature would not match the new contents
+	if !signingEnabled() {
+		os.Remove(filename + ".asc")
+		return nil
+	}
+	return signDetached(filename)
+}
+
+// Record the SHA-256 hash of an image that was just received
+// and update the checksum file of its directory.
+func (m *Master) addImageChecksum(path string, hash []byte) {
+	m.iMutex.Lock()
+	imagedir := filepath.Dir(path)
+	sums, err := readImageChecksums(imagedir)
+	if err == nil {
+		sums[filepath.Base(path)] = hex.EncodeToString(hash)
+		err = writeImageChecksums(imagedir, sums)
+	}
+	m.iMutex.Unlock()
+
+	if err != nil {
+		logging.Errorf("Unable to update checksums of \"%s\": %s\n", imagedir, err)
+		return
+	}
+
+	m.updateImages()
+}
+
+// Return all the published images with their checksums.
+func (m *Master) listImages() []*imageFile {
+	m.iMutex.Lock()
+	defer m.iMutex.Unlock()
+
+	var images []*imageFile
+
+	dirs, err := ioutil.ReadDir(Config.Storage.ImagesDir)
+	if err != nil {
+		logging.Errorf("Unable to list images: %s\n", err)
+		return images
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		imagedir := filepath.Join(Config.Storage.ImagesDir, dir.Name())
+		sums, err := readImageChecksums(imagedir)
+		if err != nil {
+			logging.Errorf("Unable to read checksums of \"%s\": %s\n", imagedir, err)
+			continue
+		}
+		_, err = os.Stat(filepath.Join(imagedir, imageChecksumName+".asc"))
+		signed := err == nil
+
+		for name, sum := range sums {
+			stat, err := os.Stat(filepath.Join(imagedir, name))
+			if err != nil {
+				continue
+			}
+			images = append(images, &imageFile{
+				Image:    dir.Name(),
+				FileName: dir.Name() + "/" + name,
+				Size:     stat.Size(),
+				Sha256:   sum,
+				Signed:   signed,
+			})
+		}
+	}
+
+	return images
+}
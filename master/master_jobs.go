@@ -27,7 +27,9 @@
 package master
 
 import (
+	"github.com/hawaii-desktop/builder"
 	"github.com/hawaii-desktop/builder/logging"
+	"path/filepath"
 )
 
 // Append a job to the list of pending jobs.
@@ -76,6 +78,72 @@ func (m *Master) forEachJob(f func(j *Job)) {
 	}
 }
 
+// Record an artifact uploaded to the repository, or to the images
+// directory, for a running job with a path relative to rootdir.
+func (m *Master) addJobArtifact(id uint64, rootdir, path, releasever string) {
+	relpath, err := filepath.Rel(rootdir, path)
+	if err != nil {
+		logging.Errorf("Unable to record artifact \"%s\" of job #%d: %s\n", path, id, err)
+		return
+	}
+
+	if job := m.findJob(id); job != nil {
+		job.Mutex.Lock()
+		job.Artifacts = append(job.Artifacts, relpath)
+		if releasever != "" {
+			job.releaseVer = releasever
+		}
+		job.Mutex.Unlock()
+	}
+}
+
+// Return the pending job with the given identifier.
+// Jobs that were being processed when the master was restarted
+// are taken back from the database, because slaves keep building
+// them and report when they are finished.
+// Return nil if not found.
+func (m *Master) findJob(id uint64) *Job {
+	m.jobsMutex.Lock()
+	defer m.jobsMutex.Unlock()
+
+	for _, j := range m.jobs {
+		if j.Id == id {
+			return j
+		}
+	}
+
+	job := m.db.GetJob(id)
+	if job == nil || job.Status != builder.JOB_STATUS_PROCESSING {
+		return nil
+	}
+	j := &Job{
+		&builder.Job{
+			Id:           job.Id,
+			Type:         job.Type,
+			Target:       job.Target,
+			Architecture: job.Architecture,
+			Distribution: job.Distribution,
+			Slave:        job.Slave,
+			Started:      job.Started,
+			Finished:     job.Finished,
+			Status:       job.Status,
+			Steps:        job.Steps,
+			Artifacts:    job.Artifacts,
+
+			PackagingRevision: job.PackagingRevision,
+			UpstreamRevision:  job.UpstreamRevision,
+			PackagingCommit:   job.PackagingCommit,
+			UpstreamCommit:    job.UpstreamCommit,
+		},
+		// Nobody is waiting for the job to finish
+		make(chan bool, 1),
+		"",
+	}
+	m.jobs = append(m.jobs, j)
+	logging.Infof("Job #%d is still being processed by \"%s\"\n", j.Id, j.Slave)
+	return j
+}
+
 // Queue a job.
 func (m *Master) queueJob(j *Job) {
 	// Update Web socket clients
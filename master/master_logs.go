@@ -0,0 +1,278 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package master
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hawaii-desktop/builder"
+	"github.com/hawaii-desktop/builder/logging"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Maximum size of a build log in MiB when not configured.
+const defaultMaxLogSize = 16
+
+var (
+	ErrInvalidLog  = errors.New("invalid stored log")
+	ErrInvalidSeek = errors.New("invalid seek on stored log")
+)
+
+// Return the path of a stored log.
+func logPath(id uint64) string {
+	return filepath.Join(Config.Storage.LogsDir, fmt.Sprintf("%d.log.gz", id))
+}
+
+// Return the maximum size of a build log in bytes.
+func maxLogSize() int64 {
+	size := Config.Storage.MaxLogSize
+	if size <= 0 {
+		size = defaultMaxLogSize
+	}
+	return size * 1024 * 1024
+}
+
+// Truncate data bigger than max bytes keeping the beginning
+// and the end, where errors usually are, with a marker in between.
+// Return the data and whether it was truncated.
+func truncateLog(data []byte, max int64) ([]byte, bool) {
+	if int64(len(data)) <= max {
+		return data, false
+	}
+
+	half := max / 2
+	marker := fmt.Sprintf("\n\n[... %d bytes truncated ...]\n\n", int64(len(data))-2*half)
+	result := make([]byte, 0, 2*half+int64(len(marker)))
+	result = append(result, data[:half]...)
+	result = append(result, marker...)
+	result = append(result, data[int64(len(data))-half:]...)
+	return result, true
+}
+
+// Compress and store a log, return a reference to it.
+func (m *Master) storeLog(data []byte) (*builder.StepLog, error) {
+	data, truncated := truncateLog(data, maxLogSize())
+
+	if err := os.MkdirAll(Config.Storage.LogsDir, 0755); err != nil {
+		return nil, err
+	}
+	ref := &builder.StepLog{
+		Id:        m.db.NewLogId(),
+		Size:      int64(len(data)),
+		Truncated: truncated,
+	}
+	file, err := os.Create(logPath(ref.Id))
+	if err != nil {
+		return nil, err
+	}
+	writer := gzip.NewWriter(file)
+	_, err = writer.Write(data)
+	if err == nil {
+		err = writer.Close()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(logPath(ref.Id))
+		return nil, err
+	}
+	return ref, nil
+}
+
+// Store the logs of a build step and return references to them.
+func (m *Master) storeStepLogs(logs map[string][]byte) map[string]*builder.StepLog {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	refs := make(map[string]*builder.StepLog)
+	for name, data := range logs {
+		ref, err := m.storeLog(data)
+		if err != nil {
+			logging.Errorf("Unable to store log \"%s\": %s\n", name, err)
+			continue
+		}
+		refs[name] = ref
+	}
+	return refs
+}
+
+// Remove stored logs.
+func removeStepLogs(refs map[string]*builder.StepLog) {
+	for _, ref := range refs {
+		os.Remove(logPath(ref.Id))
+	}
+}
+
+// Stored log that is decompressed while it's read.
+// Seeking forward skips data and seeking backward starts over,
+// this way big logs are never kept in memory.
+type logReader struct {
+	file   *os.File
+	reader *gzip.Reader
+	size   int64
+	offset int64
+	pos    int64
+}
+
+// Open a stored log.
+func openLog(id uint64) (*logReader, error) {
+	file, err := os.Open(logPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	// The uncompressed size modulo 2^32 is stored at the end
+	// of the gzip stream, logs are never that big
+	var trailer [4]byte
+	stat, err := file.Stat()
+	if err == nil && stat.Size() < int64(len(trailer)) {
+		err = ErrInvalidLog
+	}
+	if err == nil {
+		_, err = file.ReadAt(trailer[:], stat.Size()-int64(len(trailer)))
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r := &logReader{file: file, size: int64(binary.LittleEndian.Uint32(trailer[:]))}
+	if err := r.rewind(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Start decompressing from the beginning.
+func (r *logReader) rewind() error {
+	if _, err := r.file.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+	if r.reader == nil {
+		reader, err := gzip.NewReader(r.file)
+		if err != nil {
+			return err
+		}
+		r.reader = reader
+	} else if err := r.reader.Reset(r.file); err != nil {
+		return err
+	}
+	r.offset = 0
+	return nil
+}
+
+// Size of the uncompressed log.
+func (r *logReader) Size() int64 {
+	return r.size
+}
+
+func (r *logReader) Read(p []byte) (int, error) {
+	if r.pos < r.offset {
+		if err := r.rewind(); err != nil {
+			return 0, err
+		}
+	}
+	if r.pos > r.offset {
+		n, err := io.CopyN(ioutil.Discard, r.reader, r.pos-r.offset)
+		r.offset += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+	r.pos = r.offset
+	return n, err
+}
+
+func (r *logReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case os.SEEK_SET:
+	case os.SEEK_CUR:
+		offset += r.pos
+	case os.SEEK_END:
+		offset += r.size
+	default:
+		return 0, ErrInvalidSeek
+	}
+	if offset < 0 {
+		return 0, ErrInvalidSeek
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *logReader) Close() error {
+	r.reader.Close()
+	return r.file.Close()
+}
+
+// Move logs stored inline with jobs saved before logs were
+// stored separately to their own files.
+func (m *Master) MigrateStepLogs() error {
+	jobs := m.db.FilterJobs(func(job *builder.Job) bool {
+		for _, step := range job.Steps {
+			if len(step.Logs) > 0 {
+				return true
+			}
+		}
+		return false
+	})
+
+	for _, job := range jobs {
+		for _, step := range job.Steps {
+			if len(step.Logs) == 0 {
+				continue
+			}
+			if step.LogRefs == nil {
+				step.LogRefs = make(map[string]*builder.StepLog)
+			}
+			for name, data := range step.Logs {
+				ref, err := m.storeLog(data)
+				if err != nil {
+					return err
+				}
+				step.LogRefs[name] = ref
+			}
+			step.Logs = nil
+		}
+		if err := m.db.SaveJob(job); err != nil {
+			return err
+		}
+		logging.Infof("Moved logs of job #%d to the logs storage\n", job.Id)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package master
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// How many output messages can be queued for a follower
+// before new ones are dropped.
+const outputFollowerQueueSize = 256
+
+// How many lines of output stored so far are sent
+// to clients asking for the output of a job.
+const outputTailLines = 10000
+
+// How many bytes are read at a time from the end of the output.
+const outputReadChunkSize = 64 * 1024
+
+// Return the path of the file with the output of a job.
+func jobOutputPath(id uint64) string {
+	return filepath.Join(Config.Storage.LogsDir, fmt.Sprintf("%d.output", id))
+}
+
+// Return the size of the output of a job stored so far.
+func jobOutputSize(id uint64) (int64, error) {
+	fi, err := os.Stat(jobOutputPath(id))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Read up to max lines of output of a job, ending at offset size.
+// The file is read backwards from size in chunks, so the cost
+// doesn't depend on how much output was stored before.
+func readJobOutput(id uint64, size int64, max int) ([]string, error) {
+	if size <= 0 || max <= 0 {
+		return []string{}, nil
+	}
+
+	file, err := os.Open(jobOutputPath(id))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Chunks are collected from the last one, the last byte is
+	// the newline that terminates the last line
+	var chunks [][]byte
+	start := size
+	found := 0
+	for start > 0 && found <= max {
+		n := int64(outputReadChunkSize)
+		if n > start {
+			n = start
+		}
+		start -= n
+		chunk := make([]byte, n)
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+		found += bytes.Count(chunk, []byte{'\n'})
+	}
+	data := make([]byte, 0, size-start)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		data = append(data, chunks[i]...)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return lines, nil
+}
+
+// Append output received from a slave to the job output, then
+// forward it to followers and Web socket clients looking at the job.
+// A line with the step name is stored every time the step changes.
+func (m *Master) appendJobOutput(output *pb.JobOutput) {
+	m.oMutex.Lock()
+	defer m.oMutex.Unlock()
+
+	// Store
+	err := os.MkdirAll(Config.Storage.LogsDir, 0755)
+	if err == nil {
+		var file *os.File
+		file, err = os.OpenFile(jobOutputPath(output.JobId), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err == nil {
+			if output.Step != m.outputSteps[output.JobId] {
+				fmt.Fprintf(file, "==> %s\n", output.Step)
+				m.outputSteps[output.JobId] = output.Step
+			}
+			for _, line := range output.Lines {
+				fmt.Fprintln(file, line)
+			}
+			file.Close()
+		}
+	}
+	if err != nil {
+		logging.Errorf("Unable to store output of job #%d: %s\n", output.JobId, err)
+	}
+
+	// Forward to followers, slow followers lose messages
+	for _, c := range m.followers[output.JobId] {
+		select {
+		case c <- output:
+		default:
+		}
+	}
+
+	// Update Web socket clients
+	m.sendJobOutput(output)
+}
+
+// Return the last lines of output of a job stored so far along with
+// the last step.  When follow is true also return a channel that
+// receives new output and is closed when the job has finished, the
+// channel must be released with unfollowJobOutput().
+// The lock is held only to register the follower and to record where
+// the stored output ends, the file is read afterwards up to that point
+// so that the slave output is not blocked while reading and lines are
+// neither lost nor duplicated.
+func (m *Master) followJobOutput(id uint64, follow bool) ([]string, string, chan *pb.JobOutput, error) {
+	m.oMutex.Lock()
+	size, err := jobOutputSize(id)
+	if err != nil {
+		m.oMutex.Unlock()
+		return nil, "", nil, err
+	}
+	step := m.outputSteps[id]
+
+	// Jobs are removed from the list before followers are closed,
+	// hence jobs not in the list are not going to produce output
+	if follow {
+		follow = false
+		m.forEachJob(func(job *Job) {
+			if job.Id == id {
+				follow = true
+			}
+		})
+	}
+	var c chan *pb.JobOutput
+	if follow {
+		c = make(chan *pb.JobOutput, outputFollowerQueueSize)
+		m.followers[id] = append(m.followers[id], c)
+	}
+	m.oMutex.Unlock()
+
+	lines, err := readJobOutput(id, size, outputTailLines)
+	if err != nil {
+		if c != nil {
+			m.unfollowJobOutput(id, c)
+		}
+		return nil, "", nil, err
+	}
+	return lines, step, c, nil
+}
+
+// Stop following the output of a job.
+func (m *Master) unfollowJobOutput(id uint64, c chan *pb.JobOutput) {
+	m.oMutex.Lock()
+	defer m.oMutex.Unlock()
+
+	followers := m.followers[id]
+	for i, f := range followers {
+		if f == c {
+			m.followers[id] = append(followers[:i], followers[i+1:]...)
+			close(c)
+			break
+		}
+	}
+	if len(m.followers[id]) == 0 {
+		delete(m.followers, id)
+	}
+}
+
+// Close all the followers of a job, this is called when it has finished.
+func (m *Master) closeJobOutput(id uint64) {
+	m.oMutex.Lock()
+	defer m.oMutex.Unlock()
+
+	for _, c := range m.followers[id] {
+		close(c)
+	}
+	delete(m.followers, id)
+	delete(m.outputSteps, id)
+}
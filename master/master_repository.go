@@ -27,24 +27,109 @@
 package master
 
 import (
+	"compress/gzip"
 	"fmt"
+	"github.com/hawaii-desktop/builder"
 	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
+	"github.com/hawaii-desktop/builder/utils"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 )
 
+// Name of the pacman repository.
+const archRepoName = "hawaii"
+
+var (
+	// Regular expression for RPMs.
+	rpmRegexp = regexp.MustCompile(`^(.+)\.([a-z0-9\-_]+)\.rpm$`)
+
+	// Regular expression for Debian binary and source packages.
+	debRegexp = regexp.MustCompile(`^([a-z0-9][a-z0-9+.\-]+)_[^/]+\.(deb|dsc|changes|diff\.gz|tar\.[a-z0-9]+)$`)
+
+	// Regular expression for Debian binary packages and changes files.
+	debArchRegexp = regexp.MustCompile(`^[^_]+_[^_]+_([a-z0-9\-]+)\.(deb|changes)$`)
+)
+
+// Distributions published as apt repositories.
+var aptDistributions = []string{"debian", "ubuntu"}
+
+// Return whether distro is published as an apt repository.
+func isAptDistribution(distro string) bool {
+	for _, d := range aptDistributions {
+		if d == distro {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the final location of an uploaded file inside the repository
+// rooted at rootrepodir, or an empty string if the file name is not
+// valid for the distribution it was built for.
+func repositoryPath(rootrepodir string, request *pb.UploadRequest) string {
+	distro := request.Distribution
+	if distro == "" {
+		distro = builder.DEFAULT_DISTRIBUTION
+	}
+
+	switch distro {
+	case "fedora":
+		m := rpmRegexp.FindStringSubmatch(request.FileName)
+		if len(m) != 3 {
+			return ""
+		}
+		letter := m[1][:1]
+		return fmt.Sprintf("%s/fedora/releases/%s/%s/os/Packages/%s/%s",
+			rootrepodir, request.ReleaseVer, request.BaseArch,
+			letter, request.FileName)
+	case "archlinux":
+		if !builder.PacmanPackageRegexp.MatchString(request.FileName) {
+			return ""
+		}
+		return fmt.Sprintf("%s/archlinux/%s/%s", rootrepodir,
+			request.BaseArch, request.FileName)
+	case "debian", "ubuntu":
+		m := debRegexp.FindStringSubmatch(request.FileName)
+		if len(m) != 3 || request.ReleaseVer == "" {
+			return ""
+		}
+		// Binary packages are stored with their source package
+		source := m[1]
+		if request.Source != "" {
+			source = request.Source
+		}
+		letter := source[:1]
+		if strings.HasPrefix(source, "lib") && len(source) > 3 {
+			letter = source[:4]
+		}
+		return fmt.Sprintf("%s/%s/pool/%s/main/%s/%s/%s", rootrepodir,
+			distro, request.ReleaseVer, letter, source, request.FileName)
+	}
+
+	return ""
+}
+
 // Update repodata for the main repository every time another
 // goroutine ask to do it. Eventually return when false is queued
 // to the channel.
 func (m *Master) ProcessRepoDataUpdates() {
+	// Signing might have been enabled or disabled since the last run
+	m.updateRepoFiles(Config.Storage.RepositoryDir)
+
 	go func() {
 		for {
 			select {
 			case result := <-m.repoDataQueue:
 				if result {
 					m.updateRepoData(Config.Storage.RepositoryDir)
+					m.updatePacmanRepoData(Config.Storage.RepositoryDir)
+					m.updateAptRepoData(Config.Storage.RepositoryDir)
 				} else {
 					return
 				}
@@ -75,6 +160,10 @@ func (m *Master) updateRepoData(rootrepodir string) {
 						"--cachedir", cachedir, osdir)
 					if output, err := cmd.CombinedOutput(); err != nil {
 						logging.Errorf("Failed to create repodata for %s: %s\n%s", osdir, err, string(output))
+					} else if signingEnabled() {
+						if err := signDetached(filepath.Join(osdir, "repodata", "repomd.xml")); err != nil {
+							logging.Errorln(err)
+						}
 					}
 
 					// Remove old repository view
@@ -89,4 +178,136 @@ func (m *Master) updateRepoData(rootrepodir string) {
 			}
 		}
 	}
+
+	// Repository definitions for users
+	m.updateRepoFiles(rootrepodir)
+}
+
+// Create or update the pacman database for each architecture
+// of the Arch Linux repository inside rootrepodir.
+func (m *Master) updatePacmanRepoData(rootrepodir string) {
+	archrootdir := filepath.Join(rootrepodir, "archlinux")
+	archs, _ := ioutil.ReadDir(archrootdir)
+	for _, arch := range archs {
+		if !arch.IsDir() {
+			continue
+		}
+
+		// Collect packages, skipping detached signatures
+		osdir := filepath.Join(archrootdir, arch.Name())
+		matches, _ := filepath.Glob(filepath.Join(osdir, "*.pkg.tar*"))
+		packages := []string{}
+		for _, match := range matches {
+			if !strings.HasSuffix(match, ".sig") {
+				packages = append(packages, match)
+			}
+		}
+		if len(packages) == 0 {
+			continue
+		}
+
+		// Add new packages to the database and remove old files
+		dbfile := filepath.Join(osdir, archRepoName+".db.tar.gz")
+		args := append([]string{"--new", "--remove", "--prevent-downgrade", dbfile}, packages...)
+		cmd := exec.Command("repo-add", args...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			logging.Errorf("Failed to update pacman database for %s: %s\n%s", osdir, err, string(output))
+		}
+	}
+}
+
+// Generate Packages, Sources and Release indexes for each release
+// of the apt repositories inside rootrepodir.
+func (m *Master) updateAptRepoData(rootrepodir string) {
+	for _, distro := range aptDistributions {
+		distrodir := filepath.Join(rootrepodir, distro)
+		releases, _ := ioutil.ReadDir(filepath.Join(distrodir, "pool"))
+		for _, release := range releases {
+			if release.IsDir() {
+				m.updateAptRelease(distrodir, release.Name())
+			}
+		}
+	}
+}
+
+// Generate the indexes of a single apt repository release, paths
+// inside the indexes are relative to distrodir.
+func (m *Master) updateAptRelease(distrodir, release string) {
+	pooldir := filepath.Join("pool", release)
+	distdir := filepath.Join(distrodir, "dists", release)
+
+	// Find out which architectures we have packages for, the
+	// changes files are named after the architecture of the build
+	// even when it only produced architecture independent packages
+	archs := []string{}
+	filepath.Walk(filepath.Join(distrodir, pooldir), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		match := debArchRegexp.FindStringSubmatch(info.Name())
+		if len(match) == 3 && match[1] != "all" && match[1] != "source" && !utils.StringSliceContains(archs, match[1]) {
+			archs = append(archs, match[1])
+		}
+		return nil
+	})
+	sort.Strings(archs)
+
+	// Binary packages, architecture independent packages are
+	// included by apt-ftparchive for each architecture
+	for _, arch := range archs {
+		cmd := exec.Command("apt-ftparchive", "--arch", arch, "packages", pooldir)
+		cmd.Dir = distrodir
+		m.writeAptIndex(cmd, filepath.Join(distdir, "main", "binary-"+arch, "Packages"))
+	}
+
+	// Source packages
+	cmd := exec.Command("apt-ftparchive", "sources", pooldir)
+	cmd.Dir = distrodir
+	m.writeAptIndex(cmd, filepath.Join(distdir, "main", "source", "Sources"))
+
+	// Release file
+	cmd = exec.Command("apt-ftparchive",
+		"-o", "APT::FTPArchive::Release::Origin=Hawaii",
+		"-o", "APT::FTPArchive::Release::Label=Hawaii",
+		"-o", "APT::FTPArchive::Release::Suite="+release,
+		"-o", "APT::FTPArchive::Release::Codename="+release,
+		"-o", "APT::FTPArchive::Release::Architectures="+strings.Join(archs, " "),
+		"-o", "APT::FTPArchive::Release::Components=main",
+		"release", distdir)
+	output, err := cmd.Output()
+	if err != nil {
+		logging.Errorf("Failed to create Release for %s: %s\n", distdir, err)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(distdir, "Release"), output, 0644); err != nil {
+		logging.Errorf("Failed to write Release for %s: %s\n", distdir, err)
+	}
+}
+
+// Run cmd and save its output to filename and a gzip compressed copy.
+func (m *Master) writeAptIndex(cmd *exec.Cmd, filename string) {
+	output, err := cmd.Output()
+	if err != nil {
+		logging.Errorf("Failed to create %s: %s\n", filename, err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		logging.Errorf("Failed to create %s: %s\n", filepath.Dir(filename), err)
+		return
+	}
+	if err := ioutil.WriteFile(filename, output, 0644); err != nil {
+		logging.Errorf("Failed to write %s: %s\n", filename, err)
+		return
+	}
+
+	file, err := os.Create(filename + ".gz")
+	if err != nil {
+		logging.Errorf("Failed to write %s.gz: %s\n", filename, err)
+		return
+	}
+	defer file.Close()
+	writer := gzip.NewWriter(file)
+	writer.Write(output)
+	writer.Close()
 }
@@ -0,0 +1,181 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package master
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hawaii-desktop/builder/database"
+	"github.com/hawaii-desktop/builder/logging"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Name of the public key published in the repository.
+const signingPublicKeyName = "RPM-GPG-KEY"
+
+// Return whether packages and metadata are signed.
+func signingEnabled() bool {
+	return Config.Signing.KeyId != ""
+}
+
+// Return the gpg arguments used for every command.
+func gpgArgs() []string {
+	args := []string{"--batch", "--yes"}
+	if Config.Signing.GpgHome != "" {
+		args = append(args, "--homedir", Config.Signing.GpgHome)
+	}
+	if Config.Signing.PassphraseFile != "" {
+		args = append(args, "--pinentry-mode", "loopback",
+			"--passphrase-file", Config.Signing.PassphraseFile)
+	}
+	return args
+}
+
+// Return a gpg command.
+func gpgCommand(args ...string) *exec.Cmd {
+	return exec.Command("gpg", append(gpgArgs(), args...)...)
+}
+
+// Import the signing key, if configured, verify it can be used and
+// publish the public key in the repository.
+// Keys are imported into GpgHome so that signing works without
+// a gpg-agent having the key already.
+func (m *Master) PrepareSigning() error {
+	if !signingEnabled() {
+		return nil
+	}
+
+	if Config.Signing.GpgHome != "" {
+		if err := os.MkdirAll(Config.Signing.GpgHome, 0700); err != nil {
+			return err
+		}
+	}
+
+	if Config.Signing.KeyFile != "" {
+		cmd := gpgCommand("--import", Config.Signing.KeyFile)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("unable to import signing key: %s\n%s", err, string(output))
+		}
+	}
+
+	cmd := gpgCommand("--list-secret-keys", Config.Signing.KeyId)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("signing key \"%s\" not found: %s\n%s", Config.Signing.KeyId, err, string(output))
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd = gpgCommand("--armor", "--export", Config.Signing.KeyId)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to export public key: %s\n%s", err, stderr.String())
+	}
+	keyfile := filepath.Join(Config.Storage.RepositoryDir, signingPublicKeyName)
+	if err := ioutil.WriteFile(keyfile, stdout.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	logging.Infof("Signing with key \"%s\"\n", Config.Signing.KeyId)
+	return nil
+}
+
+// Sign a RPM package in place.
+func signRpm(filename string) error {
+	args := []string{"--addsign", "--define", "_gpg_name " + Config.Signing.KeyId}
+	if Config.Signing.GpgHome != "" {
+		args = append(args, "--define", "_gpg_path "+Config.Signing.GpgHome)
+	}
+	if Config.Signing.PassphraseFile != "" {
+		args = append(args, "--define", "_gpg_sign_cmd_extra_args --batch --pinentry-mode loopback --passphrase-file "+
+			Config.Signing.PassphraseFile)
+	}
+	args = append(args, filename)
+
+	cmd := exec.Command("rpmsign", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to sign \"%s\": %s\n%s", filepath.Base(filename), err, string(output))
+	}
+	return nil
+}
+
+// Create an armored detached signature of filename, named
+// after it with the .asc extension.
+func signDetached(filename string) error {
+	cmd := gpgCommand("--local-user", Config.Signing.KeyId, "--armor",
+		"--detach-sign", "--output", filename+".asc", filename)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to sign \"%s\": %s\n%s", filepath.Base(filename), err, string(output))
+	}
+	return nil
+}
+
+// Return the contents of the .repo file of a project.
+func (m *Master) projectRepoFile(prj *database.Project) string {
+	name := prj.Description
+	if name == "" {
+		name = prj.Name
+	}
+
+	baseurl := m.repoBaseUrl + "/packages/fedora/releases/$releasever/$basearch/os/"
+	contents := fmt.Sprintf("[%s]\nname=%s\nbaseurl=%s\nenabled=1\n", prj.Name, name, baseurl)
+	if signingEnabled() {
+		contents += fmt.Sprintf("gpgcheck=1\nrepo_gpgcheck=1\ngpgkey=%s/packages/%s\n",
+			m.repoBaseUrl, signingPublicKeyName)
+	} else {
+		contents += "gpgcheck=0\n"
+	}
+	return contents
+}
+
+// Write a .repo file for each project inside the Fedora repository
+// of rootrepodir and remove those of projects that no longer exist.
+func (m *Master) updateRepoFiles(rootrepodir string) {
+	repodir := filepath.Join(rootrepodir, "fedora")
+	if err := os.MkdirAll(repodir, 0755); err != nil {
+		logging.Errorf("Failed to create \"%s\": %s\n", repodir, err)
+		return
+	}
+
+	names := make(map[string]bool)
+	m.db.ForEachProject(func(prj *database.Project) {
+		names[prj.Name+".repo"] = true
+		filename := filepath.Join(repodir, prj.Name+".repo")
+		if err := ioutil.WriteFile(filename, []byte(m.projectRepoFile(prj)), 0644); err != nil {
+			logging.Errorf("Failed to write \"%s\": %s\n", filename, err)
+		}
+	})
+
+	matches, _ := filepath.Glob(filepath.Join(repodir, "*.repo"))
+	for _, match := range matches {
+		if !names[filepath.Base(match)] {
+			os.Remove(match)
+		}
+	}
+}
@@ -28,11 +28,70 @@ package master
 
 import (
 	"github.com/hawaii-desktop/builder"
+	"github.com/hawaii-desktop/builder/logging"
 	pb "github.com/hawaii-desktop/builder/protocol"
+	"time"
 )
 
-// dispatchSlave starts job dispatching to slave.
-func (m *Master) dispatchSlave(slave *Slave, channel chan<- *pb.JobRequest) {
+// Slave information to show on the Web user interface.
+type slaveInfo struct {
+	Id             uint64   `json:"id"`
+	Name           string   `json:"name"`
+	Types          []string `json:"types"`
+	Architectures  []string `json:"architectures"`
+	Subscribed     bool     `json:"subscribed"`
+	Ready          bool     `json:"ready"`
+	NotReadyReason string   `json:"reason"`
+	Draining       bool     `json:"draining"`
+	ShuttingDown   bool     `json:"shutting_down"`
+	Jobs           int      `json:"jobs"`
+}
+
+// Return the slaves along with the number of jobs they are processing.
+func (m *Master) listSlaves() []*slaveInfo {
+	var list []*slaveInfo
+	if m.service == nil {
+		return list
+	}
+
+	// Count jobs being processed by each slave, they are all
+	// in the list of pending jobs
+	jobs := make(map[string]int)
+	m.forEachJob(func(job *Job) {
+		if job.Status == builder.JOB_STATUS_PROCESSING {
+			jobs[job.Slave]++
+		}
+	})
+
+	m.service.sMutex.Lock()
+	defer m.service.sMutex.Unlock()
+	for _, s := range m.service.Slaves {
+		if s == nil {
+			continue
+		}
+		s.rMutex.Lock()
+		list = append(list, &slaveInfo{
+			Id:             s.Id,
+			Name:           s.Name,
+			Types:          s.Types,
+			Architectures:  s.Architectures,
+			Subscribed:     s.Subscribed,
+			Ready:          s.Ready,
+			NotReadyReason: s.NotReadyReason,
+			Draining:       s.Draining,
+			ShuttingDown:   s.ShuttingDown,
+			Jobs:           jobs[s.Name],
+		})
+		s.rMutex.Unlock()
+	}
+	return list
+}
+
+// dispatchSlave starts job dispatching to slave, job requests
+// are streamed with send.
+// Jobs picked up from the queue go back to it whenever they cannot
+// be sent, for example because the connection was lost.
+func (m *Master) dispatchSlave(slave *Slave, send func(*pb.JobRequest) error) {
 	// Start dispatching to this slave
 	for _, topic := range slave.Topics() {
 		go func(topic string) {
@@ -42,21 +101,54 @@ func (m *Master) dispatchSlave(slave *Slave, channel chan<- *pb.JobRequest) {
 					return
 				}
 
+				// Do not queue a slave that cannot build now
+				if !slave.waitReady(topic) {
+					return
+				}
+
 				// Add to the queue
-				m.slaveQueues[topic] <- slave.jobChannels[topic]
+				m.slaveQueue(topic) <- slave.jobChannels[topic]
 
 				select {
 				case job := <-slave.jobChannels[topic]:
+					// Slave was drained or became not ready while
+					// waiting in the queue: the job goes back to the
+					// queue and we wait until it's ready again
+					if !slave.canAccept() {
+						go m.queueJob(job)
+						continue
+					}
+
+					// Remember who is building it
+					job.Slave = slave.Name
+
 					// Send the job to the slave
 					r := m.sendJobToSlave(slave, job)
 					if r != nil {
-						channel <- r
+						// The job goes back to the queue if the slave
+						// stopped accepting jobs in the meantime or the
+						// connection was lost
+						if err := send(r); err != nil {
+							job.Slave = ""
+							go m.queueJob(job)
+							if err == ErrSlaveNotAccepting {
+								continue
+							}
+							logging.Errorf("Unable to send job #%d to \"%s\": %s\n", job.Id, slave.Name, err)
+							return
+						}
+						logging.Infof("Job #%d scheduled on \"%s\"\n", job.Id, slave.Name)
 					}
 
 					// Wait for processing on the other side
 					<-job.Channel
 				case <-slave.quitChannels[topic]:
-					// Slave has been asked to stop
+					// Slave has been asked to stop, but it's still
+					// in the queue: the job it would get goes back
+					// to the queue
+					go func(jobs chan *Job) {
+						m.queueJob(<-jobs)
+					}(slave.jobChannels[topic])
 					return
 				}
 			}
@@ -78,19 +170,38 @@ func (m *Master) sendJobToSlave(slave *Slave, job *Job) *pb.JobRequest {
 			Architectures: []string{job.Architecture},
 			Ci:            pkg.Ci,
 			Vcs: &pb.VcsInfo{
-				Url:    pkg.Vcs.Url,
-				Branch: pkg.Vcs.Branch,
+				Url:      pkg.Vcs.Url,
+				Branch:   pkg.Vcs.Branch,
+				Revision: job.PackagingRevision,
 			},
 			UpstreamVcs: &pb.VcsInfo{
-				Url:    pkg.UpstreamVcs.Url,
-				Branch: pkg.UpstreamVcs.Branch,
+				Url:      pkg.UpstreamVcs.Url,
+				Branch:   pkg.UpstreamVcs.Branch,
+				Revision: job.UpstreamRevision,
 			},
+			Distribution: pkg.Distribution,
+			Timeout:      uint32(pkg.Timeout / time.Second),
+			MemoryLimit:  pkg.MemoryLimit,
+			CpuLimit:     pkg.CpuLimit,
+			PidsLimit:    pkg.PidsLimit,
+		}
+		if pkg.Ci {
+			if last := m.lastBuild(job); last != nil {
+				pkgmsg.PreviousUpstreamCommit = last.UpstreamCommit
+			}
+		}
+		if isAptDistribution(job.DistributionName()) {
+			chroot, _ := m.db.FindActiveChroot(job.DistributionName(), pkg.ReleaseVer, job.Architecture)
+			if chroot != nil {
+				pkgmsg.ReleaseVer = chroot.OsVersion
+			}
 		}
 		return &pb.JobRequest{
 			Id: job.Id,
 			Payload: &pb.JobRequest_Package{
 				Package: pkgmsg,
 			},
+			Distribution: job.DistributionName(),
 		}
 	case builder.JOB_TARGET_TYPE_IMAGE:
 		img := m.db.GetImage(job.Target)
@@ -102,15 +213,23 @@ func (m *Master) sendJobToSlave(slave *Slave, job *Job) *pb.JobRequest {
 			Description:   img.Description,
 			Architectures: img.Architectures,
 			Vcs: &pb.VcsInfo{
-				Url:    img.Vcs.Url,
-				Branch: img.Vcs.Branch,
+				Url:      img.Vcs.Url,
+				Branch:   img.Vcs.Branch,
+				Revision: job.PackagingRevision,
 			},
+			Kickstart:  img.Kickstart,
+			Format:     img.Format,
+			Product:    img.Product,
+			ReleaseVer: img.ReleaseVer,
+			Variables:  img.Variables,
+			Timeout:    uint32(img.Timeout / time.Second),
 		}
 		return &pb.JobRequest{
 			Id: job.Id,
 			Payload: &pb.JobRequest_Image{
 				Image: imgmsg,
 			},
+			Distribution: job.DistributionName(),
 		}
 	}
 
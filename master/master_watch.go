@@ -0,0 +1,94 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package master
+
+// Return a channel that receives a value every time the job changes
+// and is closed when the job has finished, or nil if the job is not
+// running anymore.  The channel must be released with unwatchJob().
+func (m *Master) watchJob(id uint64) chan bool {
+	m.wMutex.Lock()
+	defer m.wMutex.Unlock()
+
+	// Jobs are saved and removed from the list before watchers
+	// are closed, hence jobs not in the list have finished
+	running := false
+	m.forEachJob(func(job *Job) {
+		if job.Id == id {
+			running = true
+		}
+	})
+	if !running {
+		return nil
+	}
+
+	c := make(chan bool, 1)
+	m.watchers[id] = append(m.watchers[id], c)
+	return c
+}
+
+// Stop watching a job.
+func (m *Master) unwatchJob(id uint64, c chan bool) {
+	m.wMutex.Lock()
+	defer m.wMutex.Unlock()
+
+	watchers := m.watchers[id]
+	for i, w := range watchers {
+		if w == c {
+			m.watchers[id] = append(watchers[:i], watchers[i+1:]...)
+			close(c)
+			break
+		}
+	}
+	if len(m.watchers[id]) == 0 {
+		delete(m.watchers, id)
+	}
+}
+
+// Notify watchers that a job has changed, notifications are
+// coalesced when watchers are slow.
+func (m *Master) notifyJobWatchers(id uint64) {
+	m.wMutex.Lock()
+	defer m.wMutex.Unlock()
+
+	for _, c := range m.watchers[id] {
+		select {
+		case c <- true:
+		default:
+		}
+	}
+}
+
+// Close all the watchers of a job, this is called when it has finished.
+func (m *Master) closeJobWatchers(id uint64) {
+	m.wMutex.Lock()
+	defer m.wMutex.Unlock()
+
+	for _, c := range m.watchers[id] {
+		close(c)
+	}
+	delete(m.watchers, id)
+}
@@ -29,14 +29,19 @@ package master
 import (
 	"encoding/json"
 	"github.com/hawaii-desktop/builder"
+	"github.com/hawaii-desktop/builder/database"
 	"github.com/hawaii-desktop/builder/logging"
+	pb "github.com/hawaii-desktop/builder/protocol"
 	"github.com/hawaii-desktop/builder/webserver"
+	"sort"
 	"time"
 )
 
 // Web socket subscription for certain events.
 type wsSubscription struct {
 	Type int
+	Id   uint64
+	Name string
 	C    chan bool
 }
 
@@ -44,6 +49,7 @@ type wsSubscription struct {
 type wsRequest struct {
 	Type int    `json:"type"`
 	Id   uint64 `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
 }
 
 // Generic message sent to the Web user interface.
@@ -60,12 +66,20 @@ const (
 	WEB_SOCKET_COMPLETED_JOBS
 	WEB_SOCKET_FAILED_JOBS
 	WEB_SOCKET_JOB
+	WEB_SOCKET_JOB_OUTPUT
+	WEB_SOCKET_PACKAGE_BUILDS
+	WEB_SOCKET_IMAGES
+	WEB_SOCKET_SLAVES
 )
 
+// How many lines of output are sent to clients that start
+// looking at a job.
+const webSocketOutputTailLines = 500
+
 // Handle Web socket connection registration.
 func (m *Master) WebSocketConnectionRegistration(c *webserver.WebSocketConnection) {
 	// Add the subscription
-	m.subscriptions[c] = &wsSubscription{-1, make(chan bool)}
+	m.subscriptions[c] = &wsSubscription{-1, 0, "", make(chan bool)}
 
 	// Receive messages from the Web UI and quit the goroutine when
 	// the client has unregistered
@@ -81,6 +95,8 @@ func (m *Master) WebSocketConnectionRegistration(c *webserver.WebSocketConnectio
 				}
 
 				m.subscriptions[c].Type = r.Type
+				m.subscriptions[c].Id = r.Id
+				m.subscriptions[c].Name = r.Name
 				switch {
 				case r.Type == WEB_SOCKET_STATISTICS:
 					m.calculateStatistics()
@@ -89,6 +105,13 @@ func (m *Master) WebSocketConnectionRegistration(c *webserver.WebSocketConnectio
 					m.updateJobsForConnection(r.Type, c)
 				case r.Type == WEB_SOCKET_JOB:
 					m.updateJobForConnection(r.Id, c)
+					m.sendJobOutputTail(r.Id, c)
+				case r.Type == WEB_SOCKET_PACKAGE_BUILDS:
+					m.updatePackageBuildsForConnection(r.Name, c)
+				case r.Type == WEB_SOCKET_IMAGES:
+					m.updateImagesForConnection(c)
+				case r.Type == WEB_SOCKET_SLAVES:
+					m.updateSlavesForConnection(c)
 				}
 			case <-m.subscriptions[c].C:
 				return
@@ -135,6 +158,10 @@ func (m *Master) calculateStatistics() {
 			if job.Finished.After(time.Now().Add(-48 * time.Hour)) {
 				m.stats.Failed++
 			}
+		case builder.JOB_STATUS_TIMED_OUT:
+			if job.Finished.After(time.Now().Add(-48 * time.Hour)) {
+				m.stats.Failed++
+			}
 		}
 	})
 }
@@ -249,3 +276,93 @@ func (m *Master) updateAllJobs() {
 	m.updateJobs(WEB_SOCKET_COMPLETED_JOBS)
 	m.updateJobs(WEB_SOCKET_FAILED_JOBS)
 }
+
+// Send new output to all Web socket connections looking at the job.
+func (m *Master) sendJobOutput(output *pb.JobOutput) {
+	for c, v := range m.subscriptions {
+		if v.Type == WEB_SOCKET_JOB && v.Id == output.JobId {
+			err := c.Write(&wsResponse{Type: WEB_SOCKET_JOB_OUTPUT, Data: output})
+			if err != nil {
+				logging.Errorf("Unable to send job output to the Web socket: %s\n", err)
+			}
+		}
+	}
+}
+
+// Send the last lines of output of a job to the Web socket connection.
+func (m *Master) sendJobOutputTail(id uint64, c *webserver.WebSocketConnection) {
+	m.oMutex.Lock()
+	size, err := jobOutputSize(id)
+	step := m.outputSteps[id]
+	m.oMutex.Unlock()
+	if err != nil {
+		logging.Errorf("Unable to read output of job #%d: %s\n", id, err)
+		return
+	}
+	lines, err := readJobOutput(id, size, webSocketOutputTailLines)
+	if err != nil {
+		logging.Errorf("Unable to read output of job #%d: %s\n", id, err)
+		return
+	}
+
+	output := &pb.JobOutput{JobId: id, Step: step, Lines: lines}
+	err = c.Write(&wsResponse{Type: WEB_SOCKET_JOB_OUTPUT, Data: output})
+	if err != nil {
+		logging.Errorf("Unable to send job output to the Web socket: %s\n", err)
+	}
+}
+
+// Send builds of a package to all Web socket connections looking at it.
+func (m *Master) updatePackageBuilds(name string) {
+	for c, v := range m.subscriptions {
+		if v.Type == WEB_SOCKET_PACKAGE_BUILDS && v.Name == name {
+			m.updatePackageBuildsForConnection(name, c)
+		}
+	}
+}
+
+// Send builds of a package to the Web socket connection.
+func (m *Master) updatePackageBuildsForConnection(name string, c *webserver.WebSocketConnection) {
+	builds := m.db.FilterBuilds(name, "", func(build *database.Build) bool {
+		return true
+	})
+	sort.Sort(sort.Reverse(buildsByFinished(builds)))
+	err := c.Write(&wsResponse{Type: WEB_SOCKET_PACKAGE_BUILDS, Data: builds})
+	if err != nil {
+		logging.Errorf("Unable to send package builds to the Web socket: %s\n", err)
+	}
+}
+
+// Send published images to all Web socket connections looking at them.
+func (m *Master) updateImages() {
+	for c, v := range m.subscriptions {
+		if v.Type == WEB_SOCKET_IMAGES {
+			m.updateImagesForConnection(c)
+		}
+	}
+}
+
+// Send published images to the Web socket connection.
+func (m *Master) updateImagesForConnection(c *webserver.WebSocketConnection) {
+	err := c.Write(&wsResponse{Type: WEB_SOCKET_IMAGES, Data: m.listImages()})
+	if err != nil {
+		logging.Errorf("Unable to send images to the Web socket: %s\n", err)
+	}
+}
+
+// Send slaves to all Web socket connections looking at them.
+func (m *Master) updateSlaves() {
+	for c, v := range m.subscriptions {
+		if v.Type == WEB_SOCKET_SLAVES {
+			m.updateSlavesForConnection(c)
+		}
+	}
+}
+
+// Send slaves to the Web socket connection.
+func (m *Master) updateSlavesForConnection(c *webserver.WebSocketConnection) {
+	err := c.Write(&wsResponse{Type: WEB_SOCKET_SLAVES, Data: m.listSlaves()})
+	if err != nil {
+		logging.Errorf("Unable to send slaves to the Web socket: %s\n", err)
+	}
+}
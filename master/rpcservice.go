@@ -38,10 +38,14 @@ import (
 	pb "github.com/hawaii-desktop/builder/protocol"
 	"github.com/hawaii-desktop/builder/utils"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -60,18 +64,34 @@ type RpcService struct {
 var (
 	ErrAlreadySubscribed  = errors.New("slave has already subscribed")
 	ErrSlaveNotFound      = errors.New("slave not found")
+	ErrSlaveDisconnected  = errors.New("slave is not connected")
+	ErrSlaveNotAccepting  = errors.New("slave is not accepting jobs")
 	ErrInvalidSlave       = errors.New("slave is not valid")
 	ErrJobNotFound        = errors.New("job not found with that id")
-	ErrNoMatchingPackages = errors.New("no matching packages")
-	ErrNoMatchingImages   = errors.New("no matching images")
+	ErrNoMatchingPackages = grpc.Errorf(codes.NotFound, "no matching packages")
+	ErrNoMatchingImages   = grpc.Errorf(codes.NotFound, "no matching images")
+	ErrNoMatchingProjects = grpc.Errorf(codes.NotFound, "no matching projects")
+	ErrInvalidImageFormat = errors.New("invalid image format")
+	ErrLogNotFound        = errors.New("log not found")
+	ErrInvalidRevision    = errors.New("invalid revision")
+	ErrUpstreamRevision   = errors.New("upstream revision is only valid for CI packages")
 )
 
+// Commit hashes and tags that can be checked out.
+var revisionRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/+-]*$`)
+
 // Map to decode job type.
 var jobTargetMap = map[pb.EnumTargetType]builder.JobTargetType{
 	pb.EnumTargetType_PACKAGE: builder.JOB_TARGET_TYPE_PACKAGE,
 	pb.EnumTargetType_IMAGE:   builder.JOB_TARGET_TYPE_IMAGE,
 }
 
+// Map to encode job type.
+var pbJobTargetMap = map[builder.JobTargetType]pb.EnumTargetType{
+	builder.JOB_TARGET_TYPE_PACKAGE: pb.EnumTargetType_PACKAGE,
+	builder.JOB_TARGET_TYPE_IMAGE:   pb.EnumTargetType_IMAGE,
+}
+
 // Map to decode job status.
 var jobStatusMap = map[pb.EnumJobStatus]builder.JobStatus{
 	pb.EnumJobStatus_JOB_STATUS_JUST_CREATED: builder.JOB_STATUS_JUST_CREATED,
@@ -80,6 +100,18 @@ var jobStatusMap = map[pb.EnumJobStatus]builder.JobStatus{
 	pb.EnumJobStatus_JOB_STATUS_SUCCESSFUL:   builder.JOB_STATUS_SUCCESSFUL,
 	pb.EnumJobStatus_JOB_STATUS_FAILED:       builder.JOB_STATUS_FAILED,
 	pb.EnumJobStatus_JOB_STATUS_CRASHED:      builder.JOB_STATUS_CRASHED,
+	pb.EnumJobStatus_JOB_STATUS_TIMED_OUT:    builder.JOB_STATUS_TIMED_OUT,
+}
+
+// Map to encode job status.
+var pbJobStatusMap = map[builder.JobStatus]pb.EnumJobStatus{
+	builder.JOB_STATUS_JUST_CREATED: pb.EnumJobStatus_JOB_STATUS_JUST_CREATED,
+	builder.JOB_STATUS_WAITING:      pb.EnumJobStatus_JOB_STATUS_WAITING,
+	builder.JOB_STATUS_PROCESSING:   pb.EnumJobStatus_JOB_STATUS_PROCESSING,
+	builder.JOB_STATUS_SUCCESSFUL:   pb.EnumJobStatus_JOB_STATUS_SUCCESSFUL,
+	builder.JOB_STATUS_FAILED:       pb.EnumJobStatus_JOB_STATUS_FAILED,
+	builder.JOB_STATUS_CRASHED:      pb.EnumJobStatus_JOB_STATUS_CRASHED,
+	builder.JOB_STATUS_TIMED_OUT:    pb.EnumJobStatus_JOB_STATUS_TIMED_OUT,
 }
 
 // Allocate a new RpcService with an empty list of slaves.
@@ -88,31 +120,61 @@ var jobStatusMap = map[pb.EnumJobStatus]builder.JobStatus{
 // The jobs list is initially empty and has a capacity as big as
 // the maximum number of jobs from the configuration.
 func NewRpcService(master *Master) *RpcService {
-	return &RpcService{
+	service := &RpcService{
 		Slaves: make([]*Slave, 0, Config.Build.MaxSlaves),
 		master: master,
 	}
+	master.service = service
+	return service
 }
 
 // Subscribe to the master.
 func (m *RpcService) Subscribe(ctx context.Context, args *pb.SubscribeRequest) (*pb.SubscribeResponse, error) {
-	// The same slave cannot subscribe twice
+	// The same slave cannot subscribe twice, unless it's reconnecting
+	// with the identifier it was given before and the master did
+	// not notice that the connection was lost
 	m.sMutex.Lock()
 	defer m.sMutex.Unlock()
-	for _, slave := range m.Slaves {
-		if slave == nil {
+	index := -1
+	for i, slave := range m.Slaves {
+		if slave == nil || slave.Name != args.Name {
 			continue
 		}
-		if slave.Name == args.Name && slave.Subscribed {
+		if slave.Subscribed && slave.Id != args.Id {
 			return nil, ErrAlreadySubscribed
 		}
+		index = i
+	}
+
+	// Keep the identifier across reconnections and master restarts
+	id := args.Id
+	if id == 0 || m.slaveIdTaken(id, args.Name) {
+		id = m.master.db.NewSlaveId()
+		for m.slaveIdTaken(id, args.Name) {
+			id = m.master.db.NewSlaveId()
+		}
 	}
 
-	// Create and append slave
-	slave := NewSlave(m.master.db.NewSlaveId(), args.Name, args.Types, args.Architectures)
-	m.Slaves = append(m.Slaves, slave)
+	// Create and append slave, replacing the previous subscription
+	// and keeping it drained if it was
+	slave := NewSlave(id, args.Name, args.Types, args.Architectures, args.Factories)
+	if index >= 0 {
+		old := m.Slaves[index]
+		if old.Subscribed {
+			logging.Infof("Slave \"%s\" reconnected, dropping the previous connection\n", old.Name)
+			old.Subscribed = false
+			old.Stop()
+		}
+		slave.SetDraining(old.Draining)
+		m.Slaves[index] = slave
+	} else {
+		m.Slaves = append(m.Slaves, slave)
+	}
 	logging.Infof("Subscribed slave \"%s\" with id %d\n", slave.Name, slave.Id)
 
+	// Update Web socket clients
+	go m.master.updateSlaves()
+
 	// Reply
 	response := &pb.SubscribeResponse{
 		Id:        slave.Id,
@@ -126,11 +188,43 @@ func (m *RpcService) Subscribe(ctx context.Context, args *pb.SubscribeRequest) (
 func (m *RpcService) PickJob(stream pb.Builder_PickJobServer) error {
 	var slave *Slave = nil
 
-	// Messages that will be streamed to the slave are sent here
-	outChannel := make(chan *pb.JobRequest)
+	// Job requests are streamed by the dispatch goroutines, one at
+	// a time and only while the connection is up: done is closed
+	// when it's lost so that they put back the jobs they hold.
+	// Jobs are sent only if the slave can accept them, which is
+	// checked with the mutex held so that no job follows the
+	// acknowledgement of a draining status
+	var sendMutex sync.Mutex
+	done := make(chan struct{})
+	send := func(r *pb.JobRequest) error {
+		sendMutex.Lock()
+		defer sendMutex.Unlock()
+		select {
+		case <-done:
+			return ErrSlaveDisconnected
+		default:
+		}
+		if !r.DrainingAck && !slave.canAccept() {
+			return ErrSlaveNotAccepting
+		}
+		return stream.Send(r)
+	}
 	defer func() {
-		// Quit the goroutines that are picking up from this channel
-		outChannel <- nil
+		// Stop dispatching to a slave that lost the connection,
+		// it will subscribe again
+		m.sMutex.Lock()
+		if slave != nil && slave.Subscribed {
+			logging.Warningf("Lost connection with slave \"%s\"\n", slave.Name)
+			slave.Subscribed = false
+			slave.Stop()
+			go m.master.updateSlaves()
+		}
+		m.sMutex.Unlock()
+
+		// Jobs can no longer be sent to this connection
+		sendMutex.Lock()
+		close(done)
+		sendMutex.Unlock()
 	}()
 
 	for {
@@ -146,41 +240,51 @@ func (m *RpcService) PickJob(stream pb.Builder_PickJobServer) error {
 		// Slave start
 		slaveStart := in.GetSlaveStart()
 		if slaveStart != nil {
-			// Find the slave
-			m.sMutex.Lock()
-			for _, s := range m.Slaves {
-				if s.Id == slaveStart.Id {
-					slave = s
-					break
-				}
+			// Jobs are already dispatched to the slave that
+			// started on this stream
+			if slave != nil {
+				return ErrAlreadySubscribed
 			}
-			m.sMutex.Unlock()
+
+			// Find the slave
+			slave = m.findSlave(slaveStart.Id)
 
 			// Can't continue if the slave was not found
 			if slave == nil {
 				return ErrSlaveNotFound
 			}
 
-			// Stream job requests to the slave when the dispatch
-			// function send them to the channel we created above
-			go func() {
-				for {
-					select {
-					case r := <-outChannel:
-						// Quit this go routine when a nil request has been received
-						if r == nil {
-							return
-						}
-
-						// Otherwise stream the request to the slave
-						stream.Send(r)
-						logging.Infof("Job #%d scheduled on \"%s\"\n", r.Id, slave.Name)
-					}
+			// Dispatch jobs to this slave
+			m.master.dispatchSlave(slave, send)
+		}
+
+		// Slave status
+		slaveStatus := in.GetSlaveStatus()
+		if slaveStatus != nil {
+			s := m.findSlave(slaveStatus.Id)
+			if s == nil {
+				return ErrSlaveNotFound
+			}
+			if slaveStatus.Draining {
+				logging.Infof("Slave \"%s\" is shutting down\n", s.Name)
+			} else if slaveStatus.Ready {
+				logging.Infof("Slave \"%s\" is ready\n", s.Name)
+			} else {
+				logging.Warningf("Slave \"%s\" is not ready: %s\n", s.Name, slaveStatus.Reason)
+			}
+			s.SetReady(slaveStatus.Ready, slaveStatus.Reason)
+			s.SetShuttingDown(slaveStatus.Draining)
+
+			// Jobs sent from now on would arrive after the slave
+			// quit, let it know that none will follow
+			if slaveStatus.Draining {
+				if err := send(&pb.JobRequest{DrainingAck: true}); err != nil {
+					return err
 				}
-			}()
+			}
 
-			// Dispatch jobs to this slave
-			m.master.dispatchSlave(slave, outChannel)
+			// Update Web socket clients
+			m.master.updateSlaves()
 		}
 
 		// Job update
@@ -191,33 +295,46 @@ func (m *RpcService) PickJob(stream pb.Builder_PickJobServer) error {
 				return ErrSlaveNotFound
 			}
 
-			var job *Job = nil
-			m.master.forEachJob(func(curJob *Job) {
-				// Skip other jobs
-				if curJob.Id == jobUpdate.Id {
-					job = curJob
-				}
-			})
+			// Updates of unknown jobs are ignored, the slave
+			// might have been building them before a restart
+			job := m.master.findJob(jobUpdate.Id)
 			if job == nil {
 				logging.Errorf("Cannot find job #%d\n", jobUpdate.Id)
-				return ErrJobNotFound
+				continue
 			}
 
-			// Update the status
+			// Update the status and finished time
 			job.Status = jobStatusMap[jobUpdate.Status]
-
-			// Handle status change
-			if job.Status >= builder.JOB_STATUS_SUCCESSFUL && job.Status <= builder.JOB_STATUS_CRASHED {
-				// Update finished time and notify
+			if jobUpdate.PackagingCommit != "" {
+				job.PackagingCommit = jobUpdate.PackagingCommit
+			}
+			if jobUpdate.UpstreamCommit != "" {
+				job.UpstreamCommit = jobUpdate.UpstreamCommit
+			}
+			finished := job.Status >= builder.JOB_STATUS_SUCCESSFUL && job.Status <= builder.JOB_STATUS_TIMED_OUT
+			if finished {
 				job.Finished = time.Now()
+			}
+
+			// Save on the database before the job is removed
+			// from the list, so that watchers see the final status
+			m.master.saveDatabaseJob(job)
 
+			// Handle status change
+			if finished {
 				// Log the status
 				if job.Status == builder.JOB_STATUS_SUCCESSFUL {
 					logging.Infof("Job #%d completed successfully on \"%s\"\n",
 						job.Id, slave.Name)
 
+					// Record the build
+					m.master.saveBuild(job, jobUpdate)
+
 					// Update repodata and repoview
 					m.master.repoDataQueue <- true
+				} else if job.Status == builder.JOB_STATUS_TIMED_OUT {
+					logging.Errorf("Job #%d timed out on \"%s\"\n",
+						job.Id, slave.Name)
 				} else {
 					logging.Errorf("Job #%d failed on \"%s\"\n",
 						job.Id, slave.Name)
@@ -226,22 +343,35 @@ func (m *RpcService) PickJob(stream pb.Builder_PickJobServer) error {
 				// Send status notification(s)
 				m.master.sendStatusNotifications(job)
 
-				// Remove from the list
+				// Remove from the list, stop following the output
+				// and watching the job
 				m.master.removeJob(job)
+				m.master.closeJobOutput(job.Id)
+				m.master.closeJobWatchers(job.Id)
 
 				// Proceed to the next job
 				job.Channel <- true
 			} else {
 				logging.Tracef("Change job #%d status to \"%s\"\n",
 					jobUpdate.Id, builder.JobStatusDescriptionMap[job.Status])
+				m.master.notifyJobWatchers(job.Id)
 			}
 
-			// Save on the database
-			m.master.saveDatabaseJob(job)
-
 			// Update Web socket clients
 			m.master.updateStatistics()
 			m.master.updateAllJobs()
+			m.master.updateSlaves()
+		}
+
+		// Job output
+		jobOutput := in.GetJobOutput()
+		if jobOutput != nil {
+			// We need the slave
+			if slave == nil {
+				return ErrSlaveNotFound
+			}
+
+			m.master.appendJobOutput(jobOutput)
 		}
 
 		// Build step update
@@ -253,18 +383,15 @@ func (m *RpcService) PickJob(stream pb.Builder_PickJobServer) error {
 			}
 
 			// Do we have a valid job here?
-			var job *Job = nil
-			m.master.forEachJob(func(curJob *Job) {
-				// Skip other jobs
-				if curJob.Id == stepUpdate.JobId {
-					job = curJob
-				}
-			})
+			job := m.master.findJob(stepUpdate.JobId)
 			if job == nil {
 				logging.Errorf("Cannot find job #%d\n", stepUpdate.JobId)
-				return ErrJobNotFound
+				continue
 			}
 
+			// Store logs outside the job
+			refs := m.master.storeStepLogs(stepUpdate.Logs)
+
 			// Append or replace the build step
 			job.Mutex.Lock()
 			found := false
@@ -273,7 +400,12 @@ func (m *RpcService) PickJob(stream pb.Builder_PickJobServer) error {
 					step.Started = time.Unix(0, stepUpdate.Started)
 					step.Finished = time.Unix(0, stepUpdate.Finished)
 					step.Summary = utils.MapSliceString(stepUpdate.Summary)
-					step.Logs = stepUpdate.Logs
+					step.PeakMemory = stepUpdate.PeakMemory
+					step.CpuTime = time.Duration(stepUpdate.CpuTime)
+					if len(refs) > 0 {
+						removeStepLogs(step.LogRefs)
+						step.LogRefs = refs
+					}
 					found = true
 					break
 				}
@@ -284,7 +416,10 @@ func (m *RpcService) PickJob(stream pb.Builder_PickJobServer) error {
 					Started:  time.Unix(0, stepUpdate.Started),
 					Finished: time.Unix(0, stepUpdate.Finished),
 					Summary:  utils.MapSliceString(stepUpdate.Summary),
-					Logs:     stepUpdate.Logs,
+					LogRefs:  refs,
+
+					PeakMemory: stepUpdate.PeakMemory,
+					CpuTime:    time.Duration(stepUpdate.CpuTime),
 				}
 				job.Steps = append(job.Steps, step)
 			}
@@ -292,6 +427,7 @@ func (m *RpcService) PickJob(stream pb.Builder_PickJobServer) error {
 
 			// Save on the database
 			m.master.saveDatabaseJob(job)
+			m.master.notifyJobWatchers(job.Id)
 
 			// Update Web socket clients
 			m.master.updateStatistics()
@@ -302,6 +438,29 @@ func (m *RpcService) PickJob(stream pb.Builder_PickJobServer) error {
 	return nil
 }
 
+// Return whether a slave other than name has the identifier id.
+// Must be called with the slaves mutex held.
+func (m *RpcService) slaveIdTaken(id uint64, name string) bool {
+	for _, s := range m.Slaves {
+		if s != nil && s.Id == id && s.Name != name {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the slave with the given identifier, nil if not found.
+func (m *RpcService) findSlave(id uint64) *Slave {
+	m.sMutex.Lock()
+	defer m.sMutex.Unlock()
+	for _, s := range m.Slaves {
+		if s != nil && s.Id == id {
+			return s
+		}
+	}
+	return nil
+}
+
 // Unregister a slave and stop it immediately.
 // Jobs will not be dispatched to this slave until it has subscribed again.
 func (m *RpcService) Unsubscribe(ctx context.Context, args *pb.UnsubscribeRequest) (*pb.UnsubscribeResponse, error) {
@@ -319,6 +478,7 @@ func (m *RpcService) Unsubscribe(ctx context.Context, args *pb.UnsubscribeReques
 			logging.Infof("Slave \"%s\" unsubscribed", slave.Name)
 			slave.Subscribed = false
 			slave.Stop()
+			go m.master.updateSlaves()
 			reply.Result = true
 			return reply, nil
 		}
@@ -329,6 +489,45 @@ func (m *RpcService) Unsubscribe(ctx context.Context, args *pb.UnsubscribeReques
 	return reply, ErrSlaveNotFound
 }
 
+// Stop dispatching jobs to a slave, those it's processing are
+// allowed to finish.
+func (m *RpcService) DrainSlave(ctx context.Context, args *pb.StringMessage) (*pb.BooleanMessage, error) {
+	if err := m.setSlaveDraining(args.Name, true); err != nil {
+		return &pb.BooleanMessage{Result: false}, err
+	}
+	logging.Infof("Slave \"%s\" drained\n", args.Name)
+	return &pb.BooleanMessage{Result: true}, nil
+}
+
+// Dispatch jobs again to a drained slave.
+func (m *RpcService) ResumeSlave(ctx context.Context, args *pb.StringMessage) (*pb.BooleanMessage, error) {
+	if err := m.setSlaveDraining(args.Name, false); err != nil {
+		return &pb.BooleanMessage{Result: false}, err
+	}
+	logging.Infof("Slave \"%s\" resumed\n", args.Name)
+	return &pb.BooleanMessage{Result: true}, nil
+}
+
+// Change whether the slave called name is drained.
+func (m *RpcService) setSlaveDraining(name string, draining bool) error {
+	m.sMutex.Lock()
+	found := false
+	for _, s := range m.Slaves {
+		if s != nil && s.Name == name {
+			s.SetDraining(draining)
+			found = true
+		}
+	}
+	m.sMutex.Unlock()
+	if !found {
+		return ErrSlaveNotFound
+	}
+
+	// Update Web socket clients
+	m.master.updateSlaves()
+	return nil
+}
+
 // Create and enqueue a job.
 func (m *RpcService) CollectJob(ctx context.Context, args *pb.CollectJobRequest) (*pb.CollectJobResponse, error) {
 	var (
@@ -336,7 +535,8 @@ func (m *RpcService) CollectJob(ctx context.Context, args *pb.CollectJobRequest)
 		id     uint64 = 0
 	)
 
-	j, err := m.enqueueJob(args.Target, args.Architecture, args.Type)
+	j, err := m.enqueueJob(args.Target, args.Architecture, args.Type,
+		args.PackagingRevision, args.UpstreamRevision)
 	if err != nil {
 		return nil, err
 	}
@@ -352,6 +552,8 @@ func (m *RpcService) CollectJob(ctx context.Context, args *pb.CollectJobRequest)
 // Upload a file from slave to master.
 func (m *RpcService) Upload(stream pb.Builder_UploadServer) error {
 	var file *os.File = nil
+	var request *pb.UploadRequest = nil
+	var destpath string
 
 	// Count how many bytes we write
 	total := int64(0)
@@ -359,9 +561,6 @@ func (m *RpcService) Upload(stream pb.Builder_UploadServer) error {
 	// SHA256 hash
 	hasher := sha256.New()
 
-	// Regular expressions for RPMs
-	re := regexp.MustCompile(`^(.+)\.([a-z0-9\-_]+)\.rpm$`)
-
 	for {
 		// Read request from the stream
 		in, err := stream.Recv()
@@ -373,17 +572,16 @@ func (m *RpcService) Upload(stream pb.Builder_UploadServer) error {
 		}
 
 		// Create the file if needed
-		request := in.GetRequest()
-		if request != nil {
+		if in.GetRequest() != nil {
+			request = in.GetRequest()
+
 			// Determine the final location
-			destpath := ""
-			m := re.FindStringSubmatch(request.FileName)
-			if len(m) == 3 {
-				letter := m[1][:1]
-				destpath = fmt.Sprintf("%s/fedora/releases/%s/%s/os/Packages/%s/%s",
-					Config.Storage.RepositoryDir, request.ReleaseVer, request.BaseArch,
-					letter, request.FileName)
+			if request.Image != "" {
+				destpath = imagePath(Config.Storage.ImagesDir, request)
 			} else {
+				destpath = repositoryPath(Config.Storage.RepositoryDir, request)
+			}
+			if destpath == "" {
 				return stream.SendAndClose(&pb.UploadResponse{total, "invalid file name"})
 			}
 
@@ -414,7 +612,7 @@ func (m *RpcService) Upload(stream pb.Builder_UploadServer) error {
 			total += int64(size)
 
 			// Update hash with this chunk
-			hasher.Sum(chunk.Data)
+			hasher.Write(chunk.Data)
 		}
 		file.Sync()
 
@@ -435,6 +633,29 @@ func (m *RpcService) Upload(stream pb.Builder_UploadServer) error {
 				return stream.SendAndClose(&pb.UploadResponse{total, errMsg.Error()})
 			}
 
+			// Sign packages before they are published
+			if signingEnabled() && request != nil && strings.HasSuffix(request.FileName, ".rpm") {
+				if err := signRpm(destpath); err != nil {
+					logging.Errorln(err)
+					os.Remove(destpath)
+					return stream.SendAndClose(&pb.UploadResponse{total, err.Error()})
+				}
+			}
+
+			// Publish the checksum of images
+			if request != nil && request.Image != "" {
+				m.master.addImageChecksum(destpath, hash)
+			}
+
+			// Remember which job produced it
+			if request != nil && request.JobId != 0 {
+				rootdir := Config.Storage.RepositoryDir
+				if request.Image != "" {
+					rootdir = Config.Storage.ImagesDir
+				}
+				m.master.addJobArtifact(request.JobId, rootdir, destpath, request.ReleaseVer)
+			}
+
 			break
 		}
 	}
@@ -447,13 +668,23 @@ func (m *RpcService) Download(request *pb.DownloadRequest, stream pb.Builder_Dow
 	// SHA256 hash
 	hasher := sha256.New()
 
+	// Relative paths are artifacts in the repository or images
+	filename := request.FileName
+	if !filepath.IsAbs(filename) {
+		rootdir := Config.Storage.RepositoryDir
+		if request.Image {
+			rootdir = Config.Storage.ImagesDir
+		}
+		filename = filepath.Join(rootdir, filepath.Clean("/"+filename))
+	}
+
 	// Open the file
-	file, err := os.Open(request.FileName)
+	file, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	stat, err := os.Stat(request.FileName)
+	stat, err := os.Stat(filename)
 	if err != nil {
 		return err
 	}
@@ -466,11 +697,16 @@ func (m *RpcService) Download(request *pb.DownloadRequest, stream pb.Builder_Dow
 			break
 		}
 		if err == nil {
+			// Update hash of the whole file
+			hasher.Write(chunk[:size])
+
+			// Send chunk with its own hash
+			hash := sha256.Sum256(chunk[:size])
 			response := &pb.DownloadResponse{
 				Payload: &pb.DownloadResponse_Chunk{
 					Chunk: &pb.DownloadChunk{
 						Data: chunk[:size],
-						Hash: hasher.Sum(chunk[:size]),
+						Hash: hash[:],
 					},
 				},
 			}
@@ -537,6 +773,7 @@ func (m *RpcService) ListChroots(args *pb.ListChrootsRequest, stream pb.Builder_
 				Release:      chroot.OsRelease,
 				Version:      chroot.OsVersion,
 				Architecture: chroot.Architecture,
+				Active:       chroot.Active,
 			}
 			stream.Send(reply)
 		}
@@ -559,6 +796,13 @@ func (m *RpcService) AddPackage(ctx context.Context, args *pb.PackageInfo) (*pb.
 			Url:    args.UpstreamVcs.Url,
 			Branch: args.UpstreamVcs.Branch,
 		},
+		Distribution: args.Distribution,
+		ReleaseVer:   args.ReleaseVer,
+		Project:      args.Project,
+		Timeout:      time.Duration(args.Timeout) * time.Second,
+		MemoryLimit:  args.MemoryLimit,
+		CpuLimit:     args.CpuLimit,
+		PidsLimit:    args.PidsLimit,
 	}
 	if err := m.master.db.AddPackage(pkg); err != nil {
 		return nil, err
@@ -603,6 +847,13 @@ func (m *RpcService) ListPackages(args *pb.StringMessage, stream pb.Builder_List
 				Url:    pkg.UpstreamVcs.Url,
 				Branch: pkg.UpstreamVcs.Branch,
 			},
+			Distribution: pkg.Distribution,
+			ReleaseVer:   pkg.ReleaseVer,
+			Project:      pkg.Project,
+			Timeout:      uint32(pkg.Timeout / time.Second),
+			MemoryLimit:  pkg.MemoryLimit,
+			CpuLimit:     pkg.CpuLimit,
+			PidsLimit:    pkg.PidsLimit,
 		}
 		stream.Send(reply)
 	}
@@ -612,6 +863,10 @@ func (m *RpcService) ListPackages(args *pb.StringMessage, stream pb.Builder_List
 
 // Add or update an image.
 func (m *RpcService) AddImage(ctx context.Context, args *pb.ImageInfo) (*pb.BooleanMessage, error) {
+	if args.Format != "" && !database.IsValidImageFormat(args.Format) {
+		return nil, ErrInvalidImageFormat
+	}
+
 	img := &database.Image{
 		Name:          args.Name,
 		Description:   args.Description,
@@ -620,6 +875,12 @@ func (m *RpcService) AddImage(ctx context.Context, args *pb.ImageInfo) (*pb.Bool
 			Url:    args.Vcs.Url,
 			Branch: args.Vcs.Branch,
 		},
+		Kickstart:  args.Kickstart,
+		Format:     args.Format,
+		Product:    args.Product,
+		ReleaseVer: args.ReleaseVer,
+		Variables:  args.Variables,
+		Timeout:    time.Duration(args.Timeout) * time.Second,
 	}
 	if err := m.master.db.AddImage(img); err != nil {
 		return nil, err
@@ -660,6 +921,12 @@ func (m *RpcService) ListImages(args *pb.StringMessage, stream pb.Builder_ListIm
 				Url:    img.Vcs.Url,
 				Branch: img.Vcs.Branch,
 			},
+			Kickstart:  img.Kickstart,
+			Format:     img.Format,
+			Product:    img.Product,
+			ReleaseVer: img.ReleaseVer,
+			Variables:  img.Variables,
+			Timeout:    uint32(img.Timeout / time.Second),
 		}
 		stream.Send(reply)
 	}
@@ -667,19 +934,99 @@ func (m *RpcService) ListImages(args *pb.StringMessage, stream pb.Builder_ListIm
 	return nil
 }
 
-// Enqueue a job.
-func (m *RpcService) enqueueJob(target, arch string, t pb.EnumTargetType) (*Job, error) {
+// Add or update a project.
+func (m *RpcService) AddProject(ctx context.Context, args *pb.ProjectInfo) (*pb.BooleanMessage, error) {
+	prj := &database.Project{
+		Name:           args.Name,
+		Description:    args.Description,
+		Repos:          args.Repos,
+		Chroots:        args.Chroots,
+		AutoCreateRepo: args.AutoCreaterepo,
+		BuildEnableNet: args.BuildEnableNet,
+	}
+	if err := m.master.db.AddProject(prj); err != nil {
+		return nil, err
+	}
+	m.master.updateRepoFiles(Config.Storage.RepositoryDir)
+	return &pb.BooleanMessage{Result: true}, nil
+}
+
+// Remove a project.
+func (m *RpcService) RemoveProject(ctx context.Context, args *pb.StringMessage) (*pb.BooleanMessage, error) {
+	err := m.master.db.RemoveProject(args.Name)
+	if err != nil {
+		return nil, err
+	}
+	m.master.updateRepoFiles(Config.Storage.RepositoryDir)
+	return &pb.BooleanMessage{Result: true}, nil
+}
+
+// List projects matching the regular expression.
+func (m *RpcService) ListProjects(args *pb.StringMessage, stream pb.Builder_ListProjectsServer) error {
+	r, err := regexp.Compile(args.Name)
+	if err != nil {
+		return err
+	}
+
+	list := m.master.db.ListAllProjects()
+	if len(list) == 0 {
+		return ErrNoMatchingProjects
+	}
+
+	for _, prj := range list {
+		if !r.MatchString(prj.Name) {
+			continue
+		}
+		reply := &pb.ProjectInfo{
+			Name:           prj.Name,
+			Description:    prj.Description,
+			Repos:          prj.Repos,
+			Chroots:        prj.Chroots,
+			AutoCreaterepo: prj.AutoCreateRepo,
+			BuildEnableNet: prj.BuildEnableNet,
+		}
+		stream.Send(reply)
+	}
+
+	return nil
+}
+
+// Enqueue a job, building the branch heads unless revisions are specified.
+func (m *RpcService) enqueueJob(target, arch string, t pb.EnumTargetType, packagingRev, upstreamRev string) (*Job, error) {
+	// Revisions are passed to git
+	for _, rev := range []string{packagingRev, upstreamRev} {
+		if rev != "" && !revisionRegexp.MatchString(rev) {
+			return nil, ErrInvalidRevision
+		}
+	}
+
 	// Verify if the target exists
+	distro := builder.DEFAULT_DISTRIBUTION
 	switch t {
 	case pb.EnumTargetType_PACKAGE:
-		if !m.master.db.HasPackage(target) {
+		pkg := m.master.db.GetPackage(target)
+		if pkg == nil {
 			return nil, fmt.Errorf("%s package not found", target)
 		}
+		if pkg.Distribution != "" {
+			distro = pkg.Distribution
+		}
+		if isAptDistribution(distro) {
+			if _, err := m.master.db.FindActiveChroot(distro, pkg.ReleaseVer, arch); err != nil {
+				return nil, fmt.Errorf("%s %s for %s: %s", distro, pkg.ReleaseVer, arch, err)
+			}
+		}
+		if upstreamRev != "" && !pkg.Ci {
+			return nil, ErrUpstreamRevision
+		}
 		break
 	case pb.EnumTargetType_IMAGE:
 		if !m.master.db.HasImage(target) {
 			return nil, fmt.Errorf("%s image not found", target)
 		}
+		if upstreamRev != "" {
+			return nil, ErrUpstreamRevision
+		}
 		break
 	default:
 		return nil, fmt.Errorf("Wrong target type specified for \"%s\" (%s)\n", target, arch)
@@ -692,12 +1039,17 @@ func (m *RpcService) enqueueJob(target, arch string, t pb.EnumTargetType) (*Job,
 			Type:         jobTargetMap[t],
 			Target:       target,
 			Architecture: arch,
+			Distribution: distro,
 			Started:      time.Now(),
 			Finished:     time.Time{},
 			Status:       builder.JOB_STATUS_JUST_CREATED,
 			Steps:        make([]*builder.Step, 0),
+
+			PackagingRevision: packagingRev,
+			UpstreamRevision:  upstreamRev,
 		},
 		make(chan bool),
+		"",
 	}
 
 	// Append job
@@ -711,3 +1063,342 @@ func (m *RpcService) enqueueJob(target, arch string, t pb.EnumTargetType) (*Job,
 
 	return j, nil
 }
+
+// Stream the output of a job, following new output if requested.
+func (m *RpcService) GetJobOutput(args *pb.JobOutputRequest, stream pb.Builder_GetJobOutputServer) error {
+	if m.master.db.GetJob(args.Id) == nil {
+		return ErrJobNotFound
+	}
+
+	lines, step, c, err := m.master.followJobOutput(args.Id, args.Follow)
+	if err != nil {
+		return err
+	}
+
+	// Send what we have so far in chunks, at least one message
+	// is sent so that the client knows the last step
+	for {
+		n := len(lines)
+		if n > 1000 {
+			n = 1000
+		}
+		if err := stream.Send(&pb.JobOutput{JobId: args.Id, Step: step, Lines: lines[:n]}); err != nil {
+			if c != nil {
+				m.master.unfollowJobOutput(args.Id, c)
+			}
+			return err
+		}
+		lines = lines[n:]
+		if len(lines) == 0 {
+			break
+		}
+	}
+	if c == nil {
+		return nil
+	}
+
+	// Follow until the job has finished or the client went away
+	defer m.master.unfollowJobOutput(args.Id, c)
+	for {
+		select {
+		case output, ok := <-c:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(output); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Return job information.
+func (m *RpcService) GetJob(ctx context.Context, args *pb.GetJobRequest) (*pb.JobInfo, error) {
+	job := m.master.db.GetJob(args.Id)
+	if job == nil {
+		return nil, ErrJobNotFound
+	}
+	return jobInfo(job, true), nil
+}
+
+// Stream a build step log.
+func (m *RpcService) GetLog(args *pb.GetLogRequest, stream pb.Builder_GetLogServer) error {
+	job := m.master.db.GetJob(args.JobId)
+	if job == nil {
+		return ErrJobNotFound
+	}
+
+	// Find the log, jobs saved before logs were stored separately
+	// still have them inline until they are migrated
+	var reader io.ReadSeeker
+	var size int64
+	for _, step := range job.Steps {
+		if step.Name != args.Step {
+			continue
+		}
+		if ref, ok := step.LogRefs[args.Name]; ok {
+			log, err := openLog(ref.Id)
+			if err != nil {
+				return err
+			}
+			defer log.Close()
+			reader, size = log, log.Size()
+		} else if log, ok := step.Logs[args.Name]; ok {
+			reader, size = bytes.NewReader(log), int64(len(log))
+		}
+		break
+	}
+	if reader == nil {
+		return ErrLogNotFound
+	}
+
+	if args.Tail > 0 && size > args.Tail {
+		if _, err := reader.Seek(size-args.Tail, os.SEEK_SET); err != nil {
+			return err
+		}
+	}
+
+	// Send it in chunks, at least one is sent
+	buffer := make([]byte, 1024*1024)
+	for first := true; ; first = false {
+		n, err := io.ReadFull(reader, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if n > 0 || first {
+			if err := stream.Send(&pb.LogChunk{Data: buffer[:n]}); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Stream jobs matching the criteria, most recent first.
+func (m *RpcService) ListJobs(args *pb.ListJobsRequest, stream pb.Builder_ListJobsServer) error {
+	var re *regexp.Regexp
+	if args.Target != "" {
+		var err error
+		re, err = regexp.Compile(args.Target)
+		if err != nil {
+			return err
+		}
+	}
+
+	statuses := make(map[builder.JobStatus]bool)
+	for _, status := range args.Statuses {
+		statuses[jobStatusMap[status]] = true
+	}
+
+	types := make(map[builder.JobTargetType]bool)
+	for _, t := range args.Types {
+		types[jobTargetMap[t]] = true
+	}
+
+	jobs := m.master.db.FilterJobs(func(job *builder.Job) bool {
+		if len(statuses) > 0 && !statuses[job.Status] {
+			return false
+		}
+		if len(types) > 0 && !types[job.Type] {
+			return false
+		}
+		if re != nil && !re.MatchString(job.Target) {
+			return false
+		}
+		if args.Architecture != "" && job.Architecture != args.Architecture {
+			return false
+		}
+		if args.Slave != "" && job.Slave != args.Slave {
+			return false
+		}
+		if args.StartedAfter > 0 && job.Started.UnixNano() < args.StartedAfter {
+			return false
+		}
+		if args.StartedBefore > 0 && job.Started.UnixNano() > args.StartedBefore {
+			return false
+		}
+		return true
+	})
+	sort.Sort(sort.Reverse(jobsById(jobs)))
+
+	// Keep only the first job of each target, which is the most recent
+	if args.LastPerTarget {
+		seen := make(map[string]bool)
+		last := jobs[:0]
+		for _, job := range jobs {
+			key := job.Type.String() + "/" + job.Target
+			if !seen[key] {
+				seen[key] = true
+				last = append(last, job)
+			}
+		}
+		jobs = last
+	}
+
+	// Paginate
+	if int(args.Offset) >= len(jobs) {
+		return nil
+	}
+	jobs = jobs[args.Offset:]
+	if args.Limit > 0 && int(args.Limit) < len(jobs) {
+		jobs = jobs[:args.Limit]
+	}
+
+	for _, job := range jobs {
+		if err := stream.Send(jobInfo(job, false)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List builds matching the criteria, most recent first.
+func (m *RpcService) ListBuilds(args *pb.ListBuildsRequest, stream pb.Builder_ListBuildsServer) error {
+	var re *regexp.Regexp
+	if args.Nevr != "" {
+		var err error
+		re, err = regexp.Compile(args.Nevr)
+		if err != nil {
+			return err
+		}
+	}
+
+	builds := m.master.db.FilterBuilds(args.Package, args.Chroot, func(build *database.Build) bool {
+		if re != nil && !re.MatchString(build.Nevr) {
+			return false
+		}
+		if args.Commit != "" &&
+			!strings.HasPrefix(build.PackagingCommit, args.Commit) &&
+			!strings.HasPrefix(build.UpstreamCommit, args.Commit) {
+			return false
+		}
+		if args.Artifact != "" {
+			for _, artifact := range build.Artifacts {
+				if filepath.Base(artifact.FileName) == args.Artifact {
+					return true
+				}
+			}
+			return false
+		}
+		return true
+	})
+	sort.Sort(sort.Reverse(buildsByFinished(builds)))
+
+	for _, build := range builds {
+		if err := stream.Send(buildInfo(build)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stream job information every time the job changes until it has finished.
+func (m *RpcService) WatchJob(args *pb.WatchJobRequest, stream pb.Builder_WatchJobServer) error {
+	c := m.master.watchJob(args.Id)
+	if c != nil {
+		defer m.master.unwatchJob(args.Id, c)
+	}
+
+	// Send the current status first, then again every time
+	// the job changes and finally when it's done
+	for {
+		job := m.master.db.GetJob(args.Id)
+		if job == nil {
+			return ErrJobNotFound
+		}
+		if err := stream.Send(jobInfo(job, true)); err != nil {
+			return err
+		}
+		if c == nil {
+			return nil
+		}
+
+		select {
+		case _, ok := <-c:
+			if !ok {
+				c = nil
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Return time as nanoseconds since Epoch or 0 when not set.
+func timeToNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// Convert a job to its protocol representation, build steps
+// are included only when withSteps is true.
+func jobInfo(job *builder.Job, withSteps bool) *pb.JobInfo {
+	info := &pb.JobInfo{
+		Id:           job.Id,
+		Type:         pbJobTargetMap[job.Type],
+		Target:       job.Target,
+		Architecture: job.Architecture,
+		Distribution: job.Distribution,
+		Status:       pbJobStatusMap[job.Status],
+		Started:      timeToNano(job.Started),
+		Finished:     timeToNano(job.Finished),
+		Slave:        job.Slave,
+		Artifacts:    job.Artifacts,
+
+		PackagingCommit: job.PackagingCommit,
+		UpstreamCommit:  job.UpstreamCommit,
+	}
+	if !withSteps {
+		return info
+	}
+	for _, step := range job.Steps {
+		stepInfo := &pb.StepInfo{
+			Name:       step.Name,
+			Started:    timeToNano(step.Started),
+			Finished:   timeToNano(step.Finished),
+			PeakMemory: step.PeakMemory,
+			CpuTime:    int64(step.CpuTime),
+		}
+		if len(step.Summary) > 0 {
+			stepInfo.Summary = make(map[string]string)
+			for key, values := range step.Summary {
+				stepInfo.Summary[key] = strings.Join(values, "\n")
+			}
+		}
+		for name, ref := range step.LogRefs {
+			stepInfo.Logs = append(stepInfo.Logs, &pb.LogInfo{Name: name, Size: ref.Size, Truncated: ref.Truncated})
+		}
+		for name, data := range step.Logs {
+			if _, ok := step.LogRefs[name]; !ok {
+				stepInfo.Logs = append(stepInfo.Logs, &pb.LogInfo{Name: name, Size: int64(len(data))})
+			}
+		}
+		sort.Sort(logInfoByName(stepInfo.Logs))
+		info.Steps = append(info.Steps, stepInfo)
+	}
+	return info
+}
+
+// Sort log information by name.
+type logInfoByName []*pb.LogInfo
+
+func (l logInfoByName) Len() int           { return len(l) }
+func (l logInfoByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l logInfoByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+
+// Sort jobs by identifier.
+type jobsById []*builder.Job
+
+func (l jobsById) Len() int           { return len(l) }
+func (l jobsById) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l jobsById) Less(i, j int) bool { return l[i].Id < l[j].Id }
@@ -26,6 +26,13 @@
 
 package master
 
+import (
+	"github.com/hawaii-desktop/builder"
+	"github.com/hawaii-desktop/builder/utils"
+	"strings"
+	"sync"
+)
+
 // Slave structure
 type Slave struct {
 	// Identifier.
@@ -36,34 +43,62 @@ type Slave struct {
 	Types []string
 	// Supported architectures.
 	Architectures []string
+	// Factories available, in the <kind>/<distribution> format.
+	Factories []string
 	// Whether it has subscribed to the stream or not.
 	Subscribed bool
 	// Whether it is active or not.
 	Active bool
+	// Whether it can accept new jobs, slaves that are running out
+	// of disk space are not ready.
+	Ready bool
+	// Why it is not ready.
+	NotReadyReason string
+	// Whether it was drained by an operator.
+	Draining bool
+	// Whether it is shutting down.
+	ShuttingDown bool
+	// Closed when the slave is ready, neither drained nor shutting down.
+	readyChannel chan struct{}
+	// Protects readiness and draining.
+	rMutex sync.Mutex
 	// Channels to pick up jobs from for each topic.
 	jobChannels map[string]chan *Job
-	// Channel used to stop processing jobs for each topic.
+	// Channel used to stop processing jobs for each topic,
+	// buffered so that stopping never blocks.
 	quitChannels map[string]chan bool
 }
 
 // Creates and returns a new Slave object
-func NewSlave(id uint64, name string, types []string, archs []string) *Slave {
+func NewSlave(id uint64, name string, types, archs, factories []string) *Slave {
+	// Slaves that don't advertise their factories can only build
+	// for the default distribution
+	if len(factories) == 0 {
+		for _, ttype := range types {
+			factories = append(factories, ttype+"/"+builder.DEFAULT_DISTRIBUTION)
+		}
+	}
+
 	// Create and return the object
 	slave := &Slave{
 		Id:            id,
 		Name:          name,
 		Types:         types,
 		Architectures: archs,
+		Factories:     factories,
 		Subscribed:    true,
 		Active:        true,
+		Ready:         true,
+		readyChannel:  make(chan struct{}),
 		jobChannels:   make(map[string]chan *Job),
 		quitChannels:  make(map[string]chan bool),
 	}
+	close(slave.readyChannel)
 
 	// Initialize job channels based on topics
 	for _, topic := range slave.Topics() {
 		slave.jobChannels[topic] = make(chan *Job)
-		slave.quitChannels[topic] = make(chan bool)
+		slave.quitChannels[topic] = make(chan bool, 1)
 	}
 
 	// Return
@@ -73,9 +108,15 @@ func NewSlave(id uint64, name string, types []string, archs []string) *Slave {
 // Return the topics that this slave is interested in.
 func (s *Slave) Topics() []string {
 	var topics []string
-	for _, ttype := range s.Types {
+	for _, factory := range s.Factories {
+		// Only the types the slave was configured for
+		ttype := strings.SplitN(factory, "/", 2)[0]
+		if !utils.StringSliceContains(s.Types, ttype) {
+			continue
+		}
+
 		for _, arch := range s.Architectures {
-			topics = append(topics, ttype+"/"+arch)
+			topics = append(topics, factory+"/"+arch)
 		}
 	}
 	return topics
@@ -85,9 +126,83 @@ func (s *Slave) Topics() []string {
 func (s *Slave) Stop() {
 	s.Active = false
 
-	go func() {
-		for _, topic := range s.Topics() {
-			s.quitChannels[topic] <- true
+	for _, topic := range s.Topics() {
+		select {
+		case s.quitChannels[topic] <- true:
+		default:
+		}
+	}
+}
+
+// Change whether the slave can accept new jobs.
+func (s *Slave) SetReady(ready bool, reason string) {
+	s.rMutex.Lock()
+	defer s.rMutex.Unlock()
+
+	wasAccepting := s.accepting()
+	s.Ready = ready
+	s.NotReadyReason = reason
+	s.updateReadyChannel(wasAccepting)
+}
+
+// Change whether the slave is shutting down.
+func (s *Slave) SetShuttingDown(shuttingDown bool) {
+	s.rMutex.Lock()
+	defer s.rMutex.Unlock()
+
+	wasAccepting := s.accepting()
+	s.ShuttingDown = shuttingDown
+	s.updateReadyChannel(wasAccepting)
+}
+
+// Change whether jobs are dispatched to the slave, jobs that
+// were already dispatched are processed anyway.
+func (s *Slave) SetDraining(draining bool) {
+	s.rMutex.Lock()
+	defer s.rMutex.Unlock()
+
+	wasAccepting := s.accepting()
+	s.Draining = draining
+	s.updateReadyChannel(wasAccepting)
+}
+
+// Return whether new jobs can be dispatched to the slave right now.
+func (s *Slave) canAccept() bool {
+	s.rMutex.Lock()
+	defer s.rMutex.Unlock()
+	return s.accepting()
+}
+
+// Return whether new jobs can be dispatched to the slave.
+// Must be called with the readiness mutex held.
+func (s *Slave) accepting() bool {
+	return s.Ready && !s.Draining && !s.ShuttingDown
+}
+
+// Open or close the ready channel if the slave started or stopped
+// accepting new jobs.
+// Must be called with the readiness mutex held.
+func (s *Slave) updateReadyChannel(wasAccepting bool) {
+	if accepting := s.accepting(); accepting != wasAccepting {
+		if accepting {
+			close(s.readyChannel)
+		} else {
+			s.readyChannel = make(chan struct{})
 		}
-	}()
+	}
+}
+
+// Wait until the slave is ready to accept new jobs for topic.
+// Return false if it was asked to stop in the meantime.
+func (s *Slave) waitReady(topic string) bool {
+	s.rMutex.Lock()
+	ready := s.readyChannel
+	s.rMutex.Unlock()
+
+	select {
+	case <-ready:
+		return true
+	case <-s.quitChannels[topic]:
+		return false
+	}
 }
@@ -27,8 +27,12 @@
 package master
 
 import (
+	"fmt"
 	"github.com/plimble/ace"
+	"net/http"
+	"os"
 	"strconv"
+	"strings"
 )
 
 func WebJobHandler(c *ace.C) {
@@ -41,6 +45,23 @@ func WebJobHandler(c *ace.C) {
 	c.HTML("job.html", data)
 }
 
+func WebPackageHandler(c *ace.C) {
+	data := c.GetAll()
+	data["Name"] = c.Param("name")
+	c.HTML("package.html", data)
+}
+
+func WebImagesHandler(c *ace.C) {
+	data := c.GetAll()
+	data["Signed"] = signingEnabled()
+	data["PublicKey"] = signingPublicKeyName
+	c.HTML("images.html", data)
+}
+
+func WebSlavesHandler(c *ace.C) {
+	c.HTML("slaves.html", c.GetAll())
+}
+
 func WebJobsHandler(c *ace.C) {
 	c.HTML("jobs.html", c.GetAll())
 }
@@ -60,3 +81,47 @@ func WebJobsCompletedHandler(c *ace.C) {
 func WebJobsFailedHandler(c *ace.C) {
 	c.HTML("failed.html", c.GetAll())
 }
+
+// Serve a build log, range requests are supported so that
+// clients can fetch only the end of big logs.
+// The whole log is sent compressed as stored to clients that
+// accept it, otherwise it's decompressed while it's sent.
+func WebLogHandler(c *ace.C) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		http.NotFound(c.Writer, c.Request)
+		return
+	}
+
+	stat, err := os.Stat(logPath(id))
+	if err != nil {
+		http.NotFound(c.Writer, c.Request)
+		return
+	}
+
+	c.Writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	c.Writer.Header().Add("Vary", "Accept-Encoding")
+	name := fmt.Sprintf("%d.log", id)
+
+	if c.Request.Header.Get("Range") == "" && strings.Contains(c.Request.Header.Get("Accept-Encoding"), "gzip") {
+		file, err := os.Open(logPath(id))
+		if err != nil {
+			http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		c.Writer.Header().Set("Content-Encoding", "gzip")
+		http.ServeContent(c.Writer, c.Request, name, stat.ModTime(), file)
+		return
+	}
+
+	reader, err := openLog(id)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	http.ServeContent(c.Writer, c.Request, name, stat.ModTime(), reader)
+}
@@ -20,6 +20,7 @@ It has these top-level messages:
 	CollectJobResponse
 	JobRequest
 	SlaveStartRequest
+	SlaveStatusRequest
 	JobUpdateRequest
 	StepUpdateRequest
 	PickJobRequest
@@ -37,6 +38,20 @@ It has these top-level messages:
 	VcsInfo
 	PackageInfo
 	ImageInfo
+	JobOutput
+	JobOutputRequest
+	GetJobRequest
+	LogInfo
+	StepInfo
+	JobInfo
+	GetLogRequest
+	LogChunk
+	ListJobsRequest
+	WatchJobRequest
+	ProjectInfo
+	BuildArtifact
+	BuildInfo
+	ListBuildsRequest
 */
 package protocol
 
@@ -88,6 +103,7 @@ const (
 	EnumJobStatus_JOB_STATUS_SUCCESSFUL   EnumJobStatus = 3
 	EnumJobStatus_JOB_STATUS_FAILED       EnumJobStatus = 4
 	EnumJobStatus_JOB_STATUS_CRASHED      EnumJobStatus = 5
+	EnumJobStatus_JOB_STATUS_TIMED_OUT    EnumJobStatus = 6
 )
 
 var EnumJobStatus_name = map[int32]string{
@@ -97,6 +113,7 @@ var EnumJobStatus_name = map[int32]string{
 	3: "JOB_STATUS_SUCCESSFUL",
 	4: "JOB_STATUS_FAILED",
 	5: "JOB_STATUS_CRASHED",
+	6: "JOB_STATUS_TIMED_OUT",
 }
 var EnumJobStatus_value = map[string]int32{
 	"JOB_STATUS_JUST_CREATED": 0,
@@ -105,6 +122,7 @@ var EnumJobStatus_value = map[string]int32{
 	"JOB_STATUS_SUCCESSFUL":   3,
 	"JOB_STATUS_FAILED":       4,
 	"JOB_STATUS_CRASHED":      5,
+	"JOB_STATUS_TIMED_OUT":    6,
 }
 
 func (x EnumJobStatus) String() string {
@@ -168,6 +186,11 @@ type SubscribeRequest struct {
 	Types []string `protobuf:"bytes,2,rep,name=types" json:"types,omitempty"`
 	// Architectures.
 	Architectures []string `protobuf:"bytes,3,rep,name=architectures" json:"architectures,omitempty"`
+	// Factories available on the slave, in the <kind>/<distribution>
+	// format (for example package/fedora).
+	Factories []string `protobuf:"bytes,4,rep,name=factories" json:"factories,omitempty"`
+	// Identifier assigned by a previous subscription, when reconnecting.
+	Id uint64 `protobuf:"varint,5,opt,name=id" json:"id,omitempty"`
 }
 
 func (m *SubscribeRequest) Reset()         { *m = SubscribeRequest{} }
@@ -220,6 +243,10 @@ type CollectJobRequest struct {
 	Architecture string `protobuf:"bytes,2,opt,name=architecture" json:"architecture,omitempty"`
 	// Target type.
 	Type EnumTargetType `protobuf:"varint,3,opt,name=type,enum=protocol.EnumTargetType" json:"type,omitempty"`
+	// Packaging commit hash or tag, the branch head when empty.
+	PackagingRevision string `protobuf:"bytes,4,opt,name=packaging_revision" json:"packaging_revision,omitempty"`
+	// Upstream commit hash or tag (only for CI), the branch head when empty.
+	UpstreamRevision string `protobuf:"bytes,5,opt,name=upstream_revision" json:"upstream_revision,omitempty"`
 }
 
 func (m *CollectJobRequest) Reset()         { *m = CollectJobRequest{} }
@@ -249,6 +276,11 @@ type JobRequest struct {
 	//	*JobRequest_Package
 	//	*JobRequest_Image
 	Payload isJobRequest_Payload `protobuf_oneof:"payload"`
+	// Distribution, used to pick the factory.
+	Distribution string `protobuf:"bytes,4,opt,name=distribution" json:"distribution,omitempty"`
+	// Set when the master has processed a status with draining set
+	// instead of sending a job: no job follows.
+	DrainingAck bool `protobuf:"varint,5,opt,name=draining_ack" json:"draining_ack,omitempty"`
 }
 
 func (m *JobRequest) Reset()         { *m = JobRequest{} }
@@ -353,12 +385,38 @@ func (m *SlaveStartRequest) Reset()         { *m = SlaveStartRequest{} }
 func (m *SlaveStartRequest) String() string { return proto.CompactTextString(m) }
 func (*SlaveStartRequest) ProtoMessage()    {}
 
+// Tell the master whether the slave can accept new jobs,
+// this may be sent before SlaveStartRequest.
+type SlaveStatusRequest struct {
+	// Slave identifier.
+	Id uint64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	// Whether new jobs can be dispatched to the slave.
+	Ready bool `protobuf:"varint,2,opt,name=ready" json:"ready,omitempty"`
+	// Why the slave is not ready (for example: low disk space).
+	Reason string `protobuf:"bytes,3,opt,name=reason" json:"reason,omitempty"`
+	// Whether the slave is shutting down and should not receive
+	// new jobs.
+	Draining bool `protobuf:"varint,4,opt,name=draining" json:"draining,omitempty"`
+}
+
+func (m *SlaveStatusRequest) Reset()         { *m = SlaveStatusRequest{} }
+func (m *SlaveStatusRequest) String() string { return proto.CompactTextString(m) }
+func (*SlaveStatusRequest) ProtoMessage()    {}
+
 // Contains updated information on a job being processed.
 type JobUpdateRequest struct {
 	// Identifier.
 	Id uint64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
 	// Current status of the job.
 	Status EnumJobStatus `protobuf:"varint,2,opt,name=status,enum=protocol.EnumJobStatus" json:"status,omitempty"`
+	// Name, epoch, version and release of the package built.
+	Nevr string `protobuf:"bytes,3,opt,name=nevr" json:"nevr,omitempty"`
+	// Packaging commit hash, kickstart commit hash for images.
+	PackagingCommit string `protobuf:"bytes,4,opt,name=packaging_commit" json:"packaging_commit,omitempty"`
+	// Upstream commit hash.
+	UpstreamCommit string `protobuf:"bytes,5,opt,name=upstream_commit" json:"upstream_commit,omitempty"`
+	// Upstream changes since the last successful build.
+	Changes []string `protobuf:"bytes,6,rep,name=changes" json:"changes,omitempty"`
 }
 
 func (m *JobUpdateRequest) Reset()         { *m = JobUpdateRequest{} }
@@ -381,6 +439,10 @@ type StepUpdateRequest struct {
 	Summary map[string]string `protobuf:"bytes,6,rep,name=summary" json:"summary,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	// Other optional logs.
 	Logs map[string][]byte `protobuf:"bytes,7,rep,name=logs" json:"logs,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Peak memory usage in bytes, when known.
+	PeakMemory uint64 `protobuf:"varint,8,opt,name=peak_memory" json:"peak_memory,omitempty"`
+	// CPU time in nanoseconds, when known.
+	CpuTime int64 `protobuf:"varint,9,opt,name=cpu_time" json:"cpu_time,omitempty"`
 }
 
 func (m *StepUpdateRequest) Reset()         { *m = StepUpdateRequest{} }
@@ -407,6 +469,8 @@ type PickJobRequest struct {
 	//	*PickJobRequest_SlaveStart
 	//	*PickJobRequest_JobUpdate
 	//	*PickJobRequest_StepUpdate
+	//	*PickJobRequest_JobOutput
+	//	*PickJobRequest_SlaveStatus
 	Payload isPickJobRequest_Payload `protobuf_oneof:"payload"`
 }
 
@@ -427,10 +491,18 @@ type PickJobRequest_JobUpdate struct {
 type PickJobRequest_StepUpdate struct {
 	StepUpdate *StepUpdateRequest `protobuf:"bytes,3,opt,name=step_update,oneof"`
 }
+type PickJobRequest_JobOutput struct {
+	JobOutput *JobOutput `protobuf:"bytes,4,opt,name=job_output,oneof"`
+}
+type PickJobRequest_SlaveStatus struct {
+	SlaveStatus *SlaveStatusRequest `protobuf:"bytes,5,opt,name=slave_status,oneof"`
+}
 
-func (*PickJobRequest_SlaveStart) isPickJobRequest_Payload() {}
-func (*PickJobRequest_JobUpdate) isPickJobRequest_Payload()  {}
-func (*PickJobRequest_StepUpdate) isPickJobRequest_Payload() {}
+func (*PickJobRequest_SlaveStart) isPickJobRequest_Payload()  {}
+func (*PickJobRequest_JobUpdate) isPickJobRequest_Payload()   {}
+func (*PickJobRequest_StepUpdate) isPickJobRequest_Payload()  {}
+func (*PickJobRequest_JobOutput) isPickJobRequest_Payload()   {}
+func (*PickJobRequest_SlaveStatus) isPickJobRequest_Payload() {}
 
 func (m *PickJobRequest) GetPayload() isPickJobRequest_Payload {
 	if m != nil {
@@ -460,12 +532,28 @@ func (m *PickJobRequest) GetStepUpdate() *StepUpdateRequest {
 	return nil
 }
 
+func (m *PickJobRequest) GetJobOutput() *JobOutput {
+	if x, ok := m.GetPayload().(*PickJobRequest_JobOutput); ok {
+		return x.JobOutput
+	}
+	return nil
+}
+
+func (m *PickJobRequest) GetSlaveStatus() *SlaveStatusRequest {
+	if x, ok := m.GetPayload().(*PickJobRequest_SlaveStatus); ok {
+		return x.SlaveStatus
+	}
+	return nil
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*PickJobRequest) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), []interface{}) {
 	return _PickJobRequest_OneofMarshaler, _PickJobRequest_OneofUnmarshaler, []interface{}{
 		(*PickJobRequest_SlaveStart)(nil),
 		(*PickJobRequest_JobUpdate)(nil),
 		(*PickJobRequest_StepUpdate)(nil),
+		(*PickJobRequest_JobOutput)(nil),
+		(*PickJobRequest_SlaveStatus)(nil),
 	}
 }
 
@@ -488,6 +576,16 @@ func _PickJobRequest_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 		if err := b.EncodeMessage(x.StepUpdate); err != nil {
 			return err
 		}
+	case *PickJobRequest_JobOutput:
+		b.EncodeVarint(4<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.JobOutput); err != nil {
+			return err
+		}
+	case *PickJobRequest_SlaveStatus:
+		b.EncodeVarint(5<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.SlaveStatus); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("PickJobRequest.Payload has unexpected type %T", x)
@@ -522,6 +620,22 @@ func _PickJobRequest_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto
 		err := b.DecodeMessage(msg)
 		m.Payload = &PickJobRequest_StepUpdate{msg}
 		return true, err
+	case 4: // payload.job_output
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(JobOutput)
+		err := b.DecodeMessage(msg)
+		m.Payload = &PickJobRequest_JobOutput{msg}
+		return true, err
+	case 5: // payload.slave_status
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(SlaveStatusRequest)
+		err := b.DecodeMessage(msg)
+		m.Payload = &PickJobRequest_SlaveStatus{msg}
+		return true, err
 	default:
 		return false, nil
 	}
@@ -535,6 +649,14 @@ type UploadRequest struct {
 	ReleaseVer string `protobuf:"bytes,2,opt,name=release_ver" json:"release_ver,omitempty"`
 	// Package architecture.
 	BaseArch string `protobuf:"bytes,3,opt,name=base_arch" json:"base_arch,omitempty"`
+	// Distribution the artifact was built for.
+	Distribution string `protobuf:"bytes,4,opt,name=distribution" json:"distribution,omitempty"`
+	// Job that produced the artifact.
+	JobId uint64 `protobuf:"varint,5,opt,name=job_id" json:"job_id,omitempty"`
+	// Image the artifact belongs to, empty for packages.
+	Image string `protobuf:"bytes,6,opt,name=image" json:"image,omitempty"`
+	// Source package of Debian artifacts.
+	Source string `protobuf:"bytes,7,opt,name=source" json:"source,omitempty"`
 }
 
 func (m *UploadRequest) Reset()         { *m = UploadRequest{} }
@@ -705,6 +827,9 @@ func (*UploadResponse) ProtoMessage()    {}
 type DownloadRequest struct {
 	// Desired file name.
 	FileName string `protobuf:"bytes,1,opt,name=file_name" json:"file_name,omitempty"`
+	// Relative file names are image artifacts relative to the images
+	// directory, instead of the repository directory.
+	Image bool `protobuf:"varint,2,opt,name=image" json:"image,omitempty"`
 }
 
 func (m *DownloadRequest) Reset()         { *m = DownloadRequest{} }
@@ -847,12 +972,14 @@ func (*ListChrootsRequest) ProtoMessage()    {}
 
 // Chroot information.
 type ChrootInfo struct {
-	// Release (fedora, epel, ...)
+	// Release (fedora, epel, debian, ubuntu, ...)
 	Release string `protobuf:"bytes,1,opt,name=release" json:"release,omitempty"`
-	// Version (22, 23, rawhide, ...)
+	// Version (22, 23, rawhide, sid, xenial, ...)
 	Version string `protobuf:"bytes,2,opt,name=version" json:"version,omitempty"`
 	// Architecture (i386, x86_64, armhfp, ...)
 	Architecture string `protobuf:"bytes,3,opt,name=architecture" json:"architecture,omitempty"`
+	// Whether it is active.
+	Active bool `protobuf:"varint,4,opt,name=active" json:"active,omitempty"`
 }
 
 func (m *ChrootInfo) Reset()         { *m = ChrootInfo{} }
@@ -863,6 +990,8 @@ func (*ChrootInfo) ProtoMessage()    {}
 type VcsInfo struct {
 	Url    string `protobuf:"bytes,1,opt,name=url" json:"url,omitempty"`
 	Branch string `protobuf:"bytes,2,opt,name=branch" json:"branch,omitempty"`
+	// Commit hash or tag to check out instead of the branch head.
+	Revision string `protobuf:"bytes,3,opt,name=revision" json:"revision,omitempty"`
 }
 
 func (m *VcsInfo) Reset()         { *m = VcsInfo{} }
@@ -881,6 +1010,23 @@ type PackageInfo struct {
 	Vcs *VcsInfo `protobuf:"bytes,4,opt,name=vcs" json:"vcs,omitempty"`
 	// VCS for upstream (only for CI).
 	UpstreamVcs *VcsInfo `protobuf:"bytes,5,opt,name=upstream_vcs" json:"upstream_vcs,omitempty"`
+	// Distribution (fedora, archlinux, debian, ubuntu, ...).
+	Distribution string `protobuf:"bytes,6,opt,name=distribution" json:"distribution,omitempty"`
+	// Distribution release (sid, xenial, ...), it selects the chroot
+	// when more than one is active for apt distributions.
+	ReleaseVer string `protobuf:"bytes,7,opt,name=release_ver" json:"release_ver,omitempty"`
+	// Project the package belongs to.
+	Project string `protobuf:"bytes,8,opt,name=project" json:"project,omitempty"`
+	// Upstream commit hash of the last successful build (only for CI).
+	PreviousUpstreamCommit string `protobuf:"bytes,9,opt,name=previous_upstream_commit" json:"previous_upstream_commit,omitempty"`
+	// Build timeout in seconds, 0 to use the slave default.
+	Timeout uint32 `protobuf:"varint,10,opt,name=timeout" json:"timeout,omitempty"`
+	// Memory limit in bytes, 0 to use the slave default.
+	MemoryLimit uint64 `protobuf:"varint,11,opt,name=memory_limit" json:"memory_limit,omitempty"`
+	// CPU limit in thousandths of a CPU, 0 to use the slave default.
+	CpuLimit uint32 `protobuf:"varint,12,opt,name=cpu_limit" json:"cpu_limit,omitempty"`
+	// Maximum number of processes, 0 to use the slave default.
+	PidsLimit uint32 `protobuf:"varint,13,opt,name=pids_limit" json:"pids_limit,omitempty"`
 }
 
 func (m *PackageInfo) Reset()         { *m = PackageInfo{} }
@@ -911,6 +1057,20 @@ type ImageInfo struct {
 	Architectures []string `protobuf:"bytes,3,rep,name=architectures" json:"architectures,omitempty"`
 	// VCS with build scripts.
 	Vcs *VcsInfo `protobuf:"bytes,4,opt,name=vcs" json:"vcs,omitempty"`
+	// Kickstart file path relative to the VCS root (default depends
+	// on the architecture).
+	Kickstart string `protobuf:"bytes,5,opt,name=kickstart" json:"kickstart,omitempty"`
+	// Output format (live, raw or qcow2).
+	Format string `protobuf:"bytes,6,opt,name=format" json:"format,omitempty"`
+	// Product name.
+	Product string `protobuf:"bytes,7,opt,name=product" json:"product,omitempty"`
+	// Release version.
+	ReleaseVer string `protobuf:"bytes,8,opt,name=release_ver" json:"release_ver,omitempty"`
+	// Additional variables replaced into the kickstart, for example
+	// FOO=bar replaces @FOO@ with bar.
+	Variables map[string]string `protobuf:"bytes,9,rep,name=variables" json:"variables,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Build timeout in seconds, 0 to use the slave default.
+	Timeout uint32 `protobuf:"varint,10,opt,name=timeout" json:"timeout,omitempty"`
 }
 
 func (m *ImageInfo) Reset()         { *m = ImageInfo{} }
@@ -924,6 +1084,290 @@ func (m *ImageInfo) GetVcs() *VcsInfo {
 	return nil
 }
 
+func (m *ImageInfo) GetVariables() map[string]string {
+	if m != nil {
+		return m.Variables
+	}
+	return nil
+}
+
+// Output of the commands run by a job.
+type JobOutput struct {
+	// Job identifier.
+	JobId uint64 `protobuf:"varint,1,opt,name=job_id" json:"job_id,omitempty"`
+	// Name of the build step.
+	Step string `protobuf:"bytes,2,opt,name=step" json:"step,omitempty"`
+	// Output lines.
+	Lines []string `protobuf:"bytes,3,rep,name=lines" json:"lines,omitempty"`
+}
+
+func (m *JobOutput) Reset()         { *m = JobOutput{} }
+func (m *JobOutput) String() string { return proto.CompactTextString(m) }
+func (*JobOutput) ProtoMessage()    {}
+
+// Request the output of a job.
+type JobOutputRequest struct {
+	// Job identifier.
+	Id uint64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	// Keep streaming new output until the job has finished.
+	Follow bool `protobuf:"varint,2,opt,name=follow" json:"follow,omitempty"`
+}
+
+func (m *JobOutputRequest) Reset()         { *m = JobOutputRequest{} }
+func (m *JobOutputRequest) String() string { return proto.CompactTextString(m) }
+func (*JobOutputRequest) ProtoMessage()    {}
+
+// Request job information.
+type GetJobRequest struct {
+	// Job identifier.
+	Id uint64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *GetJobRequest) Reset()         { *m = GetJobRequest{} }
+func (m *GetJobRequest) String() string { return proto.CompactTextString(m) }
+func (*GetJobRequest) ProtoMessage()    {}
+
+// Build step log information.
+type LogInfo struct {
+	// Name.
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// Size in bytes.
+	Size int64 `protobuf:"varint,2,opt,name=size" json:"size,omitempty"`
+	// Whether the log was truncated because too big.
+	Truncated bool `protobuf:"varint,3,opt,name=truncated" json:"truncated,omitempty"`
+}
+
+func (m *LogInfo) Reset()         { *m = LogInfo{} }
+func (m *LogInfo) String() string { return proto.CompactTextString(m) }
+func (*LogInfo) ProtoMessage()    {}
+
+// Build step information.
+type StepInfo struct {
+	// Name.
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// When it has started (nanoseconds since Epoch).
+	Started int64 `protobuf:"varint,2,opt,name=started" json:"started,omitempty"`
+	// When it has finished (nanoseconds since Epoch).
+	Finished int64 `protobuf:"varint,3,opt,name=finished" json:"finished,omitempty"`
+	// Summary of this step.
+	Summary map[string]string `protobuf:"bytes,4,rep,name=summary" json:"summary,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Logs.
+	Logs []*LogInfo `protobuf:"bytes,5,rep,name=logs" json:"logs,omitempty"`
+	// Peak memory usage in bytes, when known.
+	PeakMemory uint64 `protobuf:"varint,6,opt,name=peak_memory" json:"peak_memory,omitempty"`
+	// CPU time in nanoseconds, when known.
+	CpuTime int64 `protobuf:"varint,7,opt,name=cpu_time" json:"cpu_time,omitempty"`
+}
+
+func (m *StepInfo) Reset()         { *m = StepInfo{} }
+func (m *StepInfo) String() string { return proto.CompactTextString(m) }
+func (*StepInfo) ProtoMessage()    {}
+
+func (m *StepInfo) GetSummary() map[string]string {
+	if m != nil {
+		return m.Summary
+	}
+	return nil
+}
+
+func (m *StepInfo) GetLogs() []*LogInfo {
+	if m != nil {
+		return m.Logs
+	}
+	return nil
+}
+
+// Job information.
+type JobInfo struct {
+	// Identifier.
+	Id uint64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	// Target type.
+	Type EnumTargetType `protobuf:"varint,2,opt,name=type,enum=protocol.EnumTargetType" json:"type,omitempty"`
+	// Target name.
+	Target string `protobuf:"bytes,3,opt,name=target" json:"target,omitempty"`
+	// Architecture.
+	Architecture string `protobuf:"bytes,4,opt,name=architecture" json:"architecture,omitempty"`
+	// Distribution.
+	Distribution string `protobuf:"bytes,5,opt,name=distribution" json:"distribution,omitempty"`
+	// Status.
+	Status EnumJobStatus `protobuf:"varint,6,opt,name=status,enum=protocol.EnumJobStatus" json:"status,omitempty"`
+	// When it has started (nanoseconds since Epoch).
+	Started int64 `protobuf:"varint,7,opt,name=started" json:"started,omitempty"`
+	// When it has finished (nanoseconds since Epoch).
+	Finished int64 `protobuf:"varint,8,opt,name=finished" json:"finished,omitempty"`
+	// Build steps.
+	Steps []*StepInfo `protobuf:"bytes,9,rep,name=steps" json:"steps,omitempty"`
+	// Name of the slave that picked the job up.
+	Slave string `protobuf:"bytes,10,opt,name=slave" json:"slave,omitempty"`
+	// Artifacts, relative to the repository directory or to the
+	// images directory for images.
+	Artifacts []string `protobuf:"bytes,11,rep,name=artifacts" json:"artifacts,omitempty"`
+	// Packaging commit hash, kickstart commit hash for images.
+	PackagingCommit string `protobuf:"bytes,12,opt,name=packaging_commit" json:"packaging_commit,omitempty"`
+	// Upstream commit hash.
+	UpstreamCommit string `protobuf:"bytes,13,opt,name=upstream_commit" json:"upstream_commit,omitempty"`
+}
+
+func (m *JobInfo) Reset()         { *m = JobInfo{} }
+func (m *JobInfo) String() string { return proto.CompactTextString(m) }
+func (*JobInfo) ProtoMessage()    {}
+
+func (m *JobInfo) GetSteps() []*StepInfo {
+	if m != nil {
+		return m.Steps
+	}
+	return nil
+}
+
+// Request a build step log.
+type GetLogRequest struct {
+	// Job identifier.
+	JobId uint64 `protobuf:"varint,1,opt,name=job_id" json:"job_id,omitempty"`
+	// Build step name.
+	Step string `protobuf:"bytes,2,opt,name=step" json:"step,omitempty"`
+	// Log name.
+	Name string `protobuf:"bytes,3,opt,name=name" json:"name,omitempty"`
+	// Only return the last bytes of the log, when greater than zero.
+	Tail int64 `protobuf:"varint,4,opt,name=tail" json:"tail,omitempty"`
+}
+
+func (m *GetLogRequest) Reset()         { *m = GetLogRequest{} }
+func (m *GetLogRequest) String() string { return proto.CompactTextString(m) }
+func (*GetLogRequest) ProtoMessage()    {}
+
+// Chunk of a log.
+type LogChunk struct {
+	// Data.
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *LogChunk) Reset()         { *m = LogChunk{} }
+func (m *LogChunk) String() string { return proto.CompactTextString(m) }
+func (*LogChunk) ProtoMessage()    {}
+
+// Request a list of jobs.
+type ListJobsRequest struct {
+	// Only jobs with one of these statuses, all when empty.
+	Statuses []EnumJobStatus `protobuf:"varint,1,rep,packed,name=statuses,enum=protocol.EnumJobStatus" json:"statuses,omitempty"`
+	// Regular expression matching the target name.
+	Target string `protobuf:"bytes,2,opt,name=target" json:"target,omitempty"`
+	// Architecture.
+	Architecture string `protobuf:"bytes,3,opt,name=architecture" json:"architecture,omitempty"`
+	// Slave name.
+	Slave string `protobuf:"bytes,4,opt,name=slave" json:"slave,omitempty"`
+	// Only jobs started after this time (nanoseconds since Epoch).
+	StartedAfter int64 `protobuf:"varint,5,opt,name=started_after" json:"started_after,omitempty"`
+	// Only jobs started before this time (nanoseconds since Epoch).
+	StartedBefore int64 `protobuf:"varint,6,opt,name=started_before" json:"started_before,omitempty"`
+	// Maximum number of jobs, all when zero.
+	Limit uint32 `protobuf:"varint,7,opt,name=limit" json:"limit,omitempty"`
+	// Number of jobs to skip.
+	Offset uint32 `protobuf:"varint,8,opt,name=offset" json:"offset,omitempty"`
+	// Only jobs with one of these target types, all when empty.
+	Types []EnumTargetType `protobuf:"varint,9,rep,packed,name=types,enum=protocol.EnumTargetType" json:"types,omitempty"`
+	// Only the most recent job of each target.
+	LastPerTarget bool `protobuf:"varint,10,opt,name=last_per_target" json:"last_per_target,omitempty"`
+}
+
+func (m *ListJobsRequest) Reset()         { *m = ListJobsRequest{} }
+func (m *ListJobsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListJobsRequest) ProtoMessage()    {}
+
+// Request to watch a job.
+type WatchJobRequest struct {
+	// Job identifier.
+	Id uint64 `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *WatchJobRequest) Reset()         { *m = WatchJobRequest{} }
+func (m *WatchJobRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchJobRequest) ProtoMessage()    {}
+
+// Project information.
+type ProjectInfo struct {
+	// Name.
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// Description.
+	Description string `protobuf:"bytes,2,opt,name=description" json:"description,omitempty"`
+	// Additional repositories.
+	Repos string `protobuf:"bytes,3,opt,name=repos" json:"repos,omitempty"`
+	// Chroots.
+	Chroots []string `protobuf:"bytes,4,rep,name=chroots" json:"chroots,omitempty"`
+	// Whether repository metadata is created automatically.
+	AutoCreaterepo bool `protobuf:"varint,5,opt,name=auto_createrepo" json:"auto_createrepo,omitempty"`
+	// Whether network is available during builds.
+	BuildEnableNet bool `protobuf:"varint,6,opt,name=build_enable_net" json:"build_enable_net,omitempty"`
+}
+
+func (m *ProjectInfo) Reset()         { *m = ProjectInfo{} }
+func (m *ProjectInfo) String() string { return proto.CompactTextString(m) }
+func (*ProjectInfo) ProtoMessage()    {}
+
+// Artifact produced by a build.
+type BuildArtifact struct {
+	// File name, relative to the repository directory.
+	FileName string `protobuf:"bytes,1,opt,name=file_name" json:"file_name,omitempty"`
+	// Size in bytes.
+	Size int64 `protobuf:"varint,2,opt,name=size" json:"size,omitempty"`
+	// SHA256 checksum.
+	Sha256 string `protobuf:"bytes,3,opt,name=sha256" json:"sha256,omitempty"`
+}
+
+func (m *BuildArtifact) Reset()         { *m = BuildArtifact{} }
+func (m *BuildArtifact) String() string { return proto.CompactTextString(m) }
+func (*BuildArtifact) ProtoMessage()    {}
+
+// Successful build of a package.
+type BuildInfo struct {
+	// Package name.
+	Package string `protobuf:"bytes,1,opt,name=package" json:"package,omitempty"`
+	// Chroot.
+	Chroot string `protobuf:"bytes,2,opt,name=chroot" json:"chroot,omitempty"`
+	// Name, epoch, version and release.
+	Nevr string `protobuf:"bytes,3,opt,name=nevr" json:"nevr,omitempty"`
+	// Packaging commit hash, kickstart commit hash for images.
+	PackagingCommit string `protobuf:"bytes,4,opt,name=packaging_commit" json:"packaging_commit,omitempty"`
+	// Upstream commit hash.
+	UpstreamCommit string `protobuf:"bytes,5,opt,name=upstream_commit" json:"upstream_commit,omitempty"`
+	// Artifacts.
+	Artifacts []*BuildArtifact `protobuf:"bytes,6,rep,name=artifacts" json:"artifacts,omitempty"`
+	// Job that produced the build.
+	JobId uint64 `protobuf:"varint,7,opt,name=job_id" json:"job_id,omitempty"`
+	// When it has finished (nanoseconds since Epoch).
+	Finished int64 `protobuf:"varint,8,opt,name=finished" json:"finished,omitempty"`
+	// Upstream changes since the previous build.
+	Changes []string `protobuf:"bytes,9,rep,name=changes" json:"changes,omitempty"`
+}
+
+func (m *BuildInfo) Reset()         { *m = BuildInfo{} }
+func (m *BuildInfo) String() string { return proto.CompactTextString(m) }
+func (*BuildInfo) ProtoMessage()    {}
+
+func (m *BuildInfo) GetArtifacts() []*BuildArtifact {
+	if m != nil {
+		return m.Artifacts
+	}
+	return nil
+}
+
+// Request a list of builds.
+type ListBuildsRequest struct {
+	// Package name, all packages when empty.
+	Package string `protobuf:"bytes,1,opt,name=package" json:"package,omitempty"`
+	// Chroot, all chroots when empty.
+	Chroot string `protobuf:"bytes,2,opt,name=chroot" json:"chroot,omitempty"`
+	// Regular expression matching name, epoch, version and release.
+	Nevr string `protobuf:"bytes,3,opt,name=nevr" json:"nevr,omitempty"`
+	// Only builds that produced an artifact with this file name.
+	Artifact string `protobuf:"bytes,4,opt,name=artifact" json:"artifact,omitempty"`
+	// Only builds with a commit hash starting with this.
+	Commit string `protobuf:"bytes,5,opt,name=commit" json:"commit,omitempty"`
+}
+
+func (m *ListBuildsRequest) Reset()         { *m = ListBuildsRequest{} }
+func (m *ListBuildsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListBuildsRequest) ProtoMessage()    {}
+
 func init() {
 	proto.RegisterEnum("protocol.EnumListChroots", EnumListChroots_name, EnumListChroots_value)
 	proto.RegisterEnum("protocol.EnumJobStatus", EnumJobStatus_name, EnumJobStatus_value)
@@ -943,13 +1387,25 @@ type BuilderClient interface {
 	// assign an identifier returned with the reply, along with some information
 	// such as the repositories paths.
 	//
-	// Keep in mind that identifiers are valid until master is restarted.
+	// Slaves reconnecting send the identifier they were given, which is
+	// kept unless another slave is using it, even after a master restart.
 	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*SubscribeResponse, error)
 	// Unregister a slave.
 	//
 	// Slave class this procedure to unregister itself.
 	// Master replies indicating whether the operation succeded or not.
 	Unsubscribe(ctx context.Context, in *UnsubscribeRequest, opts ...grpc.CallOption) (*UnsubscribeResponse, error)
+	// Drain a slave.
+	//
+	// Master stops dispatching jobs to the slave with the name passed as
+	// argument, those already dispatched are allowed to finish.
+	// The slave stays drained until it's resumed, even across reconnections.
+	DrainSlave(ctx context.Context, in *StringMessage, opts ...grpc.CallOption) (*BooleanMessage, error)
+	// Resume a drained slave.
+	//
+	// Master dispatches jobs again to the slave with the name passed
+	// as argument.
+	ResumeSlave(ctx context.Context, in *StringMessage, opts ...grpc.CallOption) (*BooleanMessage, error)
 	// Send a job to the collector.
 	//
 	// Master will enqueue a new job and the dispatcher will find a suitable
@@ -1017,6 +1473,46 @@ type BuilderClient interface {
 	// regular expression passed as argument.
 	// With an empty string the full list of images will be retrieved.
 	ListImages(ctx context.Context, in *StringMessage, opts ...grpc.CallOption) (Builder_ListImagesClient, error)
+	// Get job output.
+	//
+	// Return the output of the commands run by a job so far and, when
+	// follow is set, keep streaming new lines until the job has finished.
+	GetJobOutput(ctx context.Context, in *JobOutputRequest, opts ...grpc.CallOption) (Builder_GetJobOutputClient, error)
+	// Get job.
+	//
+	// Return job information, its build steps, summaries and logs.
+	GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*JobInfo, error)
+	// Get a log.
+	//
+	// Stream the contents of a build step log.
+	GetLog(ctx context.Context, in *GetLogRequest, opts ...grpc.CallOption) (Builder_GetLogClient, error)
+	// List jobs.
+	//
+	// Stream the jobs matching the criteria, most recent first.
+	ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (Builder_ListJobsClient, error)
+	// Watch a job.
+	//
+	// Stream job information every time the job changes,
+	// until it has finished.
+	WatchJob(ctx context.Context, in *WatchJobRequest, opts ...grpc.CallOption) (Builder_WatchJobClient, error)
+	// Add or update a project.
+	//
+	// Store project information.
+	AddProject(ctx context.Context, in *ProjectInfo, opts ...grpc.CallOption) (*BooleanMessage, error)
+	// Remove a project.
+	//
+	// Remove project information.
+	RemoveProject(ctx context.Context, in *StringMessage, opts ...grpc.CallOption) (*BooleanMessage, error)
+	// List projects.
+	//
+	// Return the list of projects and their information, matching the
+	// regular expression passed as argument.
+	ListProjects(ctx context.Context, in *StringMessage, opts ...grpc.CallOption) (Builder_ListProjectsClient, error)
+	// List builds.
+	//
+	// Stream the successful builds of packages matching the criteria,
+	// most recent first.
+	ListBuilds(ctx context.Context, in *ListBuildsRequest, opts ...grpc.CallOption) (Builder_ListBuildsClient, error)
 }
 
 type builderClient struct {
@@ -1045,6 +1541,24 @@ func (c *builderClient) Unsubscribe(ctx context.Context, in *UnsubscribeRequest,
 	return out, nil
 }
 
+func (c *builderClient) DrainSlave(ctx context.Context, in *StringMessage, opts ...grpc.CallOption) (*BooleanMessage, error) {
+	out := new(BooleanMessage)
+	err := grpc.Invoke(ctx, "/protocol.Builder/DrainSlave", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *builderClient) ResumeSlave(ctx context.Context, in *StringMessage, opts ...grpc.CallOption) (*BooleanMessage, error) {
+	out := new(BooleanMessage)
+	err := grpc.Invoke(ctx, "/protocol.Builder/ResumeSlave", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *builderClient) CollectJob(ctx context.Context, in *CollectJobRequest, opts ...grpc.CallOption) (*CollectJobResponse, error) {
 	out := new(CollectJobResponse)
 	err := grpc.Invoke(ctx, "/protocol.Builder/CollectJob", in, out, c.cc, opts...)
@@ -1301,6 +1815,225 @@ func (x *builderListImagesClient) Recv() (*ImageInfo, error) {
 	return m, nil
 }
 
+func (c *builderClient) GetJobOutput(ctx context.Context, in *JobOutputRequest, opts ...grpc.CallOption) (Builder_GetJobOutputClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Builder_serviceDesc.Streams[6], c.cc, "/protocol.Builder/GetJobOutput", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &builderGetJobOutputClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Builder_GetJobOutputClient interface {
+	Recv() (*JobOutput, error)
+	grpc.ClientStream
+}
+
+type builderGetJobOutputClient struct {
+	grpc.ClientStream
+}
+
+func (x *builderGetJobOutputClient) Recv() (*JobOutput, error) {
+	m := new(JobOutput)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *builderClient) GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*JobInfo, error) {
+	out := new(JobInfo)
+	err := grpc.Invoke(ctx, "/protocol.Builder/GetJob", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *builderClient) GetLog(ctx context.Context, in *GetLogRequest, opts ...grpc.CallOption) (Builder_GetLogClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Builder_serviceDesc.Streams[7], c.cc, "/protocol.Builder/GetLog", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &builderGetLogClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Builder_GetLogClient interface {
+	Recv() (*LogChunk, error)
+	grpc.ClientStream
+}
+
+type builderGetLogClient struct {
+	grpc.ClientStream
+}
+
+func (x *builderGetLogClient) Recv() (*LogChunk, error) {
+	m := new(LogChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *builderClient) ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (Builder_ListJobsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Builder_serviceDesc.Streams[8], c.cc, "/protocol.Builder/ListJobs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &builderListJobsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Builder_ListJobsClient interface {
+	Recv() (*JobInfo, error)
+	grpc.ClientStream
+}
+
+type builderListJobsClient struct {
+	grpc.ClientStream
+}
+
+func (x *builderListJobsClient) Recv() (*JobInfo, error) {
+	m := new(JobInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *builderClient) WatchJob(ctx context.Context, in *WatchJobRequest, opts ...grpc.CallOption) (Builder_WatchJobClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Builder_serviceDesc.Streams[9], c.cc, "/protocol.Builder/WatchJob", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &builderWatchJobClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Builder_WatchJobClient interface {
+	Recv() (*JobInfo, error)
+	grpc.ClientStream
+}
+
+type builderWatchJobClient struct {
+	grpc.ClientStream
+}
+
+func (x *builderWatchJobClient) Recv() (*JobInfo, error) {
+	m := new(JobInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *builderClient) AddProject(ctx context.Context, in *ProjectInfo, opts ...grpc.CallOption) (*BooleanMessage, error) {
+	out := new(BooleanMessage)
+	err := grpc.Invoke(ctx, "/protocol.Builder/AddProject", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *builderClient) RemoveProject(ctx context.Context, in *StringMessage, opts ...grpc.CallOption) (*BooleanMessage, error) {
+	out := new(BooleanMessage)
+	err := grpc.Invoke(ctx, "/protocol.Builder/RemoveProject", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *builderClient) ListProjects(ctx context.Context, in *StringMessage, opts ...grpc.CallOption) (Builder_ListProjectsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Builder_serviceDesc.Streams[10], c.cc, "/protocol.Builder/ListProjects", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &builderListProjectsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Builder_ListProjectsClient interface {
+	Recv() (*ProjectInfo, error)
+	grpc.ClientStream
+}
+
+type builderListProjectsClient struct {
+	grpc.ClientStream
+}
+
+func (x *builderListProjectsClient) Recv() (*ProjectInfo, error) {
+	m := new(ProjectInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *builderClient) ListBuilds(ctx context.Context, in *ListBuildsRequest, opts ...grpc.CallOption) (Builder_ListBuildsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Builder_serviceDesc.Streams[11], c.cc, "/protocol.Builder/ListBuilds", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &builderListBuildsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Builder_ListBuildsClient interface {
+	Recv() (*BuildInfo, error)
+	grpc.ClientStream
+}
+
+type builderListBuildsClient struct {
+	grpc.ClientStream
+}
+
+func (x *builderListBuildsClient) Recv() (*BuildInfo, error) {
+	m := new(BuildInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for Builder service
 
 type BuilderServer interface {
@@ -1310,13 +2043,25 @@ type BuilderServer interface {
 	// assign an identifier returned with the reply, along with some information
 	// such as the repositories paths.
 	//
-	// Keep in mind that identifiers are valid until master is restarted.
+	// Slaves reconnecting send the identifier they were given, which is
+	// kept unless another slave is using it, even after a master restart.
 	Subscribe(context.Context, *SubscribeRequest) (*SubscribeResponse, error)
 	// Unregister a slave.
 	//
 	// Slave class this procedure to unregister itself.
 	// Master replies indicating whether the operation succeded or not.
 	Unsubscribe(context.Context, *UnsubscribeRequest) (*UnsubscribeResponse, error)
+	// Drain a slave.
+	//
+	// Master stops dispatching jobs to the slave with the name passed as
+	// argument, those already dispatched are allowed to finish.
+	// The slave stays drained until it's resumed, even across reconnections.
+	DrainSlave(context.Context, *StringMessage) (*BooleanMessage, error)
+	// Resume a drained slave.
+	//
+	// Master dispatches jobs again to the slave with the name passed
+	// as argument.
+	ResumeSlave(context.Context, *StringMessage) (*BooleanMessage, error)
 	// Send a job to the collector.
 	//
 	// Master will enqueue a new job and the dispatcher will find a suitable
@@ -1384,6 +2129,46 @@ type BuilderServer interface {
 	// regular expression passed as argument.
 	// With an empty string the full list of images will be retrieved.
 	ListImages(*StringMessage, Builder_ListImagesServer) error
+	// Get job output.
+	//
+	// Return the output of the commands run by a job so far and, when
+	// follow is set, keep streaming new lines until the job has finished.
+	GetJobOutput(*JobOutputRequest, Builder_GetJobOutputServer) error
+	// Get job.
+	//
+	// Return job information, its build steps, summaries and logs.
+	GetJob(context.Context, *GetJobRequest) (*JobInfo, error)
+	// Get a log.
+	//
+	// Stream the contents of a build step log.
+	GetLog(*GetLogRequest, Builder_GetLogServer) error
+	// List jobs.
+	//
+	// Stream the jobs matching the criteria, most recent first.
+	ListJobs(*ListJobsRequest, Builder_ListJobsServer) error
+	// Watch a job.
+	//
+	// Stream job information every time the job changes,
+	// until it has finished.
+	WatchJob(*WatchJobRequest, Builder_WatchJobServer) error
+	// Add or update a project.
+	//
+	// Store project information.
+	AddProject(context.Context, *ProjectInfo) (*BooleanMessage, error)
+	// Remove a project.
+	//
+	// Remove project information.
+	RemoveProject(context.Context, *StringMessage) (*BooleanMessage, error)
+	// List projects.
+	//
+	// Return the list of projects and their information, matching the
+	// regular expression passed as argument.
+	ListProjects(*StringMessage, Builder_ListProjectsServer) error
+	// List builds.
+	//
+	// Stream the successful builds of packages matching the criteria,
+	// most recent first.
+	ListBuilds(*ListBuildsRequest, Builder_ListBuildsServer) error
 }
 
 func RegisterBuilderServer(s *grpc.Server, srv BuilderServer) {
@@ -1414,6 +2199,30 @@ func _Builder_Unsubscribe_Handler(srv interface{}, ctx context.Context, dec func
 	return out, nil
 }
 
+func _Builder_DrainSlave_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(StringMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(BuilderServer).DrainSlave(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Builder_ResumeSlave_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(StringMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(BuilderServer).ResumeSlave(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func _Builder_CollectJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 	in := new(CollectJobRequest)
 	if err := dec(in); err != nil {
@@ -1634,6 +2443,168 @@ func (x *builderListImagesServer) Send(m *ImageInfo) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Builder_GetJobOutput_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(JobOutputRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BuilderServer).GetJobOutput(m, &builderGetJobOutputServer{stream})
+}
+
+type Builder_GetJobOutputServer interface {
+	Send(*JobOutput) error
+	grpc.ServerStream
+}
+
+type builderGetJobOutputServer struct {
+	grpc.ServerStream
+}
+
+func (x *builderGetJobOutputServer) Send(m *JobOutput) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Builder_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(GetJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(BuilderServer).GetJob(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Builder_GetLog_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetLogRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BuilderServer).GetLog(m, &builderGetLogServer{stream})
+}
+
+type Builder_GetLogServer interface {
+	Send(*LogChunk) error
+	grpc.ServerStream
+}
+
+type builderGetLogServer struct {
+	grpc.ServerStream
+}
+
+func (x *builderGetLogServer) Send(m *LogChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Builder_ListJobs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListJobsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BuilderServer).ListJobs(m, &builderListJobsServer{stream})
+}
+
+type Builder_ListJobsServer interface {
+	Send(*JobInfo) error
+	grpc.ServerStream
+}
+
+type builderListJobsServer struct {
+	grpc.ServerStream
+}
+
+func (x *builderListJobsServer) Send(m *JobInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Builder_WatchJob_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchJobRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BuilderServer).WatchJob(m, &builderWatchJobServer{stream})
+}
+
+type Builder_WatchJobServer interface {
+	Send(*JobInfo) error
+	grpc.ServerStream
+}
+
+type builderWatchJobServer struct {
+	grpc.ServerStream
+}
+
+func (x *builderWatchJobServer) Send(m *JobInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Builder_AddProject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ProjectInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(BuilderServer).AddProject(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Builder_RemoveProject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(StringMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(BuilderServer).RemoveProject(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Builder_ListProjects_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StringMessage)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BuilderServer).ListProjects(m, &builderListProjectsServer{stream})
+}
+
+type Builder_ListProjectsServer interface {
+	Send(*ProjectInfo) error
+	grpc.ServerStream
+}
+
+type builderListProjectsServer struct {
+	grpc.ServerStream
+}
+
+func (x *builderListProjectsServer) Send(m *ProjectInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Builder_ListBuilds_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListBuildsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BuilderServer).ListBuilds(m, &builderListBuildsServer{stream})
+}
+
+type Builder_ListBuildsServer interface {
+	Send(*BuildInfo) error
+	grpc.ServerStream
+}
+
+type builderListBuildsServer struct {
+	grpc.ServerStream
+}
+
+func (x *builderListBuildsServer) Send(m *BuildInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _Builder_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protocol.Builder",
 	HandlerType: (*BuilderServer)(nil),
@@ -1646,6 +2617,14 @@ var _Builder_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Unsubscribe",
 			Handler:    _Builder_Unsubscribe_Handler,
 		},
+		{
+			MethodName: "DrainSlave",
+			Handler:    _Builder_DrainSlave_Handler,
+		},
+		{
+			MethodName: "ResumeSlave",
+			Handler:    _Builder_ResumeSlave_Handler,
+		},
 		{
 			MethodName: "CollectJob",
 			Handler:    _Builder_CollectJob_Handler,
@@ -1674,6 +2653,18 @@ var _Builder_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RemoveImage",
 			Handler:    _Builder_RemoveImage_Handler,
 		},
+		{
+			MethodName: "GetJob",
+			Handler:    _Builder_GetJob_Handler,
+		},
+		{
+			MethodName: "AddProject",
+			Handler:    _Builder_AddProject_Handler,
+		},
+		{
+			MethodName: "RemoveProject",
+			Handler:    _Builder_RemoveProject_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -1707,5 +2698,35 @@ var _Builder_serviceDesc = grpc.ServiceDesc{
 			Handler:       _Builder_ListImages_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GetJobOutput",
+			Handler:       _Builder_GetJobOutput_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetLog",
+			Handler:       _Builder_GetLog_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListJobs",
+			Handler:       _Builder_ListJobs_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchJob",
+			Handler:       _Builder_WatchJob_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListProjects",
+			Handler:       _Builder_ListProjects_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListBuilds",
+			Handler:       _Builder_ListBuilds_Handler,
+			ServerStreams: true,
+		},
 	},
 }
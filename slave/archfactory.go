@@ -0,0 +1,194 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hawaii-desktop/builder"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNoChrootDir         = errors.New("Arch Linux chroot directory is not configured")
+	ErrUnsupportedArch     = errors.New("architecture not supported by Arch Linux")
+	ErrInvalidPkgbuildInfo = errors.New("unable to read PKGBUILD information")
+)
+
+// Maps builder architectures to Arch Linux architectures
+// and to the clean chroot suffix used by makechrootpkg.
+var pacmanArchMap = map[string][]string{
+	"i386":   {"i686", "32"},
+	"x86_64": {"x86_64", "64"},
+}
+
+func init() {
+	RegisterFactory("package", "archlinux", NewArchFactory)
+}
+
+func NewArchFactory(j *Job) *Factory {
+	f := NewFactory(j)
+
+	// Fetch the packaging repository
+	f.AddBuildStep(&BuildStep{
+		Name:      "git packaging",
+		KeepGoing: false,
+		Run:       archFactoryGitFetch,
+	})
+
+	// Read version and dependencies from the PKGBUILD
+	f.AddBuildStep(&BuildStep{
+		Name:      "pkgbuild info",
+		KeepGoing: false,
+		Run:       archFactoryPkgbuildInfo,
+	})
+
+	// Build in a clean chroot
+	f.AddBuildStep(&BuildStep{
+		Name:      "makechrootpkg",
+		KeepGoing: false,
+		Run:       archFactoryMakeChrootPkg,
+	})
+
+	return f
+}
+
+func archFactoryGitFetch(bs *BuildStep) error {
+	pkg := bs.parent.job.Info.Package
+	_, err := bs.parent.Download(pkg.VcsUrl, pkg.VcsBranch, pkg.VcsRevision, bs.parent.workdir, "packaging")
+	return err
+}
+
+func archFactoryPkgbuildInfo(bs *BuildStep) error {
+	// Change directory
+	cwd := path.Join(bs.parent.workdir, "packaging")
+	os.Chdir(cwd)
+
+	// Read the information with the helpers/archlinux scripts
+	version, err := archFactoryRunHelper(bs, "pkgversion")
+	if err != nil {
+		return err
+	}
+	if version == "" || version == "?" {
+		return ErrInvalidPkgbuildInfo
+	}
+	depends, err := archFactoryRunHelper(bs, "pkgdepends")
+	if err != nil {
+		return err
+	}
+	provides, err := archFactoryRunHelper(bs, "pkgprovides")
+	if err != nil {
+		return err
+	}
+
+	bs.parent.properties["Version"] = version
+	bs.parent.job.nevr = bs.parent.job.Target + "-" + version
+	bs.parent.properties["Depends"] = strings.Fields(depends)
+	bs.parent.properties["Provides"] = strings.Fields(provides)
+
+	// Add a summary
+	bs.AddSummary("Version", version)
+	if depends != "" {
+		bs.AddSummary("Depends", strings.Join(strings.Fields(depends), "\n"))
+	}
+	if provides != "" {
+		bs.AddSummary("Provides", strings.Join(strings.Fields(provides), "\n"))
+	}
+
+	return nil
+}
+
+// Run a PKGBUILD helper script and return its trimmed output.
+func archFactoryRunHelper(bs *BuildStep, helper string) (string, error) {
+	cmd := exec.Command(helper, "./PKGBUILD")
+	output, err := bs.parent.RunCombinedWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_PREPARE))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func archFactoryMakeChrootPkg(bs *BuildStep) error {
+	// Determine the chroot
+	if Config.Archlinux.ChrootDir == "" {
+		return ErrNoChrootDir
+	}
+	arch, ok := pacmanArchMap[bs.parent.job.Architecture]
+	if !ok {
+		return ErrUnsupportedArch
+	}
+	chrootdir := Config.Archlinux.ChrootDir + arch[1]
+
+	// Change directory
+	cwd := path.Join(bs.parent.workdir, "packaging")
+	os.Chdir(cwd)
+
+	// Remove packages from previous builds
+	files, err := filepath.Glob("*.pkg.tar*")
+	if err == nil {
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}
+
+	// Update the chroot and build the package in a clean copy
+	cmd := exec.Command("sudo", "makechrootpkg", "-c", "-u", "-r", chrootdir)
+	if err := bs.parent.RunWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_BUILD)); err != nil {
+		return err
+	}
+
+	// Collect the artifacts
+	files, err = filepath.Glob("*.pkg.tar*")
+	if err != nil {
+		return fmt.Errorf("Unable to collect artifacts: %s\n", err)
+	}
+	for _, file := range files {
+		if !builder.PacmanPackageRegexp.MatchString(file) {
+			continue
+		}
+
+		fullpath, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("Failed to determine absolute path of \"%s\": %s\n", file, err)
+		}
+
+		// Architecture independent packages are published
+		// in the repository of the architecture being built
+		bs.parent.job.artifacts = append(bs.parent.job.artifacts, &Artifact{
+			FileName:     fullpath,
+			BaseArch:     arch[0],
+			Distribution: "archlinux",
+			Permission:   0644,
+		})
+	}
+
+	return nil
+}
@@ -30,7 +30,8 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/hawaii-desktop/builder/logging"
-	"io/ioutil"
+	"github.com/hawaii-desktop/builder/utils"
+	"io"
 	"os"
 	"os/exec"
 	"path"
@@ -43,7 +44,6 @@ import (
 // VCS
 var (
 	lsRemoteTimeout = 5 * time.Minute
-	cloneTimeout    = 10 * time.Minute
 	fetchTimeout    = 5 * time.Minute
 	checkoutTimeout = 1 * time.Minute
 	UrlRegExp       = regexp.MustCompile(`^(?P<scheme>\w+://)*(?P<user>.+@)*(?P<host>[\w\d\.]+)(?P<port>:[\d]+){0,1}/*(?P<path>(?P<dir>[\w.]+)/*(?P<repo>[\w.]+))$`)
@@ -63,8 +63,27 @@ type Factory struct {
 	sMutex sync.Mutex
 	// Output.
 	buffer *bytes.Buffer
+	// Build step being run.
+	current *BuildStep
+	// When the job must be finished, zero means no deadline.
+	deadline time.Time
+	// Whether the last build step timed out.
+	timedOut bool
+	// Called after a command was killed.
+	cleanup FactoryCleanupFunc
+	// Resource limits of the job.
+	limits ResourceLimits
+	// Cgroup of the job, nil when commands run without limits.
+	cgroup *cgroup
+	// Cgroup of the build step being run.
+	stepCgroup *cgroup
+	// Keeps the working directory from being removed.
+	workdirLock *os.File
 }
 
+// Clean up what a killed command left behind.
+type FactoryCleanupFunc func(f *Factory) error
+
 // Build step running function.
 type BuildStepRunFunc func(bs *BuildStep) error
 
@@ -87,90 +106,114 @@ type BuildStep struct {
 	finished time.Time
 	// Logs.
 	logs map[string][]byte
+	// Peak memory usage in bytes.
+	peakMemory uint64
+	// CPU time used.
+	cpuTime time.Duration
 }
 
 // Create a new factory.
 func NewFactory(j *Job) *Factory {
-	// Create working directory
+	// The working directory is created by useWorkspace()
 	workdir := path.Join(Config.Directory.WorkDir, j.Type.String(), j.Architecture, j.Target)
-	os.MkdirAll(workdir, 0755)
 
 	// Factory
 	var buffer bytes.Buffer
 	return &Factory{
-		job:        j,
-		workdir:    workdir,
-		steps:      make([]*BuildStep, 0),
-		properties: make(VariantMap),
-		buffer:     &buffer,
+		job:         j,
+		workdir:     workdir,
+		steps:       make([]*BuildStep, 0),
+		properties:  make(VariantMap),
+		buffer:      &buffer,
+		workdirLock: useWorkspace(workdir),
 	}
 }
 
 // Run a command without timeout.
 func (f *Factory) RunCommand(cmd *exec.Cmd) error {
-	cwd, _ := os.Getwd()
-
-	if len(cmd.Env) > 0 {
-		fmt.Fprintf(f.buffer, "Environment:\n")
-		for _, e := range cmd.Env {
-			fmt.Fprintf(f.buffer, "\t%s\n", e)
-		}
-	}
-	fmt.Fprintf(f.buffer, "Running: %s\n", strings.Join(cmd.Args, " "))
-	fmt.Fprintf(f.buffer, "Argv: %q\n", cmd.Args)
-	fmt.Fprintf(f.buffer, "From: %s\n", cwd)
-
-	output, err := cmd.CombinedOutput()
-	fmt.Fprintf(f.buffer, string(output))
-
+	_, err := f.runCommand(cmd, 0)
 	return err
 }
 
 // Run a command without timeout and return the combined output.
 func (f *Factory) RunCommandCombined(cmd *exec.Cmd) ([]byte, error) {
-	cwd, _ := os.Getwd()
-
-	if len(cmd.Env) > 0 {
-		fmt.Fprintf(f.buffer, "Environment:\n")
-		for _, e := range cmd.Env {
-			fmt.Fprintf(f.buffer, "\t%s\n", e)
-		}
-	}
-	fmt.Fprintf(f.buffer, "Running: %s\n", strings.Join(cmd.Args, " "))
-	fmt.Fprintf(f.buffer, "Argv: %q\n", cmd.Args)
-	fmt.Fprintf(f.buffer, "From: %s\n", cwd)
-
-	output, err := cmd.CombinedOutput()
-	fmt.Fprintf(f.buffer, string(output))
-
-	return output, err
+	return f.runCommand(cmd, 0)
 }
 
 // Run a command with timeout.
 func (f *Factory) RunWithTimeout(cmd *exec.Cmd, timeout time.Duration) error {
-	cwd, _ := os.Getwd()
+	_, err := f.runCommand(cmd, timeout)
+	return err
+}
 
-	if len(cmd.Env) > 0 {
-		fmt.Fprintf(f.buffer, "Environment:\n")
-		for _, e := range cmd.Env {
-			fmt.Fprintf(f.buffer, "\t%s\n", e)
+// Run a command with timeout and return the combined output.
+func (f *Factory) RunCombinedWithTimeout(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	return f.runCommand(cmd, timeout)
+}
+
+// Run a command, killing it after timeout unless it's zero, and
+// return the combined output.
+// Commands never run past the job deadline and are killed when
+// the job is cancelled, the factory cleanup runs afterwards.
+// Output is also collected for the step logs and sent to the
+// master line by line while the command is running.
+func (f *Factory) runCommand(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	if f.job.ctx.Err() != nil {
+		return nil, ErrJobCancelled
+	}
+
+	if !f.deadline.IsZero() {
+		remaining := f.deadline.Sub(time.Now())
+		if remaining <= 0 {
+			f.timedOut = true
+			return nil, ErrJobTimedOut
+		}
+		if timeout == 0 || remaining < timeout {
+			timeout = remaining
 		}
 	}
-	fmt.Fprintf(f.buffer, "Running: %s\n", strings.Join(cmd.Args, " "))
-	fmt.Fprintf(f.buffer, "Argv: %q\n", cmd.Args)
-	fmt.Fprintf(f.buffer, "From: %s\n", cwd)
 
-	t := time.AfterFunc(timeout, func() { cmd.Process.Kill() })
-	defer t.Stop()
+	// Commands run in the cgroup of the step, processes killed
+	// before are not counted
+	var oomKills uint64
+	if f.cgroup != nil {
+		oomKills = f.cgroup.oomKills()
+	}
 
-	output, err := cmd.CombinedOutput()
-	fmt.Fprintf(f.buffer, string(output))
+	runningCommands.Add(1)
+	defer runningCommands.Done()
 
-	return err
+	output, killed, err := f.execCommand(cmd, timeout, f.job.ctx.Done(), f.stepCgroup)
+	if killed {
+		if err == ErrCommandTimedOut {
+			f.timedOut = true
+		}
+		f.runCleanup()
+	}
+	if f.cgroup != nil && f.cgroup.oomKills() > oomKills {
+		err = f.outOfMemory()
+	}
+	return output, err
 }
 
-// Run a command with timeout and return the combined output.
-func (f *Factory) RunCombinedWithTimeout(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+// Report that the job ran out of memory.
+func (f *Factory) outOfMemory() error {
+	reason := ErrOutOfMemory.Error()
+	if f.limits.Memory > 0 {
+		reason = fmt.Sprintf("%s, the limit is %s", reason, utils.FormatSize(f.limits.Memory))
+	}
+	fmt.Fprintf(f.buffer, "Killed: %s\n", reason)
+	if f.current != nil {
+		f.current.AddSummary("Failure", reason)
+	}
+	return fmt.Errorf("%s", reason)
+}
+
+// Run a command in its own process group, the whole group is
+// terminated after timeout unless it's zero or when cancel is closed.
+// The command is moved to cg before it runs, unless it's nil.
+// Return the combined output and whether it was killed.
+func (f *Factory) execCommand(cmd *exec.Cmd, timeout time.Duration, cancel <-chan struct{}, cg *cgroup) ([]byte, bool, error) {
 	cwd, _ := os.Getwd()
 
 	if len(cmd.Env) > 0 {
@@ -183,53 +226,81 @@ func (f *Factory) RunCombinedWithTimeout(cmd *exec.Cmd, timeout time.Duration) (
 	fmt.Fprintf(f.buffer, "Argv: %q\n", cmd.Args)
 	fmt.Fprintf(f.buffer, "From: %s\n", cwd)
 
-	t := time.AfterFunc(timeout, func() { cmd.Process.Kill() })
-	defer t.Stop()
-
-	output, err := cmd.CombinedOutput()
-	fmt.Fprintf(f.buffer, string(output))
-
-	return output, err
-}
-
-// Clone or pull a git repository.
-func (f *Factory) DownloadGit(url, tag, parentdir, clonedirname string) error {
-	// Clone if the clone directory doesn't exist otherwise pull
-	if _, err := ioutil.ReadFile(path.Join(parentdir, clonedirname, ".git", "HEAD")); err != nil {
-		// Clone repository
-		os.Chdir(parentdir)
-		cmd := exec.Command("git", "clone", url, clonedirname)
-		if err := f.RunWithTimeout(cmd, cloneTimeout); err != nil {
-			return err
-		}
+	var output bytes.Buffer
+	writer := newOutputWriter(f)
+	defer writer.Flush()
+	cmd.Stdout = io.MultiWriter(&output, f.buffer, writer)
+	cmd.Stderr = cmd.Stdout
+	setProcessGroup(cmd)
+	if cg != nil {
+		cg.wrapCommand(cmd)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, false, err
 	}
 
-	// Enter the clone directory
-	os.Chdir(path.Join(parentdir, clonedirname))
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
 
-	// Fetch from origin
-	cmd := exec.Command("git", "fetch", "origin")
-	if err := f.RunWithTimeout(cmd, cloneTimeout); err != nil {
-		return err
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
 	}
 
-	// Checkout tag or branch
-	cmd = exec.Command("git", "checkout", tag)
-	if err := f.RunWithTimeout(cmd, cloneTimeout); err != nil {
-		return err
+	select {
+	case err := <-done:
+		return output.Bytes(), false, err
+	case <-expired:
+		fmt.Fprintf(f.buffer, "Timed out after %v, terminating\n", timeout)
+		terminateProcessGroup(cmd, done)
+		return output.Bytes(), true, ErrCommandTimedOut
+	case <-cancel:
+		fmt.Fprintf(f.buffer, "Job cancelled, terminating\n")
+		terminateProcessGroup(cmd, done)
+		return output.Bytes(), true, ErrJobCancelled
 	}
+}
 
-	// Pull from an existing clone
-	cmd = exec.Command("git", "pull")
-	if err := f.RunWithTimeout(cmd, cloneTimeout); err != nil {
-		return err
+// Set the function that cleans up after a command was killed.
+func (f *Factory) SetCleanup(fn FactoryCleanupFunc) {
+	f.cleanup = fn
+}
+
+// Run the cleanup function, if any.
+func (f *Factory) runCleanup() {
+	if f.cleanup == nil {
+		return
+	}
+	fmt.Fprintf(f.buffer, "Cleaning up\n")
+	if err := f.cleanup(f); err != nil {
+		logging.Errorf("Cleanup of job #%d failed: %s\n", f.job.Id, err)
 	}
+}
 
-	return nil
+// Run a command from the cleanup function.
+// These commands ignore the job deadline and cancellation,
+// but they have a timeout too.
+func (f *Factory) RunCleanupCommand(cmd *exec.Cmd) error {
+	_, _, err := f.execCommand(cmd, cleanupTimeout, nil, nil)
+	return err
 }
 
 // Close the factory.
 func (f *Factory) Close() {
+	if f.cgroup != nil {
+		if err := f.cgroup.remove(); err != nil {
+			logging.Errorf("Unable to remove the cgroup of job #%d: %s\n", f.job.Id, err)
+		}
+		f.cgroup = nil
+	}
+	if f.workdirLock != nil {
+		unlockDirectory(f.workdirLock)
+		f.workdirLock = nil
+	}
 }
 
 // Append the build step.
@@ -250,7 +321,37 @@ func (f *Factory) Run() bool {
 	f.sMutex.Lock()
 	defer f.sMutex.Unlock()
 
-	for _, bs := range f.steps {
+	// The whole job must finish in time
+	if timeout := f.jobTimeout(); timeout > 0 {
+		f.deadline = time.Now().Add(timeout)
+	}
+
+	// Commands run within the resource limits
+	f.limits = jobLimits(f.job)
+	if c, err := newJobCgroup(f.job, f.limits); err != nil {
+		logging.Errorf("Unable to create the cgroup of job #%d, running without limits: %s\n", f.job.Id, err)
+	} else {
+		f.cgroup = c
+	}
+
+	// The running function of a step may replace the steps that
+	// follow it, so iterate by index and read the length again
+	// after each step, see ReplaceNextSteps()
+	for i := 0; i < len(f.steps); i++ {
+		bs := f.steps[i]
+
+		// Do not start steps past the deadline or after cancellation
+		f.timedOut = false
+		if !f.deadline.IsZero() && time.Now().After(f.deadline) {
+			logging.Errorf("<= Build step \"%s\" not started: %s\n", bs.Name, ErrJobTimedOut)
+			f.timedOut = true
+			return false
+		}
+		if f.job.ctx.Err() != nil {
+			logging.Errorf("<= Build step \"%s\" not started: %s\n", bs.Name, ErrJobCancelled)
+			return false
+		}
+
 		// Start measuring time
 		start := time.Now()
 
@@ -260,12 +361,15 @@ func (f *Factory) Run() bool {
 		// Send the update and run the step
 		logging.Infof("=> Running build step \"%s\"\n", bs.Name)
 		bs.started = start
+		f.current = bs
+		f.startStepCgroup(i)
 		f.job.stepUpdateQueue <- bs
 		err := bs.Run(bs)
 
 		// Elapsed time
 		elapsed := time.Since(start)
 		bs.finished = start.Add(elapsed)
+		f.finishStepCgroup(bs)
 
 		// Collect output and send the update
 		bs.logs["stdio"] = f.buffer.Bytes()
@@ -286,6 +390,60 @@ func (f *Factory) Run() bool {
 	return true
 }
 
+// Create the cgroup of a build step.
+// Processes cannot be moved into the job cgroup since it has
+// children, when the step cgroup cannot be created commands
+// of the step run without limits.
+func (f *Factory) startStepCgroup(index int) {
+	f.stepCgroup = nil
+	if f.cgroup == nil {
+		return
+	}
+	c, err := f.cgroup.child(fmt.Sprintf("step-%d", index))
+	if err != nil {
+		logging.Errorf("Unable to create the cgroup of step #%d: %s\n", index, err)
+		logging.Warningf("Step #%d of job #%d runs without resource limits\n", index, f.job.Id)
+		return
+	}
+	f.stepCgroup = c
+}
+
+// Collect the resource usage of a build step and remove its cgroup.
+func (f *Factory) finishStepCgroup(bs *BuildStep) {
+	if f.stepCgroup == nil {
+		return
+	}
+	bs.peakMemory = f.stepCgroup.peakMemory()
+	bs.cpuTime = f.stepCgroup.cpuTime()
+	if err := f.stepCgroup.remove(); err != nil {
+		logging.Errorf("Unable to remove the cgroup of build step \"%s\": %s\n", bs.Name, err)
+	}
+	f.stepCgroup = nil
+}
+
+// Replace all the build steps that follow bs with steps.
+// This can only be called by the running function of bs: Run() looks
+// up the next step by index after each one, so bs and the steps before
+// it keep their positions and the new steps run after bs.
+// A new slice is built, the previous one is never modified.
+func (bs *BuildStep) ReplaceNextSteps(steps []*BuildStep) {
+	f := bs.parent
+	next := make([]*BuildStep, 0, len(f.steps)+len(steps))
+	for _, step := range f.steps {
+		next = append(next, step)
+		if step == bs {
+			break
+		}
+	}
+	for _, step := range steps {
+		step.parent = f
+		step.summary = make(map[string][]string)
+		step.logs = make(map[string][]byte)
+		next = append(next, step)
+	}
+	f.steps = next
+}
+
 // Add a summary entry that will be shown by the user interface.
 func (bs *BuildStep) AddSummary(label string, value string) {
 	bs.summary[label] = append(bs.summary[label], value)
@@ -0,0 +1,288 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/hawaii-desktop/builder/logging"
+	"github.com/hawaii-desktop/builder/utils"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrOutOfMemory  = errors.New("out of memory")
+	ErrNoCgroupV2   = errors.New("not a cgroup v2 hierarchy")
+	ErrInvalidLimit = errors.New("invalid limit")
+)
+
+// Controllers enabled for the job cgroups.
+var cgroupControllers = []string{"memory", "cpu", "pids"}
+
+// Period of the CPU bandwidth limit in microseconds.
+const cpuPeriod = 100000
+
+// Resource limits of a job, zero means unlimited.
+type ResourceLimits struct {
+	// Memory in bytes.
+	Memory uint64
+	// CPU in thousandths of a CPU.
+	Cpu uint32
+	// Maximum number of processes.
+	Pids uint32
+}
+
+// Parse the limits of the configuration.
+func configuredLimits() (ResourceLimits, error) {
+	var limits ResourceLimits
+	if Config.Limits.Memory != "" {
+		value, err := utils.ParseSize(Config.Limits.Memory)
+		if err != nil {
+			return limits, err
+		}
+		limits.Memory = value
+	}
+	if Config.Limits.Cpu != "" {
+		value, err := strconv.ParseFloat(Config.Limits.Cpu, 64)
+		if err != nil || value < 0 {
+			return limits, fmt.Errorf("%s: CPU \"%s\"", ErrInvalidLimit, Config.Limits.Cpu)
+		}
+		limits.Cpu = uint32(value * 1000)
+	}
+	if Config.Limits.Pids < 0 {
+		return limits, fmt.Errorf("%s: pids %d", ErrInvalidLimit, Config.Limits.Pids)
+	}
+	limits.Pids = uint32(Config.Limits.Pids)
+	return limits, nil
+}
+
+// Check the limits of the configuration.
+func CheckLimits() error {
+	_, err := configuredLimits()
+	return err
+}
+
+// Return the limits of a job: packages may override the
+// slave configuration.
+func jobLimits(j *Job) ResourceLimits {
+	limits, _ := configuredLimits()
+	if pkg := j.Info.Package; pkg != nil {
+		if pkg.MemoryLimit > 0 {
+			limits.Memory = pkg.MemoryLimit
+		}
+		if pkg.CpuLimit > 0 {
+			limits.Cpu = pkg.CpuLimit
+		}
+		if pkg.PidsLimit > 0 {
+			limits.Pids = pkg.PidsLimit
+		}
+	}
+	return limits
+}
+
+// Prepare the cgroup configured for the jobs.
+// The slave moves itself to a child cgroup when it lives in the
+// configured one, because processes are only allowed in leaves.
+// Do nothing when no cgroup is configured.
+func SetupCgroup() error {
+	base := Config.Limits.CgroupDir
+	if base == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(base, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("%s: %s", base, ErrNoCgroupV2)
+	}
+
+	procs, err := ioutil.ReadFile(filepath.Join(base, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	if len(strings.TrimSpace(string(procs))) > 0 {
+		leaf := &cgroup{filepath.Join(base, "slave")}
+		if err := os.MkdirAll(leaf.path, 0755); err != nil {
+			return err
+		}
+		for _, pid := range strings.Fields(string(procs)) {
+			if err := leaf.write("cgroup.procs", pid); err != nil {
+				return err
+			}
+		}
+	}
+
+	(&cgroup{base}).enableControllers()
+	return nil
+}
+
+// A cgroup v2 directory.
+type cgroup struct {
+	path string
+}
+
+// Write a value to a cgroup file.
+func (c *cgroup) write(name string, value string) error {
+	return ioutil.WriteFile(filepath.Join(c.path, name), []byte(value), 0644)
+}
+
+// Return the value of a single value cgroup file and
+// whether the file exists.
+func (c *cgroup) readValue(name string) (uint64, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(c.path, name))
+	if err != nil {
+		return 0, false
+	}
+	value, _ := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return value, true
+}
+
+// Return the value of key from a flat keyed cgroup file.
+func (c *cgroup) readKey(name string, key string) uint64 {
+	file, err := os.Open(filepath.Join(c.path, name))
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			value, _ := strconv.ParseUint(fields[1], 10, 64)
+			return value
+		}
+	}
+	return 0
+}
+
+// Enable controllers for the children, one at a time
+// because some of them may not be available.
+func (c *cgroup) enableControllers() {
+	for _, name := range cgroupControllers {
+		if err := c.write("cgroup.subtree_control", "+"+name); err != nil {
+			logging.Warningf("Unable to enable the %s controller for %s: %s\n", name, c.path, err)
+		}
+	}
+}
+
+// Create a child cgroup.
+func (c *cgroup) child(name string) (*cgroup, error) {
+	child := &cgroup{filepath.Join(c.path, name)}
+	if err := os.Mkdir(child.path, 0755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	return child, nil
+}
+
+// Kill the processes left in the cgroup and remove it.
+func (c *cgroup) remove() error {
+	if c.readKey("cgroup.events", "populated") != 0 {
+		c.write("cgroup.kill", "1")
+		for i := 0; i < 50 && c.readKey("cgroup.events", "populated") != 0; i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	return os.Remove(c.path)
+}
+
+// Create the cgroup of a job and apply the limits.
+// Return nil when no cgroup is configured.
+func newJobCgroup(j *Job, limits ResourceLimits) (*cgroup, error) {
+	if Config.Limits.CgroupDir == "" {
+		return nil, nil
+	}
+
+	base := &cgroup{Config.Limits.CgroupDir}
+	c, err := base.child(fmt.Sprintf("job-%d", j.Id))
+	if err != nil {
+		return nil, err
+	}
+
+	if limits.Memory > 0 {
+		if err := c.write("memory.max", strconv.FormatUint(limits.Memory, 10)); err != nil {
+			c.remove()
+			return nil, err
+		}
+		// Swap would only make builds slower before failing,
+		// and the whole build goes when it runs out of memory
+		c.write("memory.swap.max", "0")
+		c.write("memory.oom.group", "1")
+	}
+	if limits.Cpu > 0 {
+		quota := uint64(limits.Cpu) * cpuPeriod / 1000
+		if err := c.write("cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)); err != nil {
+			c.remove()
+			return nil, err
+		}
+	}
+	if limits.Pids > 0 {
+		if err := c.write("pids.max", strconv.FormatUint(uint64(limits.Pids), 10)); err != nil {
+			c.remove()
+			return nil, err
+		}
+	}
+
+	// Each step has its own cgroup for accounting
+	c.enableControllers()
+	return c, nil
+}
+
+// Return how many processes of the job were killed because
+// it ran out of memory.
+func (c *cgroup) oomKills() uint64 {
+	return c.readKey("memory.events", "oom_kill")
+}
+
+// Return the peak memory usage in bytes, zero when unknown
+// such as with kernels older than 5.19 that don't have memory.peak.
+func (c *cgroup) peakMemory() uint64 {
+	value, _ := c.readValue("memory.peak")
+	return value
+}
+
+// Make the command move itself to the cgroup before running.
+// A shell writes its process identifier to cgroup.procs and then
+// replaces itself with the command, this way children can't be
+// forked outside of the cgroup and it works with any kernel and
+// Go version, unlike starting the process into the cgroup.
+func (c *cgroup) wrapCommand(cmd *exec.Cmd) {
+	args := []string{"sh", "-c", `echo $$ > "$0" && exec "$@"`,
+		filepath.Join(c.path, "cgroup.procs"), cmd.Path}
+	cmd.Args = append(args, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+}
+
+// Return the CPU time used.
+func (c *cgroup) cpuTime() time.Duration {
+	return time.Duration(c.readKey("cpu.stat", "usage_usec")) * time.Microsecond
+}
@@ -46,10 +46,42 @@ import (
 	"time"
 )
 
+// How long to wait for the master to acknowledge that we are
+// draining, it never comes while disconnected.
+const drainingAckTimeout = 30 * time.Second
+
 // Store important data used during the life time of the slave.
 type Client struct {
-	// RPC proxy.
+	// Connection to the master, nil when disconnected.
+	conn *grpc.ClientConn
+	// RPC proxy, nil when disconnected.
 	client pb.BuilderClient
+	// Stream used to pick up jobs, nil when disconnected.
+	stream pb.Builder_PickJobClient
+	// Closed when the slave is connected and subscribed.
+	connected chan struct{}
+	// Protects the connection, the stream and pending jobs.
+	cMutex sync.Mutex
+	// Slave data, the identifier is kept across reconnections.
+	data *SlaveData
+	// Jobs that finished while disconnected.
+	pending map[uint64]*Job
+	// Last readiness sent to the master.
+	ready  bool
+	reason string
+	// Whether new jobs should no longer be dispatched to us.
+	draining bool
+	// Closed when the master acknowledged that we are draining,
+	// recreated every time draining starts.
+	drainingAck chan struct{}
+	// Protects readiness, draining and its acknowledgement.
+	rMutex sync.Mutex
+	// Number of jobs being processed.
+	running int
+	// Signaled when a job was processed.
+	runningCond *sync.Cond
+	// Protects the number of jobs being processed.
+	jMutex sync.Mutex
 	// Channel for job processing.
 	jobQueue chan *Job
 	// Channel used to synchronize all goroutines.
@@ -64,14 +96,19 @@ var jobStatusMap = map[builder.JobStatus]pb.EnumJobStatus{
 	builder.JOB_STATUS_SUCCESSFUL:   pb.EnumJobStatus_JOB_STATUS_SUCCESSFUL,
 	builder.JOB_STATUS_FAILED:       pb.EnumJobStatus_JOB_STATUS_FAILED,
 	builder.JOB_STATUS_CRASHED:      pb.EnumJobStatus_JOB_STATUS_CRASHED,
+	builder.JOB_STATUS_TIMED_OUT:    pb.EnumJobStatus_JOB_STATUS_TIMED_OUT,
 }
 
-// Create a new Client from a gRPC connection.
-func NewClient(conn *grpc.ClientConn) *Client {
-	// Create a RPC proxy and a queue for (NCPU/2)+1 jobs to be
-	// processed at the same time
+// Create a new Client, see Serve() to connect to the master.
+func NewClient() *Client {
+	// Create a queue for (NCPU/2)+1 jobs to be processed
+	// at the same time
 	c := &Client{}
-	c.client = pb.NewBuilderClient(conn)
+	c.connected = make(chan struct{})
+	c.data = &SlaveData{}
+	c.pending = make(map[uint64]*Job)
+	c.drainingAck = make(chan struct{})
+	c.runningCond = sync.NewCond(&c.jMutex)
 	c.jobQueue = make(chan *Job, (runtime.NumCPU()/2)+1)
 	c.quit = make(chan bool)
 
@@ -95,88 +132,400 @@ func (c *Client) Close() {
 	c.quit <- true
 	close(c.jobQueue)
 	close(c.quit)
+	c.disconnect()
+}
+
+// Return the RPC proxy or an error when disconnected.
+func (c *Client) rpc() (pb.BuilderClient, error) {
+	c.cMutex.Lock()
+	defer c.cMutex.Unlock()
+	if c.client == nil {
+		return nil, ErrDisconnected
+	}
+	return c.client, nil
+}
+
+// Send a message to the master on the job stream.
+// Messages are serialized because the stream is shared by all jobs.
+func (c *Client) send(request *pb.PickJobRequest) error {
+	c.cMutex.Lock()
+	defer c.cMutex.Unlock()
+	if c.stream == nil {
+		return ErrDisconnected
+	}
+	return c.stream.Send(request)
+}
+
+// Return whether the slave is connected and subscribed.
+func (c *Client) IsConnected() bool {
+	c.cMutex.Lock()
+	defer c.cMutex.Unlock()
+	return c.stream != nil
+}
+
+// Wait until the slave is connected and subscribed, or timeout has
+// elapsed. Return whether it is connected.
+func (c *Client) waitConnected(timeout time.Duration) bool {
+	c.cMutex.Lock()
+	connected := c.connected
+	c.cMutex.Unlock()
+
+	select {
+	case <-connected:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Close the connection with the master.
+func (c *Client) disconnect() {
+	c.cMutex.Lock()
+	defer c.cMutex.Unlock()
+
+	if c.stream != nil {
+		c.stream.CloseSend()
+		c.stream = nil
+		c.connected = make(chan struct{})
+	}
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
 	c.client = nil
 }
 
+// Connect to the master, subscribe and pick up jobs until the
+// connection is lost, then connect again waiting longer and
+// longer between attempts.
+// Jobs keep running while disconnected, those that finish in
+// the meantime are reported when the connection is back.
+// Return when waitc is closed or ctx is done.
+func (c *Client) Serve(ctx context.Context, address string, waitc <-chan struct{}) {
+	ctx = NewContext(ctx, c.data)
+	delay := minReconnectDelay
+	for {
+		started := time.Now()
+		err := c.serveOnce(ctx, address, waitc)
+		c.disconnect()
+
+		// Quit if we were asked to
+		select {
+		case <-waitc:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		// Start over with short delays after a long session
+		if time.Since(started) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+		wait := reconnectJitter(delay)
+		if err == nil {
+			err = ErrDisconnected
+		}
+		logging.Errorf("Connection to master lost: %s, reconnecting in %v\n", err, wait)
+
+		select {
+		case <-time.After(wait):
+		case <-waitc:
+			return
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// Connect to the master, subscribe and pick up jobs until
+// the connection is lost.
+func (c *Client) serveOnce(ctx context.Context, address string, waitc <-chan struct{}) error {
+	conn, err := grpc.Dial(address, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(dialTimeout))
+	if err != nil {
+		return err
+	}
+	c.cMutex.Lock()
+	c.conn = conn
+	c.client = pb.NewBuilderClient(conn)
+	c.cMutex.Unlock()
+	logging.Infoln("Connected to master")
+
+	if err := c.Subscribe(); err != nil {
+		return err
+	}
+
+	return c.PickJob(ctx, waitc)
+}
+
 // Subscribe to the master.
-func (c *Client) Subscribe() (context.Context, error) {
-	var ctx context.Context
+// The identifier received the first time is sent again when
+// reconnecting, so that the master recognizes the slave.
+func (c *Client) Subscribe() error {
+	client, err := c.rpc()
+	if err != nil {
+		return err
+	}
+
+	// Advertise only the factories for the configured types
+	// and distributions
+	types := strings.Split(Config.Slave.Types, ",")
+	distros := []string{builder.DEFAULT_DISTRIBUTION}
+	if Config.Slave.Distributions != "" {
+		distros = strings.Split(Config.Slave.Distributions, ",")
+	}
+	var factories []string
+	for _, factory := range RegisteredFactories() {
+		parts := strings.SplitN(factory, "/", 2)
+		if utils.StringSliceContains(types, parts[0]) && utils.StringSliceContains(distros, parts[1]) {
+			factories = append(factories, factory)
+		}
+	}
 
 	request := &pb.SubscribeRequest{
 		Name:          Config.Slave.Name,
-		Types:         strings.Split(Config.Slave.Types, ","),
+		Types:         types,
 		Architectures: strings.Split(Config.Slave.Architectures, ","),
+		Factories:     factories,
+		Id:            c.data.Id,
 	}
-	response, err := c.client.Subscribe(context.Background(), request)
+	response, err := client.Subscribe(context.Background(), request)
 	if err != nil {
-		return ctx, err
+		return err
 	}
 
-	data := &SlaveData{
-		Id:        response.Id,
-		ImagesDir: response.ImagesDir,
-		RepoUrl:   response.RepoUrl,
+	c.data.Id = response.Id
+	c.data.ImagesDir = response.ImagesDir
+	c.data.RepoUrl = response.RepoUrl
+	logging.Infof("Slave subscribed with id %d\n", c.data.Id)
+
+	return nil
+}
+
+// Send a job update to the master.
+func (c *Client) sendJobUpdate(j *Job) error {
+	args := &pb.PickJobRequest{
+		Payload: &pb.PickJobRequest_JobUpdate{
+			JobUpdate: &pb.JobUpdateRequest{
+				Id:              j.Id,
+				Status:          jobStatusMap[j.Status],
+				Nevr:            j.nevr,
+				PackagingCommit: j.PackagingCommit(),
+				UpstreamCommit:  j.UpstreamCommit(),
+				Changes:         j.changes,
+			},
+		},
 	}
-	logging.Infof("Slave subscribed with id %d\n", data.Id)
-	ctx = NewContext(context.Background(), data)
+	return c.send(args)
+}
 
-	return ctx, nil
+// Send a build step update to the master.
+func (c *Client) sendStepUpdate(j *Job, bs *BuildStep) error {
+	args := &pb.PickJobRequest{
+		Payload: &pb.PickJobRequest_StepUpdate{
+			StepUpdate: &pb.StepUpdateRequest{
+				JobId:    j.Id,
+				Name:     bs.Name,
+				Running:  !bs.finished.IsZero(),
+				Started:  bs.started.UnixNano(),
+				Finished: bs.finished.UnixNano(),
+				Summary:  utils.MapStringSlice(bs.summary),
+				Logs:     bs.logs,
+
+				PeakMemory: bs.peakMemory,
+				CpuTime:    int64(bs.cpuTime),
+			},
+		},
+	}
+	return c.send(args)
 }
 
-// PickJobs picks up jobs from the master and send back updates.
-func (c *Client) PickJob(ctx context.Context, waitc <-chan struct{}) error {
-	// Get data from context
-	data, ok := FromContext(ctx)
-	if !ok {
-		return fmt.Errorf("no data from context")
+// Send command output to the master, consecutive lines of the
+// same step are batched.
+func (c *Client) sendOutput(j *Job, first *outputLine) {
+	output := &pb.JobOutput{JobId: j.Id, Step: first.step, Lines: []string{first.text}}
+	for len(output.Lines) < outputBatchSize {
+		var line *outputLine
+		select {
+		case line = <-j.outputQueue:
+		default:
+		}
+		if line == nil {
+			break
+		}
+		if line.step != output.Step {
+			c.send(&pb.PickJobRequest{Payload: &pb.PickJobRequest_JobOutput{JobOutput: output}})
+			output = &pb.JobOutput{JobId: j.Id, Step: line.step}
+		}
+		output.Lines = append(output.Lines, line.text)
 	}
+	c.send(&pb.PickJobRequest{Payload: &pb.PickJobRequest_JobOutput{JobOutput: output}})
+}
 
-	// Initiate communication
-	stream, err := c.client.PickJob(context.Background())
-	if err != nil {
-		return err
+// Send all the queued output, this is called before other
+// updates so that the master receives the output first.
+func (c *Client) flushOutput(j *Job) {
+	for {
+		select {
+		case line := <-j.outputQueue:
+			c.sendOutput(j, line)
+		default:
+			return
+		}
 	}
+}
 
-	// Function that send job updates back to the master
-	var sendJobUpdate = func(j *Job) {
-		args := &pb.PickJobRequest{
-			Payload: &pb.PickJobRequest_JobUpdate{
-				JobUpdate: &pb.JobUpdateRequest{
-					Id:     j.Id,
-					Status: jobStatusMap[j.Status],
-				},
+// Check the disk space and tell the master whether we can accept
+// new jobs, only when it changes unless force is true.
+func (c *Client) sendSlaveStatus(force bool) {
+	c.rMutex.Lock()
+	defer c.rMutex.Unlock()
+
+	ready, reason := CheckDiskSpace()
+	if !force && ready == c.ready && reason == c.reason {
+		return
+	}
+	c.ready, c.reason = ready, reason
+	if !ready {
+		logging.Warningf("Not accepting new jobs: %s\n", reason)
+	}
+
+	args := &pb.PickJobRequest{
+		Payload: &pb.PickJobRequest_SlaveStatus{
+			SlaveStatus: &pb.SlaveStatusRequest{
+				Id:       c.data.Id,
+				Ready:    ready,
+				Reason:   reason,
+				Draining: c.draining,
 			},
+		},
+	}
+	c.send(args)
+}
+
+// Report the jobs that finished while disconnected.
+func (c *Client) sendPendingJobs() {
+	c.cMutex.Lock()
+	jobs := make([]*Job, 0, len(c.pending))
+	for _, j := range c.pending {
+		jobs = append(jobs, j)
+	}
+	c.cMutex.Unlock()
+
+	for _, j := range jobs {
+		if err := c.sendJobUpdate(j); err != nil {
+			return
 		}
-		stream.Send(args)
-	}
-
-	// Function that send job updates back to the master
-	var sendStepUpdate = func(j *Job, bs *BuildStep) {
-		args := &pb.PickJobRequest{
-			Payload: &pb.PickJobRequest_StepUpdate{
-				StepUpdate: &pb.StepUpdateRequest{
-					JobId:    j.Id,
-					Name:     bs.Name,
-					Running:  !bs.finished.IsZero(),
-					Started:  bs.started.UnixNano(),
-					Finished: bs.finished.UnixNano(),
-					Summary:  utils.MapStringSlice(bs.summary),
-					Logs:     bs.logs,
-				},
-			},
+		logging.Infof("Reported job #%d finished while disconnected\n", j.Id)
+		c.cMutex.Lock()
+		delete(c.pending, j.Id)
+		c.cMutex.Unlock()
+	}
+}
+
+// Send the updates of a job to the master until it's processed.
+func (c *Client) handleJob(j *Job, waitc <-chan struct{}) {
+	defer func() {
+		c.jMutex.Lock()
+		c.running--
+		c.runningCond.Broadcast()
+		c.jMutex.Unlock()
+	}()
+
+	for {
+		select {
+		case <-j.UpdateChannel:
+			c.flushOutput(j)
+			finished := j.Status >= builder.JOB_STATUS_SUCCESSFUL
+			// Tell the master before it dispatches another job
+			if finished {
+				c.sendSlaveStatus(false)
+			}
+			if err := c.sendJobUpdate(j); err != nil && finished {
+				logging.Warningf("Job #%d will be reported when connected again: %s\n", j.Id, err)
+				c.cMutex.Lock()
+				c.pending[j.Id] = j
+				c.cMutex.Unlock()
+			}
+		case bs := <-j.stepUpdateQueue:
+			c.flushOutput(j)
+			c.sendStepUpdate(j, bs)
+		case line := <-j.outputQueue:
+			c.sendOutput(j, line)
+		case <-j.artifactsChannel:
+			err := c.UploadArtifacts(j.Id, j.artifacts)
+			if err != nil && !c.IsConnected() && c.waitConnected(uploadRetryTimeout) {
+				// Try again when the master is back
+				err = c.UploadArtifacts(j.Id, j.artifacts)
+			}
+			if err != nil {
+				j.Status = builder.JOB_STATUS_FAILED
+				j.Finished = time.Now()
+				logging.Errorln(err)
+			}
+		case <-j.CloseChannel:
+			j = nil
+			return
+		case <-waitc:
+			return
 		}
-		stream.Send(args)
 	}
+}
+
+// PickJobs picks up jobs from the master and send back updates,
+// until the stream is closed.
+func (c *Client) PickJob(ctx context.Context, waitc <-chan struct{}) error {
+	client, err := c.rpc()
+	if err != nil {
+		return err
+	}
+
+	// Initiate communication
+	stream, err := client.PickJob(context.Background())
+	if err != nil {
+		return err
+	}
+	c.cMutex.Lock()
+	c.stream = stream
+	close(c.connected)
+	c.cMutex.Unlock()
+
+	// Check free space before jobs are dispatched and from time to time,
+	// the master waits for us to be ready again
+	c.sendSlaveStatus(true)
+	go func() {
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.sendSlaveStatus(false)
+			case <-stream.Context().Done():
+				return
+			case <-waitc:
+				return
+			}
+		}
+	}()
 
 	// Start the dispatcher
 	args := &pb.PickJobRequest{
 		Payload: &pb.PickJobRequest_SlaveStart{
 			SlaveStart: &pb.SlaveStartRequest{
-				Id: data.Id,
+				Id: c.data.Id,
 			},
 		},
 	}
-	stream.Send(args)
+	if err := c.send(args); err != nil {
+		return err
+	}
+
+	// Now that the master knows us again
+	c.sendPendingJobs()
 
 	// Read from the stream
 	for {
@@ -189,6 +538,19 @@ func (c *Client) PickJob(ctx context.Context, waitc <-chan struct{}) error {
 			return err
 		}
 
+		// Jobs received so far are already counted as running,
+		// only this loop closes the channel
+		if in.DrainingAck {
+			c.rMutex.Lock()
+			select {
+			case <-c.drainingAck:
+			default:
+				close(c.drainingAck)
+			}
+			c.rMutex.Unlock()
+			continue
+		}
+
 		// Read build information from the request
 		var (
 			target string
@@ -212,70 +574,99 @@ func (c *Client) PickJob(ctx context.Context, waitc <-chan struct{}) error {
 		var imgInfo *ImageInfo = nil
 		if pkg != nil {
 			pkgInfo = &PackageInfo{
-				Ci:                pkg.Ci,
-				VcsUrl:            pkg.Vcs.Url,
-				VcsBranch:         pkg.Vcs.Branch,
-				UpstreamVcsUrl:    pkg.UpstreamVcs.Url,
-				UpstreamVcsBranch: pkg.UpstreamVcs.Branch,
+				Ci:                  pkg.Ci,
+				VcsUrl:              pkg.Vcs.Url,
+				VcsBranch:           pkg.Vcs.Branch,
+				VcsRevision:         pkg.Vcs.Revision,
+				UpstreamVcsUrl:      pkg.UpstreamVcs.Url,
+				UpstreamVcsBranch:   pkg.UpstreamVcs.Branch,
+				UpstreamVcsRevision: pkg.UpstreamVcs.Revision,
+				ReleaseVer:          pkg.ReleaseVer,
+
+				PreviousUpstreamCommit: pkg.PreviousUpstreamCommit,
+				Timeout:                time.Duration(pkg.Timeout) * time.Second,
+				MemoryLimit:            pkg.MemoryLimit,
+				CpuLimit:               pkg.CpuLimit,
+				PidsLimit:              pkg.PidsLimit,
 			}
 		} else if img != nil {
 			imgInfo = &ImageInfo{
-				VcsUrl:    img.Vcs.Url,
-				VcsBranch: img.Vcs.Branch,
+				VcsUrl:      img.Vcs.Url,
+				VcsBranch:   img.Vcs.Branch,
+				VcsRevision: img.Vcs.Revision,
+				Kickstart:   img.Kickstart,
+				Format:      img.Format,
+				Product:     img.Product,
+				ReleaseVer:  img.ReleaseVer,
+				Variables:   img.Variables,
+				Timeout:     time.Duration(img.Timeout) * time.Second,
 			}
 		}
-		j := NewJob(ctx, in.Id, target, arch, &TargetInfo{pkgInfo, imgInfo})
-
-		// Send updates back to master
-		go func(j *Job) {
-			for {
-				select {
-				case <-j.UpdateChannel:
-					sendJobUpdate(j)
-				case bs := <-j.stepUpdateQueue:
-					sendStepUpdate(j, bs)
-				case <-j.artifactsChannel:
-					if err := c.UploadArtifacts(j.artifacts); err != nil {
-						j.Status = builder.JOB_STATUS_FAILED
-						j.Finished = time.Now()
-						logging.Errorln(err)
-					}
-				case <-j.CloseChannel:
-					j = nil
-					return
-				case <-waitc:
-					return
-				}
-			}
-		}(j)
+		j := NewJob(ctx, in.Id, target, arch, in.Distribution, &TargetInfo{pkgInfo, imgInfo})
+
+		// Send updates back to master, even across reconnections
+		c.jMutex.Lock()
+		c.running++
+		c.jMutex.Unlock()
+		go c.handleJob(j, waitc)
 
 		// Process
 		c.jobQueue <- j
 	}
 
-	// Close the stream
-	go func() {
-		<-waitc
-		stream.CloseSend()
-	}()
-
 	return nil
 }
 
+// Ask the master not to dispatch new jobs and wait until
+// those already dispatched are processed.
+// Jobs might be dispatched before the master knows, hence the
+// acknowledgement is awaited before waiting for the jobs.
+func (c *Client) Drain() {
+	// Acknowledgements of a previous drain don't count
+	c.rMutex.Lock()
+	c.draining = true
+	c.drainingAck = make(chan struct{})
+	drainingAck := c.drainingAck
+	c.rMutex.Unlock()
+	c.sendSlaveStatus(true)
+
+	select {
+	case <-drainingAck:
+	case <-time.After(drainingAckTimeout):
+		logging.Warningf("Master did not acknowledge draining in %v\n", drainingAckTimeout)
+	}
+
+	c.jMutex.Lock()
+	for c.running > 0 {
+		logging.Infof("Waiting for %d job(s) to finish...\n", c.running)
+		c.runningCond.Wait()
+	}
+	c.jMutex.Unlock()
+
+	// Jobs that finished while disconnected are reported when
+	// connected again, which won't happen after quitting
+	c.cMutex.Lock()
+	pending := len(c.pending)
+	c.cMutex.Unlock()
+	if pending > 0 {
+		logging.Warningf("%d job(s) finished while disconnected will not be reported\n", pending)
+	}
+}
+
 // Unsubscribe from the master.
-func (c *Client) Unsubscribe(ctx context.Context) error {
-	data, ok := FromContext(ctx)
-	if !ok {
-		return fmt.Errorf("no data from context")
+func (c *Client) Unsubscribe() error {
+	client, err := c.rpc()
+	if err != nil {
+		return err
 	}
 
-	args := &pb.UnsubscribeRequest{Id: data.Id}
-	_, err := c.client.Unsubscribe(ctx, args)
+	args := &pb.UnsubscribeRequest{Id: c.data.Id}
+	_, err = client.Unsubscribe(context.Background(), args)
 	return err
 }
 
-// Upload an artifact to the master.
-func (c *Client) UploadArtifact(artifact *Artifact) error {
+// Upload an artifact produced by job id to the master.
+func (c *Client) UploadArtifact(id uint64, artifact *Artifact) error {
 	// Logging
 	logging.Infof("Uploading \"%s\" to the staging repository...\n",
 		filepath.Base(artifact.FileName))
@@ -283,20 +674,24 @@ func (c *Client) UploadArtifact(artifact *Artifact) error {
 	// Determine how many bytes are left to send
 	stat, err := os.Stat(artifact.FileName)
 	if err != nil {
-		return fmt.Errorf("Failed to stat \"%s\": %s", err)
+		return fmt.Errorf("Failed to stat \"%s\": %s", artifact.FileName, err)
 	}
 
 	// Open the file
 	file, err := os.Open(artifact.FileName)
 	if err != nil {
-		return fmt.Errorf("Failed to open \"%s\": %s", err)
+		return fmt.Errorf("Failed to open \"%s\": %s", artifact.FileName, err)
 	}
 
 	// SHA256 hash
 	hasher := sha256.New()
 
 	// Open the stream
-	stream, err := c.client.Upload(context.Background())
+	client, err := c.rpc()
+	if err != nil {
+		return err
+	}
+	stream, err := client.Upload(context.Background())
 	if err != nil {
 		return err
 	}
@@ -305,9 +700,13 @@ func (c *Client) UploadArtifact(artifact *Artifact) error {
 	args := &pb.UploadMessage{
 		Payload: &pb.UploadMessage_Request{
 			Request: &pb.UploadRequest{
-				FileName:   filepath.Base(artifact.FileName),
-				ReleaseVer: artifact.ReleaseVer,
-				BaseArch:   artifact.BaseArch,
+				FileName:     filepath.Base(artifact.FileName),
+				ReleaseVer:   artifact.ReleaseVer,
+				BaseArch:     artifact.BaseArch,
+				Distribution: artifact.Distribution,
+				JobId:        id,
+				Image:        artifact.Image,
+				Source:       artifact.Source,
 			},
 		},
 	}
@@ -324,7 +723,7 @@ func (c *Client) UploadArtifact(artifact *Artifact) error {
 		size, err := file.Read(chunk)
 		if err == nil {
 			// Update hash with this chunk
-			hasher.Sum(chunk[:size])
+			hasher.Write(chunk[:size])
 
 			// Send chunk
 			args := &pb.UploadMessage{
@@ -378,8 +777,8 @@ func (c *Client) UploadArtifact(artifact *Artifact) error {
 	return nil
 }
 
-// Upload artifacts to the master.
-func (c *Client) UploadArtifacts(artifacts []*Artifact) error {
+// Upload artifacts produced by job id to the master.
+func (c *Client) UploadArtifacts(id uint64, artifacts []*Artifact) error {
 	var wg sync.WaitGroup
 	var mutex sync.RWMutex
 	var errors []string
@@ -388,7 +787,7 @@ func (c *Client) UploadArtifacts(artifacts []*Artifact) error {
 		wg.Add(1)
 		go func(artifact *Artifact) {
 			defer wg.Done()
-			if err := c.UploadArtifact(artifact); err != nil {
+			if err := c.UploadArtifact(id, artifact); err != nil {
 				mutex.Lock()
 				errors = append(errors, err.Error())
 				mutex.Unlock()
@@ -415,7 +814,11 @@ func (c *Client) DownloadFile(srcfilename, dstfilename string) error {
 	defer file.Close()
 
 	// Initiate download
-	stream, err := c.client.Download(context.Background(), &pb.DownloadRequest{srcfilename})
+	client, err := c.rpc()
+	if err != nil {
+		return err
+	}
+	stream, err := client.Download(context.Background(), &pb.DownloadRequest{srcfilename, false})
 	if err != nil {
 		return err
 	}
@@ -429,6 +832,9 @@ func (c *Client) DownloadFile(srcfilename, dstfilename string) error {
 	// Write chunks received from master
 	for {
 		r, err := stream.Recv()
+		if err == io.EOF {
+			return fmt.Errorf("transfer interrupted")
+		}
 		if err != nil {
 			return err
 		}
@@ -449,11 +855,12 @@ func (c *Client) DownloadFile(srcfilename, dstfilename string) error {
 			}
 
 			// Hash check
-			hash := hasher.Sum(chunk.Data)
-			if !bytes.Equal(hash, chunk.Hash) {
+			hash := sha256.Sum256(chunk.Data)
+			if !bytes.Equal(hash[:], chunk.Hash) {
 				return fmt.Errorf("wrong SHA256 hash \"%s\" for the chunk, expected \"%s\"",
-					hex.EncodeToString(hash), hex.EncodeToString(chunk.Hash))
+					hex.EncodeToString(hash[:]), hex.EncodeToString(chunk.Hash))
 			}
+			hasher.Write(chunk.Data)
 
 			// Increment size
 			total += int64(size)
@@ -473,8 +880,7 @@ func (c *Client) DownloadFile(srcfilename, dstfilename string) error {
 				return fmt.Errorf("wrong SHA256 hash \"%s\", expected \"%s\"",
 					hex.EncodeToString(hash), hex.EncodeToString(end.Hash))
 			}
+			return nil
 		}
 	}
-
-	return nil
 }
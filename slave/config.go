@@ -35,9 +35,43 @@ type Settings struct {
 		Name          string
 		Types         string
 		Architectures string
+		Distributions string
 	}
 	Directory struct {
-		WorkDir string
+		WorkDir  string
+		CacheDir string
+	}
+	Vcs struct {
+		ShallowDepth int
+	}
+	Rpm struct {
+		Changelog           bool
+		ChangelogAuthor     string
+		ChangelogMaxEntries int
+	}
+	Archlinux struct {
+		ChrootDir string
+	}
+	Debian struct {
+		Builder     string
+		PbuilderDir string
+	}
+	Timeout struct {
+		Vcs     string
+		Prepare string
+		Build   string
+		Image   string
+		Job     string
+	}
+	Workspace struct {
+		MinFreeSpace string
+		GcFreeSpace  string
+	}
+	Limits struct {
+		CgroupDir string
+		Memory    string
+		Cpu       string
+		Pids      int
 	}
 }
 
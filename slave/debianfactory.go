@@ -0,0 +1,387 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/hawaii-desktop/builder/logging"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrNoReleaseVer         = errors.New("no chroot release was provided")
+	ErrNoDsc                = errors.New("Dsc property was not saved")
+	ErrUnknownDebianBuilder = errors.New("unknown Debian builder, use sbuild or pbuilder")
+	ErrNoChangelogVersion   = errors.New("unable to read the version from debian/changelog")
+)
+
+// Tools that build Debian binary packages.
+const (
+	debianBuilderSbuild   = "sbuild"
+	debianBuilderPbuilder = "pbuilder"
+)
+
+// Directory with the pbuilder base tarballs when the
+// configuration doesn't specify it.
+const defaultPbuilderDir = "/var/cache/pbuilder"
+
+// Maps builder architectures to Debian architectures.
+var debianArchMap = map[string]string{
+	"i386":   "i386",
+	"x86_64": "amd64",
+	"armhfp": "armhf",
+}
+
+func init() {
+	RegisterFactory("package", "debian", NewDebianFactory)
+	RegisterFactory("package", "ubuntu", NewDebianFactory)
+}
+
+func NewDebianFactory(j *Job) *Factory {
+	f := NewFactory(j)
+
+	// Fetch the packaging repository, it must contain the debian directory
+	f.AddBuildStep(&BuildStep{
+		Name:      "git packaging",
+		KeepGoing: false,
+		Run:       debianFactoryGitFetch,
+	})
+
+	// Download the original tarball for non-native packages
+	f.AddBuildStep(&BuildStep{
+		Name:      "origtargz",
+		KeepGoing: false,
+		Run:       debianFactoryOrigTarball,
+	})
+
+	// Build the source package
+	f.AddBuildStep(&BuildStep{
+		Name:      "dpkg-source",
+		KeepGoing: false,
+		Run:       debianFactoryDpkgSource,
+	})
+
+	// Build binary packages
+	f.AddBuildStep(&BuildStep{
+		Name:      debianBuilder(),
+		KeepGoing: false,
+		Run:       debianFactoryBinary,
+	})
+
+	return f
+}
+
+// Return the tool that builds binary packages.
+func debianBuilder() string {
+	if Config.Debian.Builder == "" {
+		return debianBuilderSbuild
+	}
+	return Config.Debian.Builder
+}
+
+// Check the Debian settings of the configuration.
+func CheckDebian() error {
+	switch debianBuilder() {
+	case debianBuilderSbuild, debianBuilderPbuilder:
+		return nil
+	}
+	return ErrUnknownDebianBuilder
+}
+
+func debianFactoryGitFetch(bs *BuildStep) error {
+	pkg := bs.parent.job.Info.Package
+	_, err := bs.parent.Download(pkg.VcsUrl, pkg.VcsBranch, pkg.VcsRevision, bs.parent.workdir, "packaging")
+	return err
+}
+
+func debianFactoryOrigTarball(bs *BuildStep) error {
+	// Change directory
+	cwd := path.Join(bs.parent.workdir, "packaging")
+	os.Chdir(cwd)
+
+	// Packages without debian/source/format use the 1.0 format
+	format := "1.0"
+	if contents, err := ioutil.ReadFile(path.Join("debian", "source", "format")); err == nil {
+		format = strings.TrimSpace(string(contents))
+	}
+
+	// Native packages don't have an original tarball, with
+	// the 1.0 format they are those without a Debian revision
+	switch format {
+	case "3.0 (quilt)":
+	case "1.0":
+		version := debianFactoryChangelogVersion(path.Join("debian", "changelog"))
+		if version == "" {
+			return ErrNoChangelogVersion
+		}
+		if !strings.Contains(version, "-") {
+			bs.AddSummary("Format", "1.0 (native)")
+			return nil
+		}
+	case "3.0 (native)":
+		bs.AddSummary("Format", format)
+		return nil
+	default:
+		return fmt.Errorf("source format \"%s\" is not supported", format)
+	}
+	bs.AddSummary("Format", format)
+
+	// Download with uscan or pristine-tar into the parent directory
+	cmd := exec.Command("origtargz", "--unpack=no")
+	return bs.parent.RunWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_PREPARE))
+}
+
+func debianFactoryDpkgSource(bs *BuildStep) error {
+	// Change directory
+	os.Chdir(bs.parent.workdir)
+
+	// Remove source packages from previous builds
+	for _, pattern := range []string{"*.dsc", "*.debian.tar.*", "*.diff.gz"} {
+		files, err := filepath.Glob(pattern)
+		if err == nil {
+			for _, file := range files {
+				os.Remove(file)
+			}
+		}
+	}
+
+	// Build the source package
+	cmd := exec.Command("dpkg-source", "-b", "packaging")
+	output, err := bs.parent.RunCombinedWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_PREPARE))
+	if err != nil {
+		return err
+	}
+
+	// Save the .dsc name
+	re := regexp.MustCompile(`building .+ in (\S+\.dsc)`)
+	m := re.FindStringSubmatch(string(output))
+	if len(m) != 2 {
+		return ErrNoDsc
+	}
+	bs.parent.properties["Dsc"] = m[1]
+
+	return nil
+}
+
+func debianFactoryBinary(bs *BuildStep) error {
+	// Determine release and architecture
+	releasever := bs.parent.job.Info.Package.ReleaseVer
+	if releasever == "" {
+		return ErrNoReleaseVer
+	}
+	arch, ok := debianArchMap[bs.parent.job.Architecture]
+	if !ok {
+		return fmt.Errorf("architecture %s is not supported by %s",
+			bs.parent.job.Architecture, bs.parent.job.DistributionName())
+	}
+	dsc := bs.parent.properties.GetString("Dsc", "")
+	if dsc == "" {
+		return ErrNoDsc
+	}
+	dsc = path.Join(bs.parent.workdir, dsc)
+
+	// Results are written to a clean directory
+	resultdir := path.Join(bs.parent.workdir, "results", fmt.Sprintf("%s-%s", releasever, arch))
+	os.RemoveAll(resultdir)
+	if err := os.MkdirAll(resultdir, 0755); err != nil {
+		return err
+	}
+	os.Chdir(resultdir)
+
+	// Build against the <release>-<arch>-sbuild chroot or
+	// the <release>-<arch>.tgz pbuilder base tarball
+	var cmd *exec.Cmd
+	if debianBuilder() == debianBuilderPbuilder {
+		basedir := Config.Debian.PbuilderDir
+		if basedir == "" {
+			basedir = defaultPbuilderDir
+		}
+		logfile := fmt.Sprintf("%s_%s.build", strings.TrimSuffix(filepath.Base(dsc), ".dsc"), arch)
+		cmd = exec.Command("sudo", "pbuilder", "--build",
+			"--distribution", releasever, "--architecture", arch,
+			"--basetgz", path.Join(basedir, fmt.Sprintf("%s-%s.tgz", releasever, arch)),
+			"--buildresult", resultdir, "--logfile", path.Join(resultdir, logfile), dsc)
+	} else {
+		cmd = exec.Command("sbuild", "--dist="+releasever, "--arch="+arch,
+			"--no-run-lintian", dsc)
+	}
+	err := bs.parent.RunWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_BUILD))
+
+	// Collect the build log even on failure
+	logs, _ := filepath.Glob("*.build")
+	for _, file := range logs {
+		contents, err := ioutil.ReadFile(file)
+		if err == nil {
+			bs.logs[filepath.Base(file)] = contents
+		} else {
+			logging.Warningf("Unable to read \"%s\": %s\n", file, err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Source package name and version, artifacts are stored
+	// in the pool under the source package name
+	bs.parent.job.nevr = debianFactoryNevr(dsc)
+	source, _ := debianFactoryDscSource(dsc)
+
+	// Collect source artifacts: the .dsc and the files it references,
+	// tarballs of other versions left by previous builds are ignored
+	sourcefiles, err := debianFactoryDscFiles(dsc)
+	if err != nil {
+		return fmt.Errorf("Unable to collect source artifacts: %s\n", err)
+	}
+	debianFactoryAddArtifact(bs, dsc, source, releasever, "source")
+	for _, file := range sourcefiles {
+		debianFactoryAddArtifact(bs, file, source, releasever, "source")
+	}
+
+	// Collect binary artifacts
+	for _, pattern := range []string{"*.deb", "*.changes"} {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("Unable to collect artifacts: %s\n", err)
+		}
+		for _, file := range files {
+			debianFactoryAddArtifact(bs, file, source, releasever, arch)
+		}
+	}
+
+	return nil
+}
+
+// Return source package name and version from a .dsc file,
+// fallback to the file name if it cannot be parsed.
+func debianFactoryNevr(dsc string) string {
+	source, version := debianFactoryDscSource(dsc)
+	if source == "" || version == "" {
+		return strings.Replace(strings.TrimSuffix(filepath.Base(dsc), ".dsc"), "_", "-", 1)
+	}
+	return source + "-" + version
+}
+
+// Return the Source and Version fields of a .dsc file, empty
+// strings are returned for fields that cannot be found.
+func debianFactoryDscSource(dsc string) (string, string) {
+	contents, err := ioutil.ReadFile(dsc)
+	if err != nil {
+		return "", ""
+	}
+
+	var source, version string
+	scanner := bufio.NewScanner(strings.NewReader(string(contents)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Source: ") {
+			source = strings.TrimSpace(strings.TrimPrefix(line, "Source: "))
+		} else if strings.HasPrefix(line, "Version: ") {
+			version = strings.TrimSpace(strings.TrimPrefix(line, "Version: "))
+		}
+	}
+	return source, version
+}
+
+// Return the version of the latest entry of a debian/changelog
+// file, or an empty string if it cannot be parsed.
+func debianFactoryChangelogVersion(changelog string) string {
+	file, err := os.Open(changelog)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	// The first line is "<source> (<version>) <distributions>; <options>"
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return ""
+	}
+	m := regexp.MustCompile(`^\S+ \(([^)]+)\)`).FindStringSubmatch(scanner.Text())
+	if len(m) != 2 {
+		return ""
+	}
+	return m[1]
+}
+
+// Return the files listed in the Files field of a .dsc file,
+// they are in the same directory.
+func debianFactoryDscFiles(dsc string) ([]string, error) {
+	contents, err := ioutil.ReadFile(dsc)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	inFiles := false
+	scanner := bufio.NewScanner(strings.NewReader(string(contents)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "Files:" {
+			inFiles = true
+			continue
+		}
+		if !inFiles {
+			continue
+		}
+
+		// Continuation lines are "<md5sum> <size> <file name>"
+		if !strings.HasPrefix(line, " ") {
+			inFiles = false
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 3 {
+			files = append(files, path.Join(filepath.Dir(dsc), fields[2]))
+		}
+	}
+	return files, nil
+}
+
+func debianFactoryAddArtifact(bs *BuildStep, file, source, releasever, basearch string) {
+	fullpath, err := filepath.Abs(file)
+	if err != nil {
+		logging.Warningf("Failed to determine absolute path of \"%s\": %s\n", file, err)
+		return
+	}
+
+	bs.parent.job.artifacts = append(bs.parent.job.artifacts, &Artifact{
+		FileName:     fullpath,
+		ReleaseVer:   releasever,
+		BaseArch:     basearch,
+		Distribution: bs.parent.job.DistributionName(),
+		Permission:   0644,
+		Source:       source,
+	})
+}
@@ -0,0 +1,246 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"github.com/hawaii-desktop/builder/logging"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Git mirror cache errors.
+var (
+	ErrNoCacheDir = errors.New("cache directory not configured")
+)
+
+// File touched every time a mirror is used.
+const gitMirrorStampFile = "builder-last-used"
+
+// Return the directory with the git mirrors, empty if the
+// cache is disabled.
+func gitMirrorsDir() string {
+	if Config.Directory.CacheDir == "" {
+		return ""
+	}
+	return path.Join(Config.Directory.CacheDir, "git")
+}
+
+// Return the path of the bare mirror for url, the name of the
+// repository is kept to make it easier to recognize.
+func gitMirrorPath(url string) string {
+	name := strings.TrimSuffix(path.Base(strings.TrimRight(url, "/")), ".git")
+	name = strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || (r >= '0' && r <= '9') ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, name)
+	hash := sha1.Sum([]byte(url))
+	return path.Join(gitMirrorsDir(), name+"-"+hex.EncodeToString(hash[:])[:12]+".git")
+}
+
+// Lock a directory, such as a mirror or a workspace, with a shared
+// or exclusive lock depending on how, which is a flock(2) operation.
+// The lock file might be removed by a prune while waiting,
+// in that case try again with the new file.
+func lockDirectory(dir string, how int) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(dir+".lock", os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err = syscall.Flock(int(file.Fd()), how); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		// Make sure we locked the file that is still on disk
+		fi, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if di, err := os.Stat(file.Name()); err == nil && os.SameFile(fi, di) {
+			return file, nil
+		}
+		file.Close()
+	}
+}
+
+// Unlock a directory.
+func unlockDirectory(file *os.File) {
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	file.Close()
+}
+
+// Create or update the bare mirror of url and return its path with
+// a shared lock held, so that it won't be pruned while cloning from
+// it, the caller releases the lock as soon as the clone is done.
+// An empty path is returned when the cache is disabled or the
+// mirror cannot be used.
+// Locks are never waited for: when another process is updating
+// the mirror it's used as it is, or not at all if it doesn't
+// exist yet.
+func (f *Factory) updateGitMirror(url string) (string, *os.File) {
+	if gitMirrorsDir() == "" {
+		return "", nil
+	}
+	if err := os.MkdirAll(gitMirrorsDir(), 0755); err != nil {
+		logging.Warningf("Unable to create git mirrors directory: %s\n", err)
+		return "", nil
+	}
+
+	// Keep the mirror from being pruned while in use
+	mirror := gitMirrorPath(url)
+	lock, err := lockDirectory(mirror, syscall.LOCK_SH|syscall.LOCK_NB)
+	if err != nil {
+		logging.Warningf("Unable to lock git mirror \"%s\": %s\n", mirror, err)
+		return "", nil
+	}
+
+	// Only one process at a time can update the mirror
+	updateLock, err := lockDirectory(mirror+".update", syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		if _, err := os.Stat(path.Join(mirror, "HEAD")); err != nil {
+			unlockDirectory(lock)
+			logging.Infof("Git mirror \"%s\" is being created, cloning from upstream\n", mirror)
+			return "", nil
+		}
+		logging.Infof("Git mirror \"%s\" is being updated, using it as it is\n", mirror)
+		return mirror, lock
+	}
+	defer unlockDirectory(updateLock)
+
+	if _, err := os.Stat(path.Join(mirror, "HEAD")); err != nil {
+		// Clone into a temporary directory so that a mirror
+		// being created is never used by other processes
+		tmpdir := mirror + ".new"
+		os.RemoveAll(tmpdir)
+		cmd := exec.Command("git", "clone", "--mirror", url, tmpdir)
+		err = f.RunWithTimeout(cmd, f.StepTimeout(STEP_KIND_VCS))
+		if err == nil {
+			// Don't drop objects while other jobs clone from the mirror
+			cmd = exec.Command("git", "--git-dir", tmpdir, "config", "gc.auto", "0")
+			err = f.RunWithTimeout(cmd, checkoutTimeout)
+		}
+		if err == nil {
+			os.RemoveAll(mirror)
+			err = os.Rename(tmpdir, mirror)
+		}
+		if err != nil {
+			os.RemoveAll(tmpdir)
+			unlockDirectory(lock)
+			logging.Warningf("Unable to mirror \"%s\": %s\n", url, err)
+			return "", nil
+		}
+	} else {
+		cmd := exec.Command("git", "--git-dir", mirror, "fetch", "--prune", "origin")
+		if err := f.RunWithTimeout(cmd, fetchTimeout); err != nil {
+			// An outdated mirror is still useful
+			logging.Warningf("Unable to update mirror of \"%s\": %s\n", url, err)
+		}
+	}
+
+	// Remember when it was used for the last time
+	ioutil.WriteFile(path.Join(mirror, gitMirrorStampFile), []byte(time.Now().Format(time.RFC3339)+"\n"), 0644)
+
+	return mirror, lock
+}
+
+// Return whether all the alternate object directories of a clone
+// exist, clones referencing a pruned mirror are broken.
+func gitAlternatesValid(clonedir string) bool {
+	data, err := ioutil.ReadFile(path.Join(clonedir, ".git", "objects", "info", "alternates"))
+	if err != nil {
+		return true
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := os.Stat(line); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove git mirrors that were not used for maxAge and are not
+// in use, return the paths of the mirrors removed.
+func PruneGitMirrors(maxAge time.Duration) ([]string, error) {
+	if gitMirrorsDir() == "" {
+		return nil, ErrNoCacheDir
+	}
+
+	mirrors, err := filepath.Glob(path.Join(gitMirrorsDir(), "*.git"))
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, mirror := range mirrors {
+		// Fallback to the directory modification time for
+		// mirrors that were never stamped
+		fi, err := os.Stat(path.Join(mirror, gitMirrorStampFile))
+		if err != nil {
+			if fi, err = os.Stat(mirror); err != nil {
+				continue
+			}
+		}
+		if time.Since(fi.ModTime()) < maxAge {
+			continue
+		}
+
+		// Skip mirrors being used
+		lock, err := lockDirectory(mirror, syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != nil {
+			logging.Infof("Skipping git mirror \"%s\": %s\n", mirror, err)
+			continue
+		}
+		if err = os.RemoveAll(mirror); err == nil {
+			removed = append(removed, mirror)
+			os.Remove(mirror + ".update.lock")
+			os.Remove(lock.Name())
+		} else {
+			logging.Errorf("Unable to remove git mirror \"%s\": %s\n", mirror, err)
+		}
+		unlockDirectory(lock)
+	}
+
+	return removed, nil
+}
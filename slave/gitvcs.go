@@ -0,0 +1,180 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"fmt"
+	"github.com/hawaii-desktop/builder/logging"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterVcs("git", &gitVcs{})
+}
+
+// Git backend, with objects borrowed from the mirror cache
+// when enabled.
+type gitVcs struct{}
+
+// Return whether a command succeeds, without logging it.
+func gitProbe(args ...string) bool {
+	return exec.Command("git", args...).Run() == nil
+}
+
+func (v *gitVcs) Download(f *Factory, source *VcsSource, dir string) error {
+	// Objects are copied from the mirror, if the cache is enabled,
+	// which stays locked only until the clone is done
+	mirror, lock := f.updateGitMirror(source.Url)
+
+	// Shallow clones don't save anything when objects are local
+	depth := Config.Vcs.ShallowDepth
+	if mirror != "" {
+		depth = 0
+	}
+
+	// Clones referencing a mirror that was pruned have to be recreated
+	if !gitAlternatesValid(dir) {
+		logging.Warningf("Removing \"%s\" since its mirror was pruned\n", path.Base(dir))
+		os.RemoveAll(dir)
+	}
+
+	// Clone if the clone directory doesn't exist otherwise fetch
+	if _, err := os.Stat(path.Join(dir, ".git", "HEAD")); err != nil {
+		os.MkdirAll(path.Dir(dir), 0755)
+		os.Chdir(path.Dir(dir))
+		args := []string{"clone", "--no-checkout"}
+		if mirror != "" {
+			args = append(args, "--reference", mirror, "--dissociate")
+		}
+		if depth > 0 {
+			args = append(args, "--depth", strconv.Itoa(depth), "--no-single-branch")
+		}
+		args = append(args, source.Url, path.Base(dir))
+		cmd := exec.Command("git", args...)
+		if err := f.RunWithTimeout(cmd, f.StepTimeout(STEP_KIND_VCS)); err != nil {
+			if lock != nil {
+				unlockDirectory(lock)
+			}
+			return err
+		}
+	}
+	if lock != nil {
+		unlockDirectory(lock)
+	}
+
+	// Enter the clone directory
+	os.Chdir(dir)
+
+	// Fetch from origin
+	args := []string{"fetch", "--tags", "--prune", "origin"}
+	if depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+	}
+	cmd := exec.Command("git", args...)
+	if err := f.RunWithTimeout(cmd, fetchTimeout); err != nil {
+		return err
+	}
+
+	// Check out a commit or tag detached, branches are reset to the
+	// remote head so that force pushes and previous detached
+	// checkouts don't get in the way
+	switch {
+	case source.Revision != "":
+		if err := v.checkoutRevision(f, source.Revision, depth); err != nil {
+			return err
+		}
+	case gitProbe("rev-parse", "--verify", "-q", "refs/remotes/origin/"+source.Branch):
+		cmd = exec.Command("git", "checkout", "--force", "-B", source.Branch, "origin/"+source.Branch)
+		if err := f.RunWithTimeout(cmd, checkoutTimeout); err != nil {
+			return err
+		}
+	default:
+		if err := v.checkoutRevision(f, source.Branch, depth); err != nil {
+			return err
+		}
+	}
+
+	// Initialize and update submodules
+	if _, err := os.Stat(".gitmodules"); err == nil {
+		cmd = exec.Command("git", "submodule", "sync", "--recursive")
+		if err := f.RunWithTimeout(cmd, checkoutTimeout); err != nil {
+			return err
+		}
+		args = []string{"submodule", "update", "--init", "--recursive", "--force"}
+		if depth > 0 {
+			args = append(args, "--depth", strconv.Itoa(depth))
+		}
+		cmd = exec.Command("git", args...)
+		if err := f.RunWithTimeout(cmd, f.StepTimeout(STEP_KIND_VCS)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Check out a commit or tag, shallow clones might not have
+// it yet and in that case it's fetched explicitly.
+func (v *gitVcs) checkoutRevision(f *Factory, revision string, depth int) error {
+	if depth > 0 && !gitProbe("rev-parse", "--verify", "-q", revision+"^{commit}") {
+		cmd := exec.Command("git", "fetch", "--depth", strconv.Itoa(depth), "origin", revision)
+		if err := f.RunWithTimeout(cmd, fetchTimeout); err != nil {
+			return err
+		}
+		revision = "FETCH_HEAD"
+	}
+
+	cmd := exec.Command("git", "checkout", "--force", "--detach", revision)
+	return f.RunWithTimeout(cmd, checkoutTimeout)
+}
+
+func (v *gitVcs) Revision(f *Factory, dir string) (*VcsRevision, error) {
+	cmd := exec.Command("git", "log", "-1", "--format=%H%n%h%n%ct")
+	cmd.Dir = dir
+	output, err := f.RunCombinedWithTimeout(cmd, checkoutTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// The command output is the last lines
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) < 3 {
+		return nil, fmt.Errorf("unexpected git log output: %q", output)
+	}
+	lines = lines[len(lines)-3:]
+	timestamp, err := strconv.ParseInt(lines[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VcsRevision{lines[0], lines[1], time.Unix(timestamp, 0)}, nil
+}
@@ -0,0 +1,99 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterVcs("hg", &hgVcs{})
+}
+
+// Mercurial backend.
+type hgVcs struct{}
+
+func (v *hgVcs) Download(f *Factory, source *VcsSource, dir string) error {
+	// Clone if the clone directory doesn't exist otherwise pull
+	if _, err := os.Stat(path.Join(dir, ".hg")); err != nil {
+		os.MkdirAll(path.Dir(dir), 0755)
+		os.Chdir(path.Dir(dir))
+		cmd := exec.Command("hg", "clone", "--noupdate", source.Url, path.Base(dir))
+		if err := f.RunWithTimeout(cmd, f.StepTimeout(STEP_KIND_VCS)); err != nil {
+			return err
+		}
+	}
+
+	// Enter the clone directory
+	os.Chdir(dir)
+
+	// Pull from the repository
+	cmd := exec.Command("hg", "pull")
+	if err := f.RunWithTimeout(cmd, fetchTimeout); err != nil {
+		return err
+	}
+
+	// Update to the revision, tag or branch; master is the default
+	// branch of packages added without specifying one and doesn't
+	// exist in Mercurial
+	revision := source.Revision
+	if revision == "" {
+		revision = source.Branch
+		if revision == "" || revision == "master" {
+			revision = "default"
+		}
+	}
+	cmd = exec.Command("hg", "update", "--clean", "--rev", revision)
+	return f.RunWithTimeout(cmd, checkoutTimeout)
+}
+
+func (v *hgVcs) Revision(f *Factory, dir string) (*VcsRevision, error) {
+	cmd := exec.Command("hg", "log", "--rev", ".", "--template", "{node}\n{node|short}\n{date|hgdate}\n")
+	cmd.Dir = dir
+	output, err := f.RunCombinedWithTimeout(cmd, checkoutTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) < 3 {
+		return nil, fmt.Errorf("unexpected hg log output: %q", output)
+	}
+	lines = lines[len(lines)-3:]
+	timestamp, err := strconv.ParseInt(strings.Fields(lines[2])[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VcsRevision{lines[0], lines[1], time.Unix(timestamp, 0)}, nil
+}
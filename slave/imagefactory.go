@@ -34,11 +34,19 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
+func init() {
+	RegisterFactory("image", "fedora", NewImageFactory)
+}
+
 func NewImageFactory(j *Job) *Factory {
 	f := NewFactory(j)
+	f.SetCleanup(imgFactoryCleanup)
 
 	// Fetch the repository
 	f.AddBuildStep(&BuildStep{
@@ -68,7 +76,8 @@ func imgFactoryGitFetch(bs *BuildStep) error {
 	// Clone or update
 	url := bs.parent.job.Info.Image.VcsUrl
 	branch := bs.parent.job.Info.Image.VcsBranch
-	err := bs.parent.DownloadGit(url, branch, bs.parent.workdir, "sources")
+	revision := bs.parent.job.Info.Image.VcsRevision
+	_, err := bs.parent.Download(url, branch, revision, bs.parent.workdir, "sources")
 	if err != nil {
 		return err
 	}
@@ -80,15 +89,18 @@ func imgFactoryFlatten(bs *BuildStep) error {
 	// Need to run from the sources
 	os.Chdir(path.Join(bs.parent.workdir, "sources"))
 
-	// Determine the source kickstart
-	filename := "hawaii-livecd.ks"
-	if bs.parent.job.Architecture == "armhfp" {
-		filename = "hawaii-arm.ks"
+	// Determine the source kickstart, unless the image has its own
+	filename := bs.parent.job.Info.Image.Kickstart
+	if filename == "" {
+		filename = "hawaii-livecd.ks"
+		if bs.parent.job.Architecture == "armhfp" {
+			filename = "hawaii-arm.ks"
+		}
 	}
 
 	// Flatten
 	cmd := exec.Command("ksflatten", "-c", filename, "-o", "../flattened.ks")
-	if err := bs.parent.RunWithTimeout(cmd, cloneTimeout); err != nil {
+	if err := bs.parent.RunWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_PREPARE)); err != nil {
 		return err
 	}
 	_, err := os.Stat("../flattened.ks")
@@ -106,50 +118,78 @@ func imgFactoryBuild(bs *BuildStep) error {
 		logging.Fatalln("Internal error: no data from context")
 	}
 
+	// Image information
+	info := bs.parent.job.Info.Image
+
+	// Product name
+	product := info.Product
+	if product == "" {
+		product = "Hawaii"
+	}
+
 	today := time.Now().Format("20060102-150405")
-	fsname := fmt.Sprintf("hawaii-%s-%s", today, bs.parent.job.Architecture)
+	fsname := fmt.Sprintf("%s-%s-%s",
+		strings.Replace(strings.ToLower(product), " ", "-", -1),
+		today, bs.parent.job.Architecture)
 	filename := fsname
 
 	// Fedora release
-	releasever := "23"
+	releasever := info.ReleaseVer
+	if releasever == "" {
+		releasever = "23"
+	}
+
+	// Output format
+	format := info.Format
+	if format == "" {
+		format = "live"
+		if bs.parent.job.Architecture == "armhfp" {
+			format = "raw"
+		}
+	}
 
-	// Replace @REPO_URL@
-	input, err := ioutil.ReadFile("flattened.ks")
+	// Replace @REPO_URL@ and the other variables
+	vars := make(map[string]string)
+	for k, v := range info.Variables {
+		vars[k] = v
+	}
+	vars["REPO_URL"] = d.RepoUrl
+	output, err := ioutil.ReadFile("flattened.ks")
 	if err != nil {
 		return err
 	}
-	lines := bytes.Split(input, []byte("\n"))
-	for i, line := range lines {
-		if bytes.Contains(line, []byte("@REPO_URL@")) {
-			lines[i] = bytes.Replace(line, []byte("@REPO_URL@"), []byte(d.RepoUrl), -1)
-		}
+	for k, v := range vars {
+		output = bytes.Replace(output, []byte("@"+k+"@"), []byte(v), -1)
 	}
-	output := bytes.Join(lines, []byte("\n"))
 	if err = ioutil.WriteFile("flattened.ks", output, 0644); err != nil {
 		return err
 	}
 
 	// Build
 	var cmd *exec.Cmd
-	if bs.parent.job.Architecture == "armhfp" {
-		cmd = exec.Command("sudo", "appliance-creator",
-			"--logfile", "results/appliance.log", "--cache", "cache",
-			"-d", "-v", "-o", "results", "--format=raw", "--checksum",
-			"--name", filename, "--version", releasever, "--release", today,
-			"-c", "flattened.ks")
-		filename += ".raw"
-	} else {
+	switch format {
+	case "live":
 		linuxcmd := "linux64"
 		if bs.parent.job.Architecture == "i386" {
 			linuxcmd = "linux32"
 		}
 
 		cmd = exec.Command("sudo", linuxcmd, "livecd-creator", "--releasever="+releasever,
-			"--title=Hawaii", "--product=Hawaii", "-c", "flattened.ks",
+			"--title="+product, "--product="+product, "-c", "flattened.ks",
 			"-f", fsname, "-d", "-v", "--cache", "cache", "--tmpdir", "tmp")
 		filename += ".iso"
+	case "raw", "qcow2":
+		cmd = exec.Command("sudo", "appliance-creator",
+			"--logfile", "results/appliance.log", "--cache", "cache",
+			"-d", "-v", "-o", "results", "--format="+format, "--checksum",
+			"--tmpdir", "tmp",
+			"--name", filename, "--version", releasever, "--release", today,
+			"-c", "flattened.ks")
+		filename += "." + format
+	default:
+		return fmt.Errorf("unsupported image format \"%s\"", format)
 	}
-	if err := bs.parent.RunCommand(cmd); err != nil {
+	if err := bs.parent.RunWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_IMAGE)); err != nil {
 		return err
 	}
 	_, err = os.Stat(filename)
@@ -157,5 +197,45 @@ func imgFactoryBuild(bs *BuildStep) error {
 		return err
 	}
 
+	// Publish the image
+	fullpath, err := filepath.Abs(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to determine absolute path of \"%s\": %s\n", filename, err)
+	}
+	bs.parent.job.artifacts = append(bs.parent.job.artifacts, &Artifact{
+		FileName:     fullpath,
+		ReleaseVer:   releasever,
+		BaseArch:     bs.parent.job.Architecture,
+		Distribution: bs.parent.job.Distribution,
+		Permission:   0644,
+		Image:        bs.parent.job.Target,
+	})
+
 	return nil
 }
+
+// Unmount the file systems that a killed image creator left
+// mounted in its temporary directory.
+func imgFactoryCleanup(f *Factory) error {
+	tmpdir, err := filepath.Abs(path.Join(f.workdir, "tmp"))
+	if err != nil {
+		return err
+	}
+
+	mounts, err := mountsUnder(tmpdir)
+	if err != nil {
+		return err
+	}
+
+	// Nested mounts first
+	sort.Sort(sort.Reverse(sort.StringSlice(mounts)))
+	var lastErr error
+	for _, mountpoint := range mounts {
+		cmd := exec.Command("sudo", "umount", mountpoint)
+		if err := f.RunCleanupCommand(cmd); err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
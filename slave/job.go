@@ -35,17 +35,38 @@ import (
 
 // Package information for a build.
 type PackageInfo struct {
-	Ci                bool
-	VcsUrl            string
-	VcsBranch         string
-	UpstreamVcsUrl    string
-	UpstreamVcsBranch string
+	Ci                  bool
+	VcsUrl              string
+	VcsBranch           string
+	VcsRevision         string
+	UpstreamVcsUrl      string
+	UpstreamVcsBranch   string
+	UpstreamVcsRevision string
+	ReleaseVer          string
+	// Upstream commit of the last successful build.
+	PreviousUpstreamCommit string
+	// Build timeout, zero to use the slave configuration.
+	Timeout time.Duration
+	// Memory limit in bytes, zero to use the slave configuration.
+	MemoryLimit uint64
+	// CPU limit in thousandths of a CPU, zero to use the slave configuration.
+	CpuLimit uint32
+	// Maximum number of processes, zero to use the slave configuration.
+	PidsLimit uint32
 }
 
 // Image information for a build.
 type ImageInfo struct {
-	VcsUrl    string
-	VcsBranch string
+	VcsUrl      string
+	VcsBranch   string
+	VcsRevision string
+	Kickstart   string
+	Format      string
+	Product     string
+	ReleaseVer  string
+	Variables   map[string]string
+	// Build timeout, zero to use the slave configuration.
+	Timeout time.Duration
 }
 
 // Describe a target.
@@ -72,6 +93,15 @@ type Job struct {
 	artifacts []*Artifact
 	// Send a value to this channel to trigger artifacts upload.
 	artifactsChannel chan bool
+	// Command output is queued here and then sent to the master.
+	outputQueue chan *outputLine
+	// Name, epoch, version and release of the package built.
+	nevr string
+	// Commit hashes of the repositories checked out, keyed by
+	// repository role.
+	commits map[string]string
+	// Upstream changes since the last successful build.
+	changes []string
 }
 
 // Artifact.
@@ -82,12 +112,56 @@ type Artifact struct {
 	ReleaseVer string
 	// Package architecture.
 	BaseArch string
+	// Distribution the artifact was built for.
+	Distribution string
 	// File permission on master.
 	Permission uint32
+	// Image name, empty for packages.
+	Image string
+	// Source package name, Debian only.
+	Source string
+}
+
+// Roles of the repositories checked out by a job.
+const (
+	repositoryRolePackaging = "packaging"
+	repositoryRoleUpstream  = "upstream"
+	repositoryRoleKickstart = "kickstart"
+)
+
+// Return the role of the repository cloned into dirname.
+// Images only clone the kickstart repository, the upstream
+// repository of CI packages is cloned into a directory named
+// after the target.
+func (j *Job) repositoryRole(dirname string) string {
+	switch {
+	case j.Type == builder.JOB_TARGET_TYPE_IMAGE:
+		return repositoryRoleKickstart
+	case dirname == "packaging":
+		return repositoryRolePackaging
+	case dirname == j.Target:
+		return repositoryRoleUpstream
+	}
+	return dirname
+}
+
+// Return the commit hash of the packaging repository, for
+// images the kickstart repository which plays the same role.
+func (j *Job) PackagingCommit() string {
+	if j.Type == builder.JOB_TARGET_TYPE_IMAGE {
+		return j.commits[repositoryRoleKickstart]
+	}
+	return j.commits[repositoryRolePackaging]
+}
+
+// Return the commit hash of the upstream repository, only CI
+// packages have one.
+func (j *Job) UpstreamCommit() string {
+	return j.commits[repositoryRoleUpstream]
 }
 
 // Create a new job object.
-func NewJob(ctx context.Context, id uint64, target, arch string, info *TargetInfo) *Job {
+func NewJob(ctx context.Context, id uint64, target, arch, distro string, info *TargetInfo) *Job {
 	var ttype builder.JobTargetType
 	switch {
 	case info.Package != nil:
@@ -101,6 +175,7 @@ func NewJob(ctx context.Context, id uint64, target, arch string, info *TargetInf
 			Type:         ttype,
 			Target:       target,
 			Architecture: arch,
+			Distribution: distro,
 			Started:      time.Time{},
 			Finished:     time.Time{},
 			Status:       builder.JOB_STATUS_WAITING,
@@ -112,6 +187,10 @@ func NewJob(ctx context.Context, id uint64, target, arch string, info *TargetInf
 		make(chan bool),
 		make([]*Artifact, 0),
 		make(chan bool),
+		make(chan *outputLine, outputQueueSize),
+		"",
+		make(map[string]string),
+		nil,
 	}
 	return j
 }
@@ -134,21 +213,20 @@ func (j *Job) Process() {
 		j.Id, j.Target, j.Architecture)
 
 	// Create a factory
-	var f *Factory
-	if j.Info.Package != nil {
-		f = NewRpmFactory(j)
-	} else if j.Info.Image != nil {
-		f = NewImageFactory(j)
-	} else {
-		// We shouldn't reach here but I'm paranoid
+	constructor, ok := LookupFactory(j.Type.String(), j.DistributionName())
+	if !ok {
+		logging.Errorf("No factory for %s/%s\n", j.Type.String(), j.DistributionName())
 		j.Status = builder.JOB_STATUS_FAILED
 		j.UpdateChannel <- true
 		return
 	}
+	f := constructor(j)
 
 	// Run factory
 	if f.Run() {
 		j.Status = builder.JOB_STATUS_SUCCESSFUL
+	} else if f.TimedOut() {
+		j.Status = builder.JOB_STATUS_TIMED_OUT
 	} else {
 		j.Status = builder.JOB_STATUS_FAILED
 	}
@@ -0,0 +1,112 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Maximum number of output lines waiting to be sent to the master,
+// further lines are dropped until there is room again.
+const outputQueueSize = 1024
+
+// Maximum number of lines sent to the master with a single message.
+const outputBatchSize = 64
+
+// Line of output of a build step.
+type outputLine struct {
+	// Build step name.
+	step string
+	// Text without the trailing newline.
+	text string
+}
+
+// Writer that splits command output into lines and queues
+// them to be sent to the master.
+type outputWriter struct {
+	// Factory running the command.
+	factory *Factory
+	// Incomplete line.
+	partial []byte
+	// How many lines were dropped because the queue was full.
+	dropped int
+}
+
+// Create a new output writer for the command run by the factory.
+func newOutputWriter(f *Factory) *outputWriter {
+	return &outputWriter{factory: f}
+}
+
+// Write queues all the complete lines in p.
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.queue(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush queues the last line even if it's not terminated by a newline.
+func (w *outputWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.queue(string(w.partial))
+		w.partial = nil
+	}
+}
+
+// Queue a line without blocking the command.
+func (w *outputWriter) queue(text string) {
+	step := ""
+	if w.factory.current != nil {
+		step = w.factory.current.Name
+	}
+
+	// Let the user know some lines are missing
+	if w.dropped > 0 {
+		marker := fmt.Sprintf("[%d lines dropped]", w.dropped)
+		select {
+		case w.factory.job.outputQueue <- &outputLine{step, marker}:
+			w.dropped = 0
+		default:
+			w.dropped++
+			return
+		}
+	}
+
+	select {
+	case w.factory.job.outputQueue <- &outputLine{step, strings.TrimSuffix(text, "\r")}:
+	default:
+		w.dropped++
+	}
+}
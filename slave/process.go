@@ -0,0 +1,102 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"errors"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrCommandTimedOut = errors.New("command timed out")
+	ErrJobCancelled    = errors.New("job cancelled")
+)
+
+// How long processes have to exit after SIGTERM before
+// they are killed with SIGKILL.
+const killGracePeriod = 10 * time.Second
+
+// Timeout of the commands run to clean up after a kill.
+const cleanupTimeout = 5 * time.Minute
+
+// Commands being run, the slave waits for them to be
+// terminated before quitting.
+var runningCommands sync.WaitGroup
+
+// Start the command in a new session, so that it's the leader
+// of a process group that includes all of its children.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+}
+
+// Terminate the process group of a command with SIGTERM and
+// then SIGKILL after the grace period, done receives the result
+// of waiting for the command.
+// Processes that left the group or changed credentials, like
+// the children of sudo, are only reached through their parent
+// forwarding SIGTERM, hence the factory cleanup.
+func terminateProcessGroup(cmd *exec.Cmd, done <-chan error) {
+	pgid := cmd.Process.Pid
+
+	syscall.Kill(-pgid, syscall.SIGTERM)
+	select {
+	case <-done:
+		return
+	case <-time.After(killGracePeriod):
+	}
+
+	syscall.Kill(-pgid, syscall.SIGKILL)
+	select {
+	case <-done:
+	case <-time.After(killGracePeriod):
+		// Give up, the output of processes that escaped
+		// the group is lost
+	}
+}
+
+// Wait until all running commands have been terminated,
+// or timeout has elapsed. Return whether they were all terminated.
+func WaitForCommands(timeout time.Duration) bool {
+	done := make(chan bool)
+	go func() {
+		runningCommands.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,342 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Name of the recipe file inside the packaging repository.
+const RecipeFileName = "builder.yml"
+
+// Recipe format version.
+const RecipeVersion = 1
+
+var (
+	ErrInvalidRecipe = errors.New("invalid recipe")
+)
+
+// Allowed keys for each level of the recipe, anything else
+// is rejected by validation.
+var recipeSchema = map[string][]string{
+	"recipe": {"version", "env", "steps", "artifacts"},
+	"step":   {"name", "run", "builtin", "timeout", "keep-going", "env", "dir"},
+}
+
+// Valid environment variable names.
+var recipeEnvRegExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Build recipe stored in the packaging repository.
+type Recipe struct {
+	// Format version.
+	Version int `yaml:"version"`
+	// Environment variables for all the steps.
+	Env map[string]string `yaml:"env"`
+	// Steps executed in order.
+	Steps []*RecipeStep `yaml:"steps"`
+	// Globs of artifacts relative to the working directory.
+	Artifacts []string `yaml:"artifacts"`
+}
+
+// Single step of a recipe.
+type RecipeStep struct {
+	// Step name.
+	Name string `yaml:"name"`
+	// Shell command.
+	Run string `yaml:"run"`
+	// Name of a built-in step, alternative to Run.
+	Builtin string `yaml:"builtin"`
+	// Timeout of the command (for example: 30m).
+	Timeout string `yaml:"timeout"`
+	// Whether a failure should keep the factory going.
+	KeepGoing bool `yaml:"keep-going"`
+	// Environment variables for this step.
+	Env map[string]string `yaml:"env"`
+	// Working directory relative to the job working directory.
+	Dir string `yaml:"dir"`
+}
+
+// Function that adds the files matching the artifacts globs to the job.
+type RecipeArtifactsFunc func(bs *BuildStep, files []string) error
+
+// Parse and validate a recipe, builtins is the list of built-in
+// steps the recipe can reference.
+func ParseRecipe(data []byte, builtins map[string]BuildStepRunFunc) (*Recipe, []string) {
+	// Check for unknown keys first
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, []string{err.Error()}
+	}
+	problems := recipeCheckKeys("recipe", raw)
+	if steps, ok := raw["steps"].([]interface{}); ok {
+		for i, step := range steps {
+			if m, ok := step.(map[interface{}]interface{}); ok {
+				keys := make(map[string]interface{})
+				for k, v := range m {
+					keys[fmt.Sprintf("%v", k)] = v
+				}
+				for _, problem := range recipeCheckKeys("step", keys) {
+					problems = append(problems, fmt.Sprintf("step %d: %s", i+1, problem))
+				}
+			} else {
+				problems = append(problems, fmt.Sprintf("step %d: not a mapping", i+1))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	// Decode and validate values
+	r := &Recipe{}
+	if err := yaml.Unmarshal(data, r); err != nil {
+		return nil, []string{err.Error()}
+	}
+	if problems := r.Validate(builtins); len(problems) > 0 {
+		return nil, problems
+	}
+	return r, nil
+}
+
+// Return the keys of m that are not allowed by the schema for level.
+func recipeCheckKeys(level string, m map[string]interface{}) []string {
+	var problems []string
+	for k := range m {
+		found := false
+		for _, allowed := range recipeSchema[level] {
+			if k == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("unknown key \"%s\"", k))
+		}
+	}
+	return problems
+}
+
+// Validate the recipe and return a list of problems.
+func (r *Recipe) Validate(builtins map[string]BuildStepRunFunc) []string {
+	var problems []string
+
+	if r.Version != RecipeVersion {
+		problems = append(problems, fmt.Sprintf("unsupported version %d, expected %d", r.Version, RecipeVersion))
+	}
+	problems = append(problems, recipeCheckEnv("", r.Env)...)
+	if len(r.Steps) == 0 {
+		problems = append(problems, "no steps")
+	}
+
+	names := make(map[string]bool)
+	for i, s := range r.Steps {
+		prefix := fmt.Sprintf("step %d: ", i+1)
+		if s.Name == "" {
+			problems = append(problems, prefix+"missing name")
+		} else if names[s.Name] {
+			problems = append(problems, prefix+fmt.Sprintf("duplicate name \"%s\"", s.Name))
+		}
+		names[s.Name] = true
+
+		if (s.Run == "") == (s.Builtin == "") {
+			problems = append(problems, prefix+"exactly one of run or builtin is required")
+		}
+		if s.Builtin != "" {
+			if _, ok := builtins[s.Builtin]; !ok {
+				problems = append(problems, prefix+fmt.Sprintf("unknown builtin \"%s\"", s.Builtin))
+			}
+			if s.Timeout != "" || s.Dir != "" || len(s.Env) > 0 {
+				problems = append(problems, prefix+"timeout, dir and env are only supported by run steps")
+			}
+		}
+		if s.Timeout != "" {
+			if d, err := time.ParseDuration(s.Timeout); err != nil || d <= 0 {
+				problems = append(problems, prefix+fmt.Sprintf("invalid timeout \"%s\"", s.Timeout))
+			}
+		}
+		if s.Dir != "" && !recipeIsRelative(s.Dir) {
+			problems = append(problems, prefix+fmt.Sprintf("dir \"%s\" must be inside the working directory", s.Dir))
+		}
+		problems = append(problems, recipeCheckEnv(prefix, s.Env)...)
+	}
+
+	for _, glob := range r.Artifacts {
+		if _, err := filepath.Match(glob, ""); err != nil || !recipeIsRelative(glob) {
+			problems = append(problems, fmt.Sprintf("invalid artifacts glob \"%s\"", glob))
+		}
+	}
+
+	return problems
+}
+
+// Return whether p is a relative path that doesn't leave its root.
+func recipeIsRelative(p string) bool {
+	p = path.Clean(p)
+	return !path.IsAbs(p) && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// Return problems with the environment variable names.
+func recipeCheckEnv(prefix string, env map[string]string) []string {
+	var problems []string
+	for k := range env {
+		if !recipeEnvRegExp.MatchString(k) {
+			problems = append(problems, prefix+fmt.Sprintf("invalid environment variable \"%s\"", k))
+		}
+	}
+	return problems
+}
+
+// Create build steps from the recipe.
+func (r *Recipe) BuildSteps(builtins map[string]BuildStepRunFunc, addArtifacts RecipeArtifactsFunc) []*BuildStep {
+	var steps []*BuildStep
+
+	for _, s := range r.Steps {
+		run := builtins[s.Builtin]
+		if s.Run != "" {
+			run = r.commandStep(s)
+		}
+		steps = append(steps, &BuildStep{
+			Name:      s.Name,
+			KeepGoing: s.KeepGoing,
+			Run:       run,
+		})
+	}
+
+	if len(r.Artifacts) > 0 {
+		globs := r.Artifacts
+		steps = append(steps, &BuildStep{
+			Name:      "artifacts",
+			KeepGoing: false,
+			Run: func(bs *BuildStep) error {
+				return recipeCollectArtifacts(bs, globs, addArtifacts)
+			},
+		})
+	}
+
+	return steps
+}
+
+// Return the running function of a step with a shell command.
+func (r *Recipe) commandStep(s *RecipeStep) BuildStepRunFunc {
+	return func(bs *BuildStep) error {
+		// Change directory, the packaging repository by default
+		dir := s.Dir
+		if dir == "" {
+			dir = "packaging"
+		}
+		os.Chdir(path.Join(bs.parent.workdir, dir))
+
+		// Timeout
+		timeout := bs.parent.StepTimeout(STEP_KIND_BUILD)
+		if s.Timeout != "" {
+			timeout, _ = time.ParseDuration(s.Timeout)
+		}
+
+		// Only pass a minimal environment to avoid leaking
+		// the slave environment into the logs
+		env := []string{
+			"PATH=" + os.Getenv("PATH"),
+			"HOME=" + os.Getenv("HOME"),
+			"BUILDER_WORKDIR=" + bs.parent.workdir,
+			"BUILDER_TARGET=" + bs.parent.job.Target,
+			"BUILDER_ARCH=" + bs.parent.job.Architecture,
+			"BUILDER_VCS_DATE=" + bs.parent.properties.GetString("VcsDate", ""),
+			"BUILDER_VCS_REV=" + bs.parent.properties.GetString("VcsShortRev", ""),
+		}
+		for k, v := range r.Env {
+			env = append(env, k+"="+v)
+		}
+		for k, v := range s.Env {
+			env = append(env, k+"="+v)
+		}
+
+		cmd := exec.Command("/bin/sh", "-e", "-c", s.Run)
+		cmd.Env = env
+		return bs.parent.RunWithTimeout(cmd, timeout)
+	}
+}
+
+// Add files matching globs to the artifacts, skipping files
+// that were already added by another step.
+func recipeCollectArtifacts(bs *BuildStep, globs []string, addArtifacts RecipeArtifactsFunc) error {
+	var files []string
+	for _, glob := range globs {
+		matches, err := filepath.Glob(path.Join(bs.parent.workdir, glob))
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			found := false
+			for _, artifact := range bs.parent.job.artifacts {
+				if artifact.FileName == match {
+					found = true
+					break
+				}
+			}
+			if !found {
+				files = append(files, match)
+			}
+		}
+	}
+
+	bs.AddSummary("Artifacts", fmt.Sprintf("%d", len(files)))
+	return addArtifacts(bs, files)
+}
+
+// Load the recipe from the packaging repository and replace the steps
+// that follow bs with it, the steps are left untouched when the
+// packaging repository doesn't have a recipe.
+func LoadRecipeSteps(bs *BuildStep, builtins map[string]BuildStepRunFunc, addArtifacts RecipeArtifactsFunc) error {
+	data, err := ioutil.ReadFile(path.Join(bs.parent.workdir, "packaging", RecipeFileName))
+	if os.IsNotExist(err) {
+		bs.AddSummary("Recipe", "default")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	r, problems := ParseRecipe(data, builtins)
+	if len(problems) > 0 {
+		bs.AddSummary("Recipe Errors", strings.Join(problems, "\n"))
+		return ErrInvalidRecipe
+	}
+
+	steps := r.BuildSteps(builtins, addArtifacts)
+	bs.AddSummary("Recipe", fmt.Sprintf("%s (%d steps)", RecipeFileName, len(steps)))
+	bs.ReplaceNextSteps(steps)
+	return nil
+}
@@ -0,0 +1,184 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Built-in steps recipes can reference in tests.
+var testRecipeBuiltins = map[string]BuildStepRunFunc{
+	"rpmbuild": func(bs *BuildStep) error { return nil },
+}
+
+func TestParseRecipe(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		problems []string
+	}{
+		{
+			"valid",
+			"version: 1\nenv:\n  CFLAGS: -O2\nsteps:\n  - name: build\n    run: make\n    timeout: 30m\n    dir: packaging/src\n  - name: rpm\n    builtin: rpmbuild\nartifacts:\n  - \"*.rpm\"\n",
+			nil,
+		},
+		{
+			"invalid yaml",
+			"version: [1\n",
+			[]string{"yaml: line 1: did not find expected ',' or ']'"},
+		},
+		{
+			"unknown recipe key",
+			"version: 1\nstages: []\nsteps:\n  - name: build\n    run: make\n",
+			[]string{"unknown key \"stages\""},
+		},
+		{
+			"unknown step key",
+			"version: 1\nsteps:\n  - name: build\n    command: make\n",
+			[]string{"step 1: unknown key \"command\""},
+		},
+		{
+			"step not a mapping",
+			"version: 1\nsteps:\n  - make\n",
+			[]string{"step 1: not a mapping"},
+		},
+		{
+			"invalid values",
+			"version: 2\nsteps:\n  - name: build\n",
+			[]string{
+				"unsupported version 2, expected 1",
+				"step 1: exactly one of run or builtin is required",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		r, problems := ParseRecipe([]byte(test.data), testRecipeBuiltins)
+		if !reflect.DeepEqual(problems, test.problems) {
+			t.Errorf("%s: expected problems %q, got %q", test.name, test.problems, problems)
+		}
+		if (r == nil) != (len(test.problems) > 0) {
+			t.Errorf("%s: expected a recipe only without problems", test.name)
+		}
+	}
+}
+
+func TestRecipeValidate(t *testing.T) {
+	run := func(name string) *RecipeStep {
+		return &RecipeStep{Name: name, Run: "make"}
+	}
+
+	tests := []struct {
+		name     string
+		recipe   *Recipe
+		problems []string
+	}{
+		{
+			"valid",
+			&Recipe{Version: 1, Steps: []*RecipeStep{run("build")}, Artifacts: []string{"results/*.rpm"}},
+			nil,
+		},
+		{
+			"unsupported version",
+			&Recipe{Version: 0, Steps: []*RecipeStep{run("build")}},
+			[]string{"unsupported version 0, expected 1"},
+		},
+		{
+			"no steps",
+			&Recipe{Version: 1},
+			[]string{"no steps"},
+		},
+		{
+			"invalid recipe environment",
+			&Recipe{Version: 1, Env: map[string]string{"1FOO": "bar"}, Steps: []*RecipeStep{run("build")}},
+			[]string{"invalid environment variable \"1FOO\""},
+		},
+		{
+			"missing name",
+			&Recipe{Version: 1, Steps: []*RecipeStep{run("")}},
+			[]string{"step 1: missing name"},
+		},
+		{
+			"duplicate name",
+			&Recipe{Version: 1, Steps: []*RecipeStep{run("build"), run("build")}},
+			[]string{"step 2: duplicate name \"build\""},
+		},
+		{
+			"run and builtin",
+			&Recipe{Version: 1, Steps: []*RecipeStep{{Name: "build", Run: "make", Builtin: "rpmbuild"}}},
+			[]string{"step 1: exactly one of run or builtin is required"},
+		},
+		{
+			"unknown builtin",
+			&Recipe{Version: 1, Steps: []*RecipeStep{{Name: "build", Builtin: "debuild"}}},
+			[]string{"step 1: unknown builtin \"debuild\""},
+		},
+		{
+			"builtin with run options",
+			&Recipe{Version: 1, Steps: []*RecipeStep{{Name: "build", Builtin: "rpmbuild", Dir: "src"}}},
+			[]string{"step 1: timeout, dir and env are only supported by run steps"},
+		},
+		{
+			"invalid timeout",
+			&Recipe{Version: 1, Steps: []*RecipeStep{{Name: "build", Run: "make", Timeout: "soon"}}},
+			[]string{"step 1: invalid timeout \"soon\""},
+		},
+		{
+			"negative timeout",
+			&Recipe{Version: 1, Steps: []*RecipeStep{{Name: "build", Run: "make", Timeout: "-1m"}}},
+			[]string{"step 1: invalid timeout \"-1m\""},
+		},
+		{
+			"directory outside",
+			&Recipe{Version: 1, Steps: []*RecipeStep{{Name: "build", Run: "make", Dir: "packaging/../.."}}},
+			[]string{"step 1: dir \"packaging/../..\" must be inside the working directory"},
+		},
+		{
+			"absolute directory",
+			&Recipe{Version: 1, Steps: []*RecipeStep{{Name: "build", Run: "make", Dir: "/tmp"}}},
+			[]string{"step 1: dir \"/tmp\" must be inside the working directory"},
+		},
+		{
+			"invalid step environment",
+			&Recipe{Version: 1, Steps: []*RecipeStep{{Name: "build", Run: "make", Env: map[string]string{"FOO-BAR": "1"}}}},
+			[]string{"step 1: invalid environment variable \"FOO-BAR\""},
+		},
+		{
+			"invalid artifacts glob",
+			&Recipe{Version: 1, Steps: []*RecipeStep{run("build")}, Artifacts: []string{"[", "../*.rpm"}},
+			[]string{"invalid artifacts glob \"[\"", "invalid artifacts glob \"../*.rpm\""},
+		},
+	}
+
+	for _, test := range tests {
+		problems := test.recipe.Validate(testRecipeBuiltins)
+		if !reflect.DeepEqual(problems, test.problems) {
+			t.Errorf("%s: expected problems %q, got %q", test.name, test.problems, problems)
+		}
+	}
+}
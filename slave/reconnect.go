@@ -0,0 +1,58 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+var (
+	ErrDisconnected = errors.New("not connected to master")
+)
+
+// How long to wait for the connection to the master.
+const dialTimeout = 10 * time.Second
+
+// Delay before the first reconnection attempt, it doubles
+// after each failure up to maxReconnectDelay.
+const minReconnectDelay = time.Second
+
+// Maximum delay between reconnection attempts.
+const maxReconnectDelay = 2 * time.Minute
+
+// How long to wait for the master to come back before
+// giving up uploading artifacts.
+const uploadRetryTimeout = 30 * time.Minute
+
+// Return a random delay between half and the whole delay, so that
+// slaves don't reconnect all at the same time after a master restart.
+func reconnectJitter(delay time.Duration) time.Duration {
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,77 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"sort"
+	"sync"
+)
+
+// Function that creates a factory for a job.
+type FactoryConstructor func(j *Job) *Factory
+
+// Registered factory constructors, keyed by <kind>/<distribution>.
+var (
+	factories = make(map[string]FactoryConstructor)
+	fMutex    sync.RWMutex
+)
+
+// Return the key used to register a factory.
+func factoryKey(kind, distribution string) string {
+	return kind + "/" + distribution
+}
+
+// Register a factory constructor for the target kind (package, image, ...)
+// and distribution (fedora, ...).
+// This is usually called from the init() function of the file that
+// implements the factory.
+func RegisterFactory(kind, distribution string, constructor FactoryConstructor) {
+	fMutex.Lock()
+	defer fMutex.Unlock()
+	factories[factoryKey(kind, distribution)] = constructor
+}
+
+// Return the factory constructor for the target kind and distribution.
+func LookupFactory(kind, distribution string) (FactoryConstructor, bool) {
+	fMutex.RLock()
+	defer fMutex.RUnlock()
+	constructor, ok := factories[factoryKey(kind, distribution)]
+	return constructor, ok
+}
+
+// Return the sorted list of registered factories in the
+// <kind>/<distribution> format.
+func RegisteredFactories() []string {
+	fMutex.RLock()
+	defer fMutex.RUnlock()
+	var list []string
+	for k := range factories {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
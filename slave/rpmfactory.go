@@ -37,7 +37,9 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -46,14 +48,42 @@ var (
 	ErrNoSrpm            = errors.New("Srpm property was not saved")
 )
 
+// Fedora release packages are built for.
+const rpmReleaseVer = "23"
+
+// Environment of mock commands, /sbin must come first so that the
+// mock that doesn't ask for a password is executed (provided that
+// the user is in the mock group).
+var rpmMockEnv = []string{"PATH=/usr/local/sbin:/sbin:/usr/sbin:/usr/local/bin:/bin:/usr/bin"}
+
+// Generated changelog defaults.
+const (
+	rpmChangelogAuthor     = "Builder <builder@localhost>"
+	rpmChangelogMaxEntries = 50
+)
+
+// Built-in steps that can be referenced by a recipe.
+var rpmBuiltinSteps = map[string]BuildStepRunFunc{
+	"rpmlint":  rpmFactoryRpmlint,
+	"sources":  rpmFactorySources,
+	"spectool": rpmFactorySpectool,
+	"srpm":     rpmFactorySrpmBuild,
+	"mock":     rpmFactoryMockRebuild,
+}
+
+func init() {
+	RegisterFactory("package", "fedora", NewRpmFactory)
+}
+
 func NewRpmFactory(j *Job) *Factory {
 	f := NewFactory(j)
+	f.SetCleanup(rpmFactoryCleanup)
 
 	// Make the repositories iterable
 	var repos [][]string
-	repos = append(repos, []string{"packaging", j.Info.Package.VcsUrl, j.Info.Package.VcsBranch})
+	repos = append(repos, []string{"packaging", j.Info.Package.VcsUrl, j.Info.Package.VcsBranch, j.Info.Package.VcsRevision})
 	if j.Info.Package.Ci {
-		repos = append(repos, []string{j.Target, j.Info.Package.UpstreamVcsUrl, j.Info.Package.UpstreamVcsBranch})
+		repos = append(repos, []string{j.Target, j.Info.Package.UpstreamVcsUrl, j.Info.Package.UpstreamVcsBranch, j.Info.Package.UpstreamVcsRevision})
 	}
 
 	// Fetch all repositories
@@ -68,12 +98,33 @@ func NewRpmFactory(j *Job) *Factory {
 		})
 	}
 
+	// Document upstream changes of CI packages
+	if j.Info.Package.Ci && Config.Rpm.Changelog {
+		f.AddBuildStep(&BuildStep{
+			Name:      "changelog",
+			KeepGoing: true,
+			Run:       rpmFactoryChangelog,
+		})
+	}
+
 	// TODO: Do we need to build?
 	buildNeeded := true
 	if !buildNeeded {
 		return f
 	}
 
+	// Replace the steps below with the recipe from the packaging
+	// repository, if any
+	f.AddBuildStep(&BuildStep{
+		Name:      "recipe",
+		KeepGoing: false,
+		Run: func(bs *BuildStep) error {
+			return LoadRecipeSteps(bs, rpmBuiltinSteps, func(bs *BuildStep, files []string) error {
+				return rpmFactoryAddArtifacts(bs, files, rpmReleaseVer)
+			})
+		},
+	})
+
 	// Validate spec file with rpmlint but do not block builds on failure
 	f.AddBuildStep(&BuildStep{
 		Name:      "rpmlint",
@@ -116,32 +167,90 @@ func NewRpmFactory(j *Job) *Factory {
 
 func rpmFactoryGitFetch(repo []string, bs *BuildStep) error {
 	// Clone or update
-	err := bs.parent.DownloadGit(repo[1], repo[2], bs.parent.workdir, repo[0])
+	rev, err := bs.parent.Download(repo[1], repo[2], repo[3], bs.parent.workdir, repo[0])
 	if err != nil {
 		return err
 	}
 
 	// Get version information from upstream
 	if repo[0] == bs.parent.job.Target {
-		cmd := exec.Command("git", "log", "-1", `--format="%cd"`, "--date=short")
-		output, err := bs.parent.RunCombinedWithTimeout(cmd, cloneTimeout)
-		if err != nil {
-			return err
-		}
-		result := strings.Replace(string(output), "-", "", -1)
-		result = strings.Replace(result, "\"", "", -1)
-		bs.parent.properties["VcsDate"] = strings.TrimSuffix(result, "\n")
+		bs.parent.properties["VcsDate"] = rev.Date.Format("20060102")
+		bs.parent.properties["VcsShortRev"] = rev.ShortId
+		bs.parent.properties["VcsName"], _ = splitVcsUrl(repo[1])
+	}
 
-		cmd = exec.Command("git", "log", "-1", `--format="%h"`)
-		output, err = bs.parent.RunCombinedWithTimeout(cmd, cloneTimeout)
-		if err != nil {
-			return err
+	return nil
+}
+
+// Add a changelog entry with the upstream commits since the last
+// successful build to the spec file.
+func rpmFactoryChangelog(bs *BuildStep) error {
+	pkg := bs.parent.job.Info.Package
+	if name, _ := splitVcsUrl(pkg.UpstreamVcsUrl); name != "git" {
+		bs.AddSummary("Changelog", "Upstream history is available only for git")
+		return nil
+	}
+
+	// Upstream commits since the last build, just the last one when
+	// the previous build is unknown or not in the history (for
+	// example rewritten or not fetched by a shallow clone)
+	upstreamdir := path.Join(bs.parent.workdir, bs.parent.job.Target)
+	max := Config.Rpm.ChangelogMaxEntries
+	if max <= 0 {
+		max = rpmChangelogMaxEntries
+	}
+	args := []string{"-C", upstreamdir, "log", "--no-merges", "--format=%h %s"}
+	previous := pkg.PreviousUpstreamCommit
+	if previous != "" && gitProbe("-C", upstreamdir, "merge-base", "--is-ancestor", previous, "HEAD") {
+		args = append(args, "-n", strconv.Itoa(max), previous+"..HEAD")
+	} else {
+		args = append(args, "-n", "1")
+	}
+	cmd := exec.Command("git", args...)
+	output, err := bs.parent.RunCombinedWithTimeout(cmd, checkoutTimeout)
+	if err != nil {
+		return err
+	}
+	var changes []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			changes = append(changes, line)
 		}
-		result = strings.Replace(string(output), "\"", "", -1)
-		bs.parent.properties["VcsShortRev"] = strings.TrimSuffix(result, "\n")
 	}
+	bs.parent.job.changes = changes
 
-	return nil
+	// Generate the entry, % must be escaped for rpm
+	author := Config.Rpm.ChangelogAuthor
+	if author == "" {
+		author = rpmChangelogAuthor
+	}
+	entry := fmt.Sprintf("* %s %s\n- Snapshot %s%s%s\n",
+		time.Now().Format("Mon Jan 02 2006"), author,
+		bs.parent.properties.GetString("VcsDate", ""),
+		bs.parent.properties.GetString("VcsName", "git"),
+		bs.parent.properties.GetString("VcsShortRev", ""))
+	if len(changes) == 0 {
+		entry += "- No upstream changes\n"
+	}
+	for _, change := range changes {
+		entry += "- " + strings.Replace(change, "%", "%%", -1) + "\n"
+	}
+	bs.AddSummary("Changes", strings.Join(changes, "\n"))
+
+	// Prepend it to the existing changelog
+	specfile := path.Join(bs.parent.workdir, "packaging", bs.parent.job.Target+".spec")
+	data, err := ioutil.ReadFile(specfile)
+	if err != nil {
+		return err
+	}
+	spec := string(data)
+	loc := regexp.MustCompile(`(?m)^%changelog[ \t]*\n`).FindStringIndex(spec)
+	if loc == nil {
+		spec = strings.TrimRight(spec, "\n") + "\n\n%changelog\n" + entry
+	} else {
+		spec = spec[:loc[1]] + entry + "\n" + spec[loc[1]:]
+	}
+	return ioutil.WriteFile(specfile, []byte(spec), 0644)
 }
 
 func rpmFactoryRpmlint(bs *BuildStep) error {
@@ -151,7 +260,7 @@ func rpmFactoryRpmlint(bs *BuildStep) error {
 
 	// Run rpmlint
 	cmd := exec.Command("rpmlint", "-i", bs.parent.job.Target+".spec")
-	output, err := bs.parent.RunCombinedWithTimeout(cmd, cloneTimeout)
+	output, err := bs.parent.RunCombinedWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_PREPARE))
 	if err != nil {
 		return err
 	}
@@ -194,7 +303,7 @@ func rpmFactorySources(bs *BuildStep) error {
 	// Make sources
 	filename := path.Join("packaging", bs.parent.job.Target+".tar.xz")
 	cmd := exec.Command("tar", "-cJf", filename, bs.parent.job.Target)
-	if err := bs.parent.RunWithTimeout(cmd, cloneTimeout); err != nil {
+	if err := bs.parent.RunWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_PREPARE)); err != nil {
 		return err
 	}
 	_, err := os.Stat(filename)
@@ -213,7 +322,7 @@ func rpmFactorySpectool(bs *BuildStep) error {
 	// Make sources
 	filename := bs.parent.job.Target + ".spec"
 	cmd := exec.Command("spectool", "-g", "-A", filename)
-	if err := bs.parent.RunWithTimeout(cmd, cloneTimeout); err != nil {
+	if err := bs.parent.RunWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_PREPARE)); err != nil {
 		return err
 	}
 	_, err := os.Stat(filename)
@@ -246,7 +355,8 @@ func rpmFactorySrpmBuild(bs *BuildStep) error {
 		if date == "" || revision == "" {
 			return ErrNoVcsInformation
 		}
-		args = append(args, "--define", "_checkout "+fmt.Sprintf("%sgit%s", date, revision))
+		vcsname := bs.parent.properties.GetString("VcsName", "git")
+		args = append(args, "--define", "_checkout "+fmt.Sprintf("%s%s%s", date, vcsname, revision))
 	}
 
 	// Append specfile
@@ -254,7 +364,7 @@ func rpmFactorySrpmBuild(bs *BuildStep) error {
 
 	// Run rpmbuild
 	cmd := exec.Command("rpmbuild", args...)
-	output, err := bs.parent.RunCombinedWithTimeout(cmd, cloneTimeout)
+	output, err := bs.parent.RunCombinedWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_PREPARE))
 	if err != nil {
 		return err
 	}
@@ -306,7 +416,7 @@ func rpmFactoryMockRebuild(bs *BuildStep) error {
 	os.Chdir(cwd)
 
 	// Fedora release
-	releasever := "23"
+	releasever := rpmReleaseVer
 
 	// Determine mock root
 	root := fmt.Sprintf("fedora-%s-%s", releasever, bs.parent.job.Architecture)
@@ -333,7 +443,7 @@ func rpmFactoryMockRebuild(bs *BuildStep) error {
 		if date == "" || revision == "" {
 			return ErrNoVcsInformation
 		}
-		rev := date + "git" + revision
+		rev := date + bs.parent.properties.GetString("VcsName", "git") + revision
 		args = append(args, "-m", "--define=_checkout "+rev)
 	}
 	args = append(args, "-m", `--define="vendor Hawaii"`)
@@ -348,12 +458,11 @@ func rpmFactoryMockRebuild(bs *BuildStep) error {
 	args = append(args, "--tmp_prefix", "builder-mock")
 
 	// We run mockchain instead of mock so we can use the remote
-	// repository from master, we also need to have /sbin come first
-	// so the right mock is executed (the one that doesn't ask for a
-	// password, provided that the user is in the mock group)
+	// repository from master
+	bs.parent.properties["MockRoot"] = root
 	cmd := exec.Command("mockchain", args...)
-	cmd.Env = []string{"PATH=/usr/local/sbin:/sbin:/usr/sbin:/usr/local/bin:/bin:/usr/bin"}
-	if err := bs.parent.RunWithTimeout(cmd, cloneTimeout); err != nil {
+	cmd.Env = rpmMockEnv
+	if err := bs.parent.RunWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_BUILD)); err != nil {
 		return err
 	}
 
@@ -372,35 +481,71 @@ func rpmFactoryMockRebuild(bs *BuildStep) error {
 		logging.Warningf("Unable to collect mock logs: %s\n", err)
 	}
 
+	// Collect the artifacts
+	files, err = filepath.Glob(resultdir + "/*.rpm")
+	if err != nil {
+		return fmt.Errorf("Unable to collect artifacts: %s\n", err)
+	}
+	return rpmFactoryAddArtifacts(bs, files, releasever)
+}
+
+// Return name, epoch, version and release of a source RPM, fallback
+// to the file name when rpm cannot query it.
+func rpmFactoryNevr(bs *BuildStep, srpm, fallback string) string {
+	cmd := exec.Command("rpm", "-qp", "--qf", "%{NAME}-%|EPOCH?{%{EPOCH}:}|%{VERSION}-%{RELEASE}", srpm)
+	output, err := bs.parent.RunCombinedWithTimeout(cmd, bs.parent.StepTimeout(STEP_KIND_PREPARE))
+	if err != nil {
+		return fallback
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// Append RPMs from files to the job artifacts.
+func rpmFactoryAddArtifacts(bs *BuildStep, files []string, releasever string) error {
 	// Regular expressions for RPMs
 	re := regexp.MustCompile(`^(.+)\.([a-z0-9\-_]+)\.rpm$`)
 
-	// Collect the artifacts
-	files, err = filepath.Glob(resultdir + "/*.rpm")
-	if err == nil {
-		for _, file := range files {
-			fullpath, err := filepath.Abs(file)
-			if err != nil {
-				return fmt.Errorf("Failed to determine absolute path of \"%s\": %s\n", file, err)
-			}
+	for _, file := range files {
+		fullpath, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("Failed to determine absolute path of \"%s\": %s\n", file, err)
+		}
 
-			m := re.FindStringSubmatch(filepath.Base(file))
-			if len(m) == 3 {
-				basearch := bs.parent.job.Architecture
-				if m[2] == "src" {
-					basearch = "source"
-				}
-
-				bs.parent.job.artifacts = append(bs.parent.job.artifacts, &Artifact{
-					FileName:   fullpath,
-					ReleaseVer: releasever,
-					BaseArch:   basearch,
-					Permission: 0644,
-				})
+		m := re.FindStringSubmatch(filepath.Base(file))
+		if len(m) == 3 {
+			basearch := bs.parent.job.Architecture
+			if m[2] == "src" {
+				basearch = "source"
+				bs.parent.job.nevr = rpmFactoryNevr(bs, fullpath, m[1])
 			}
+
+			bs.parent.job.artifacts = append(bs.parent.job.artifacts, &Artifact{
+				FileName:   fullpath,
+				ReleaseVer: releasever,
+				BaseArch:   basearch,
+				Permission: 0644,
+			})
+		}
+	}
+
+	return nil
+}
+
+// Kill processes left in the mock chroot and remove it after
+// a build was killed, otherwise the next build finds it locked.
+func rpmFactoryCleanup(f *Factory) error {
+	root := f.properties.GetString("MockRoot", "")
+	if root == "" {
+		return nil
+	}
+
+	for _, action := range []string{"--orphanskill", "--clean"} {
+		cmd := exec.Command("mock", "--root", root, action)
+		cmd.Env = rpmMockEnv
+		if err := f.RunCleanupCommand(cmd); err != nil {
+			return err
 		}
-	} else {
-		return fmt.Errorf("Unable to collect artifacts: %s\n", err)
 	}
 
 	return nil
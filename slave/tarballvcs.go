@@ -0,0 +1,131 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterVcs("tarball", &tarballVcs{})
+}
+
+// Backend for sources released as archives, the checksum is used
+// as revision and when a revision is requested the archive must
+// have that SHA256 checksum.
+type tarballVcs struct{}
+
+// Return the path of the archive downloaded for dir.
+func tarballPath(dir string) string {
+	return path.Join(path.Dir(dir), "."+path.Base(dir)+".tarball")
+}
+
+func (v *tarballVcs) Download(f *Factory, source *VcsSource, dir string) error {
+	archive := tarballPath(dir)
+	os.MkdirAll(path.Dir(dir), 0755)
+
+	// Download the archive
+	fmt.Fprintf(f.buffer, "Downloading: %s\n", source.Url)
+	// Give up when the job is canceled or the step times out
+	req, err := http.NewRequest("GET", source.Url, nil)
+	if err != nil {
+		return err
+	}
+	req.Cancel = f.job.ctx.Done()
+	client := &http.Client{Timeout: f.StepTimeout(STEP_KIND_VCS)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download \"%s\": %s", source.Url, resp.Status)
+	}
+
+	file, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hasher), resp.Body)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	fmt.Fprintf(f.buffer, "Downloaded %d bytes, SHA256 %s\n", size, checksum)
+
+	// Verify the checksum
+	if source.Revision != "" && !strings.EqualFold(source.Revision, checksum) {
+		return fmt.Errorf("wrong SHA256 checksum \"%s\", expected \"%s\"", checksum, source.Revision)
+	}
+
+	// Remember the release date
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(archive, modified, modified)
+	}
+	if err = ioutil.WriteFile(archive+".sha256", []byte(checksum+"\n"), 0644); err != nil {
+		return err
+	}
+
+	// Extract without the top level directory
+	os.RemoveAll(dir)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	os.Chdir(dir)
+	cmd := exec.Command("tar", "-xf", archive, "--strip-components=1")
+	return f.RunWithTimeout(cmd, f.StepTimeout(STEP_KIND_VCS))
+}
+
+func (v *tarballVcs) Revision(f *Factory, dir string) (*VcsRevision, error) {
+	archive := tarballPath(dir)
+	data, err := ioutil.ReadFile(archive + ".sha256")
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := strings.TrimSpace(string(data))
+	if len(checksum) < 7 {
+		return nil, fmt.Errorf("invalid checksum for \"%s\"", archive)
+	}
+	return &VcsRevision{checksum, checksum[:7], fi.ModTime().In(time.UTC)}, nil
+}
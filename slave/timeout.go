@@ -0,0 +1,155 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrJobTimedOut = errors.New("job deadline exceeded")
+)
+
+// Kind of build step, each kind has its own timeout.
+type StepKind uint32
+
+const (
+	// Clone or download sources.
+	STEP_KIND_VCS StepKind = iota
+	// Short commands that prepare the build (source packages,
+	// linting, kickstart flattening...).
+	STEP_KIND_PREPARE
+	// Package build.
+	STEP_KIND_BUILD
+	// Image creation.
+	STEP_KIND_IMAGE
+)
+
+// Timeouts used when the configuration doesn't specify them.
+var defaultStepTimeouts = map[StepKind]time.Duration{
+	STEP_KIND_VCS:     10 * time.Minute,
+	STEP_KIND_PREPARE: 10 * time.Minute,
+	STEP_KIND_BUILD:   3 * time.Hour,
+	STEP_KIND_IMAGE:   3 * time.Hour,
+}
+
+// Job deadline used when the configuration doesn't specify it.
+const defaultJobTimeout = 12 * time.Hour
+
+// Return the configured timeout of a step kind.
+func configuredStepTimeout(kind StepKind) string {
+	switch kind {
+	case STEP_KIND_VCS:
+		return Config.Timeout.Vcs
+	case STEP_KIND_PREPARE:
+		return Config.Timeout.Prepare
+	case STEP_KIND_BUILD:
+		return Config.Timeout.Build
+	case STEP_KIND_IMAGE:
+		return Config.Timeout.Image
+	}
+	return ""
+}
+
+// Parse a timeout from the configuration, empty means
+// the default value.
+func parseTimeout(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid timeout \"%s\"", value)
+	}
+	return d, nil
+}
+
+// Check the timeouts of the configuration.
+func CheckTimeouts() error {
+	for kind, def := range defaultStepTimeouts {
+		if _, err := parseTimeout(configuredStepTimeout(kind), def); err != nil {
+			return err
+		}
+	}
+	_, err := parseTimeout(Config.Timeout.Job, defaultJobTimeout)
+	return err
+}
+
+// Return how long a job can run, zero means forever.
+// Packages and images asking for more time to build than the
+// slave configuration allows extend the job by the difference.
+func (f *Factory) jobTimeout() time.Duration {
+	d, err := parseTimeout(Config.Timeout.Job, defaultJobTimeout)
+	if err != nil {
+		d = defaultJobTimeout
+	}
+	if d == 0 {
+		return 0
+	}
+
+	for _, kind := range []StepKind{STEP_KIND_BUILD, STEP_KIND_IMAGE} {
+		configured, err := parseTimeout(configuredStepTimeout(kind), defaultStepTimeouts[kind])
+		if err != nil {
+			configured = defaultStepTimeouts[kind]
+		}
+		requested := f.StepTimeout(kind)
+		switch {
+		case configured == 0 && requested > d:
+			d = requested
+		case configured > 0 && requested > configured:
+			d += requested - configured
+		}
+	}
+	return d
+}
+
+// Return the timeout of commands run by a step of the given kind.
+// Packages and images may ask for more time to build, otherwise
+// the slave configuration applies.
+func (f *Factory) StepTimeout(kind StepKind) time.Duration {
+	info := f.job.Info
+	switch {
+	case kind == STEP_KIND_BUILD && info.Package != nil && info.Package.Timeout > 0:
+		return info.Package.Timeout
+	case kind == STEP_KIND_IMAGE && info.Image != nil && info.Image.Timeout > 0:
+		return info.Image.Timeout
+	}
+
+	d, err := parseTimeout(configuredStepTimeout(kind), defaultStepTimeouts[kind])
+	if err != nil {
+		return defaultStepTimeouts[kind]
+	}
+	return d
+}
+
+// Return whether the last build step failed because it timed out
+// or the job deadline was exceeded.
+func (f *Factory) TimedOut() bool {
+	return f.timedOut
+}
@@ -0,0 +1,149 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hawaii-desktop/builder"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VCS errors.
+var (
+	ErrUnsupportedVcs = errors.New("unsupported version control system")
+)
+
+// Sources to download.
+type VcsSource struct {
+	// Repository or file URL, without the backend prefix.
+	Url string
+	// Branch, might also be a tag for repositories added
+	// before revisions could be specified.
+	Branch string
+	// Commit hash or tag, the branch head when empty.
+	Revision string
+}
+
+// Revision that was checked out.
+type VcsRevision struct {
+	// Full identifier (commit hash, checksum, ...).
+	Id string
+	// Abbreviated identifier.
+	ShortId string
+	// When it was committed.
+	Date time.Time
+}
+
+// Version control system backend.
+type Vcs interface {
+	// Download the sources into dir or update them if already
+	// there, then check out the requested revision.
+	Download(f *Factory, source *VcsSource, dir string) error
+	// Return the revision checked out in dir.
+	Revision(f *Factory, dir string) (*VcsRevision, error)
+}
+
+// Registered VCS backends, keyed by name.
+var (
+	vcsBackends = make(map[string]Vcs)
+	vMutex      sync.RWMutex
+)
+
+// Register a VCS backend.
+// This is usually called from the init() function of the file that
+// implements the backend.
+func RegisterVcs(name string, vcs Vcs) {
+	vMutex.Lock()
+	defer vMutex.Unlock()
+	vcsBackends[name] = vcs
+}
+
+// Return the VCS backend by name.
+func LookupVcs(name string) (Vcs, bool) {
+	vMutex.RLock()
+	defer vMutex.RUnlock()
+	vcs, ok := vcsBackends[name]
+	return vcs, ok
+}
+
+// Return the sorted list of registered VCS backends.
+func RegisteredVcs() []string {
+	vMutex.RLock()
+	defer vMutex.RUnlock()
+	var list []string
+	for k := range vcsBackends {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Return the backend name and the URL without its prefix.
+// The backend is specified with a <name>+ prefix (for example
+// hg+https://hg.example.org/repo), archives are recognized by their
+// extension and everything else is a git repository.
+func splitVcsUrl(url string) (string, string) {
+	if i := strings.Index(url, "+"); i > 0 && strings.Contains(url[i:], "://") {
+		if name := url[:i]; !strings.ContainsAny(name, ":/@") {
+			return name, url[i+1:]
+		}
+	}
+	if builder.IsTarballUrl(url) {
+		return "tarball", url
+	}
+	return "git", url
+}
+
+// Download the sources from url into parentdir/dirname and check
+// out branch or revision when it's not empty, the current directory
+// is changed to the sources.
+// The revision checked out is remembered and reported to the master.
+func (f *Factory) Download(url, branch, revision, parentdir, dirname string) (*VcsRevision, error) {
+	name, url := splitVcsUrl(url)
+	vcs, ok := LookupVcs(name)
+	if !ok {
+		return nil, fmt.Errorf("%s \"%s\"", ErrUnsupportedVcs, name)
+	}
+
+	dir := path.Join(parentdir, dirname)
+	if err := vcs.Download(f, &VcsSource{url, branch, revision}, dir); err != nil {
+		return nil, err
+	}
+
+	rev, err := vcs.Revision(f, dir)
+	if err != nil {
+		return nil, err
+	}
+	f.job.commits[f.job.repositoryRole(dirname)] = rev.Id
+
+	return rev, nil
+}
@@ -0,0 +1,319 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package slave
+
+import (
+	"fmt"
+	"github.com/hawaii-desktop/builder/logging"
+	"github.com/hawaii-desktop/builder/utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// File touched every time a workspace is used.
+const workspaceStampFile = ".builder-last-used"
+
+// Free space required to accept jobs, when not configured.
+const defaultMinFreeSpace = 5 << 30
+
+// Free space the garbage collection aims for, when not configured.
+const defaultGcFreeSpace = 20 << 30
+
+// How often the free space is checked.
+const diskCheckInterval = time.Minute
+
+// Kind of workspace.
+type WorkspaceKind uint32
+
+const (
+	// Working directory of a target.
+	WORKSPACE_KIND_BUILD WorkspaceKind = iota
+	// Bare mirror of a git repository.
+	WORKSPACE_KIND_GIT_MIRROR
+)
+
+// Workspace kind descriptions.
+var WorkspaceKindDescriptionMap = map[WorkspaceKind]string{
+	WORKSPACE_KIND_BUILD:      "build",
+	WORKSPACE_KIND_GIT_MIRROR: "git-mirror",
+}
+
+// A directory that can be removed to free space.
+type Workspace struct {
+	// Path.
+	Path string
+	// Kind.
+	Kind WorkspaceKind
+	// When it was used for the last time.
+	LastUsed time.Time
+}
+
+// Workspaces sorted by last use, least recently used first.
+type workspacesByLastUse []*Workspace
+
+func (l workspacesByLastUse) Len() int           { return len(l) }
+func (l workspacesByLastUse) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l workspacesByLastUse) Less(i, j int) bool { return l[i].LastUsed.Before(l[j].LastUsed) }
+
+// Parse a size from the configuration, empty means the default value.
+func parseFreeSpace(value string, def uint64) (uint64, error) {
+	if value == "" {
+		return def, nil
+	}
+	return utils.ParseSize(value)
+}
+
+// Check the workspace settings of the configuration.
+func CheckWorkspace() error {
+	min, err := parseFreeSpace(Config.Workspace.MinFreeSpace, defaultMinFreeSpace)
+	if err != nil {
+		return err
+	}
+	gc, err := parseFreeSpace(Config.Workspace.GcFreeSpace, defaultGcFreeSpace)
+	if err != nil {
+		return err
+	}
+	if gc > 0 && gc < min {
+		return fmt.Errorf("GcFreeSpace cannot be less than MinFreeSpace")
+	}
+	return nil
+}
+
+// Return the free space required to accept jobs and the free space
+// the garbage collection aims for, zero means disabled.
+func FreeSpaceWatermarks() (uint64, uint64) {
+	min, err := parseFreeSpace(Config.Workspace.MinFreeSpace, defaultMinFreeSpace)
+	if err != nil {
+		min = defaultMinFreeSpace
+	}
+	gc, err := parseFreeSpace(Config.Workspace.GcFreeSpace, defaultGcFreeSpace)
+	if err != nil {
+		gc = defaultGcFreeSpace
+	}
+	return min, gc
+}
+
+// Return the space available to unprivileged users on the
+// file system of dir.
+func diskFree(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// Return the last time a directory was used, falling back to
+// the modification time when it was never stamped.
+func lastUsed(dir string, stamp string) time.Time {
+	fi, err := os.Stat(path.Join(dir, stamp))
+	if err != nil {
+		if fi, err = os.Stat(dir); err != nil {
+			return time.Time{}
+		}
+	}
+	return fi.ModTime()
+}
+
+// Return the mount points below dir.
+func mountsUnder(dir string) ([]string, error) {
+	data, err := ioutil.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	var mounts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// Spaces and other characters are escaped as octal
+		mountpoint, err := strconv.Unquote(`"` + fields[1] + `"`)
+		if err != nil {
+			mountpoint = fields[1]
+		}
+		if strings.HasPrefix(mountpoint, dir+"/") {
+			mounts = append(mounts, mountpoint)
+		}
+	}
+	return mounts, nil
+}
+
+// Return all the workspaces and git mirrors, least recently used first.
+func ListWorkspaces() ([]*Workspace, error) {
+	var workspaces []*Workspace
+
+	dirs, err := filepath.Glob(path.Join(Config.Directory.WorkDir, "*", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		workspaces = append(workspaces, &Workspace{dir, WORKSPACE_KIND_BUILD, lastUsed(dir, workspaceStampFile)})
+	}
+
+	if gitMirrorsDir() != "" {
+		mirrors, err := filepath.Glob(path.Join(gitMirrorsDir(), "*.git"))
+		if err != nil {
+			return nil, err
+		}
+		for _, mirror := range mirrors {
+			workspaces = append(workspaces, &Workspace{mirror, WORKSPACE_KIND_GIT_MIRROR, lastUsed(mirror, gitMirrorStampFile)})
+		}
+	}
+
+	sort.Sort(workspacesByLastUse(workspaces))
+	return workspaces, nil
+}
+
+// Return the disk usage of the workspace in bytes.
+func (w *Workspace) Size() uint64 {
+	var size uint64
+	filepath.Walk(w.Path, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			size += uint64(fi.Size())
+		}
+		return nil
+	})
+	return size
+}
+
+// Remove the workspace unless it's being used.
+func (w *Workspace) Remove() error {
+	lock, err := lockDirectory(w.Path, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		return err
+	}
+	defer unlockDirectory(lock)
+
+	// Never descend into file systems left mounted by a crashed build
+	abspath, err := filepath.Abs(w.Path)
+	if err != nil {
+		return err
+	}
+	mounts, err := mountsUnder(abspath)
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("%d file system(s) still mounted", len(mounts))
+	}
+
+	if err := os.RemoveAll(w.Path); err != nil {
+		return err
+	}
+	os.Remove(lock.Name())
+	return nil
+}
+
+// Remove the least recently used workspaces and git mirrors until
+// there are at least free bytes available on their file systems.
+// Workspaces being used are skipped.
+// Return the workspaces removed.
+func CollectGarbage(free uint64) ([]*Workspace, error) {
+	workspaces, err := ListWorkspaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []*Workspace
+	for _, w := range workspaces {
+		if available, err := diskFree(w.Path); err != nil || available >= free {
+			continue
+		}
+		if err := w.Remove(); err != nil {
+			logging.Infof("Skipping workspace \"%s\": %s\n", w.Path, err)
+			continue
+		}
+		logging.Infof("Removed workspace \"%s\" last used %s\n", w.Path, w.LastUsed.Format(time.RFC3339))
+		removed = append(removed, w)
+	}
+
+	return removed, nil
+}
+
+// Check whether there is enough free space to accept new jobs,
+// collecting garbage when it's running low.
+// Return whether new jobs can be accepted or the reason why not.
+func CheckDiskSpace() (bool, string) {
+	min, gc := FreeSpaceWatermarks()
+	if min == 0 && gc == 0 {
+		return true, ""
+	}
+
+	dirs := []string{Config.Directory.WorkDir}
+	if Config.Directory.CacheDir != "" {
+		dirs = append(dirs, Config.Directory.CacheDir)
+	}
+
+	// Make room before it's too late
+	for _, dir := range dirs {
+		os.MkdirAll(dir, 0755)
+		if free, err := diskFree(dir); err == nil && free < gc {
+			if _, err := CollectGarbage(gc); err != nil {
+				logging.Errorf("Unable to collect garbage: %s\n", err)
+			}
+			break
+		}
+	}
+
+	for _, dir := range dirs {
+		free, err := diskFree(dir)
+		if err != nil {
+			return false, err.Error()
+		}
+		if free < min {
+			return false, fmt.Sprintf("only %s free in %s, %s required",
+				utils.FormatSize(free), dir, utils.FormatSize(min))
+		}
+	}
+	return true, ""
+}
+
+// Lock a workspace so that it's not removed while building, then
+// create it if needed and mark it as used.
+// The directory is created with the lock held, otherwise the
+// garbage collection could remove it before it's locked.
+func useWorkspace(dir string) *os.File {
+	os.MkdirAll(path.Dir(dir), 0755)
+	lock, err := lockDirectory(dir, syscall.LOCK_SH)
+	if err != nil {
+		logging.Warningf("Unable to lock workspace \"%s\": %s\n", dir, err)
+	}
+	os.MkdirAll(dir, 0755)
+	ioutil.WriteFile(path.Join(dir, workspaceStampFile), []byte(time.Now().Format(time.RFC3339)+"\n"), 0644)
+	return lock
+}
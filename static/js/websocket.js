@@ -30,6 +30,10 @@ var WEB_SOCKET_DISPATCHED_JOBS = 2;
 var WEB_SOCKET_COMPLETED_JOBS = 3;
 var WEB_SOCKET_FAILED_JOBS = 4;
 var WEB_SOCKET_JOB = 5;
+var WEB_SOCKET_JOB_OUTPUT = 6;
+var WEB_SOCKET_PACKAGE_BUILDS = 7;
+var WEB_SOCKET_IMAGES = 8;
+var WEB_SOCKET_SLAVES = 9;
 
 function createWebSocket(address, processFunc) {
     wsConn = new WebSocket(address);
@@ -0,0 +1,73 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size suffixes, powers of 1024.
+var sizeSuffixes = []string{"K", "M", "G", "T"}
+
+// Parse a size in bytes with an optional K, M, G or T suffix
+// (for example: 512M or 4G).
+func ParseSize(s string) (uint64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(value, "B")
+	multiplier := uint64(1)
+	for i, suffix := range sizeSuffixes {
+		if strings.HasSuffix(value, suffix) {
+			value = strings.TrimSuffix(value, suffix)
+			multiplier = uint64(1) << (10 * uint(i+1))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size \"%s\"", s)
+	}
+	return uint64(n * float64(multiplier)), nil
+}
+
+// Format a size in bytes for humans (for example: 1.5G).
+func FormatSize(size uint64) string {
+	value := float64(size)
+	unit := ""
+	for _, suffix := range sizeSuffixes {
+		if value < 1024 {
+			break
+		}
+		value /= 1024
+		unit = suffix
+	}
+	if unit == "" {
+		return fmt.Sprintf("%dB", size)
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + unit
+}
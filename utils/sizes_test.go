@@ -0,0 +1,71 @@
+/****************************************************************************
+ * This file is part of Builder.
+ *
+ * Copyright (C) 2015-2016 Pier Luigi Fiorini
+ *
+ * Author(s):
+ *    Pier Luigi Fiorini <pierluigi.fiorini@gmail.com>
+ *
+ * $BEGIN_LICENSE:AGPL3+$
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * $END_LICENSE$
+ ***************************************************************************/
+
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input string
+		size  uint64
+		valid bool
+	}{
+		{"0", 0, true},
+		{"1024", 1024, true},
+		{"512K", 512 * 1024, true},
+		{"512M", 512 * 1024 * 1024, true},
+		{"4G", 4 * 1024 * 1024 * 1024, true},
+		{"1T", 1024 * 1024 * 1024 * 1024, true},
+		{"1.5G", 3 * 512 * 1024 * 1024, true},
+		{"2g", 2 * 1024 * 1024 * 1024, true},
+		{"2GB", 2 * 1024 * 1024 * 1024, true},
+		{"100B", 100, true},
+		{" 8M ", 8 * 1024 * 1024, true},
+		{"", 0, false},
+		{"M", 0, false},
+		{"-1M", 0, false},
+		{"12X", 0, false},
+		{"lots", 0, false},
+	}
+
+	for _, test := range tests {
+		size, err := ParseSize(test.input)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %d", test.input, size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.input, err)
+		} else if size != test.size {
+			t.Errorf("%q: expected %d, got %d", test.input, test.size, size)
+		}
+	}
+}